@@ -0,0 +1,202 @@
+package rules
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// WindowKind selects how an Accumulator groups reports over time.
+type WindowKind int
+
+const (
+	// SlidingWindow aggregates the reports added during the last Window, up
+	// to and including the bucket of the time of the read (see
+	// AccumulatorOptions.Resolution).
+	SlidingWindow WindowKind = iota
+	// TumblingWindow aggregates the reports of fixed, non-overlapping windows
+	// aligned to multiples of Window (e.g. on the minute for time.Minute):
+	// [start, start+Window).
+	TumblingWindow
+)
+
+// DefaultAccumulatorWindow is the window used when AccumulatorOptions.Window
+// is not positive.
+const DefaultAccumulatorWindow = time.Minute
+
+// DefaultSlidingBuckets is the number of buckets a sliding window spans when
+// AccumulatorOptions.Resolution is not positive.
+const DefaultSlidingBuckets = 10
+
+// AccumulatorOptions configures an Accumulator.
+type AccumulatorOptions struct {
+	// Window is the span of time a snapshot aggregates. It defaults to
+	// DefaultAccumulatorWindow.
+	Window time.Duration
+	// Kind selects sliding or tumbling windows; the default is SlidingWindow.
+	Kind WindowKind
+	// Resolution is the width of the buckets reports are aggregated into,
+	// and so the step a sliding window moves by. It defaults to
+	// Window/DefaultSlidingBuckets and is never more than Window. Tumbling
+	// windows always use one bucket per window.
+	Resolution time.Duration
+	// Retention is how long reports are kept, and so how far back SnapshotAt
+	// can read. It defaults to, and is never less than, Window.
+	Retention time.Duration
+	// Now returns the current time. It defaults to time.Now and is mainly
+	// useful in tests.
+	Now func() time.Time
+}
+
+// WindowSnapshot is the aggregate of the reports of one window.
+type WindowSnapshot struct {
+	// Start and End bound the window: [Start, End), aligned to the buckets.
+	Start, End time.Time
+	// BatchReport merges the reports added during the window, as
+	// MergeReports does.
+	BatchReport
+}
+
+// Accumulator aggregates reports over time, for KMIs such as "rejection rate
+// over the last 5 minutes": reports are added as evaluations complete and
+// snapshots merge those of a window with the kind-specific rules of
+// MergeReports.
+//
+// Reports are not kept: Add merges them into the BatchReport of the bucket
+// of their time, so memory grows with the number of buckets per retention
+// period and the number of series, not with the number of reports. Buckets
+// older than the retention are dropped as new reports are added. Snapshots
+// merge the buckets of a window: averages, ratios, counts, histograms and
+// sketches come out as if every report had been merged at once, while the
+// median and custom aggregations combine the results of the buckets.
+//
+// An Accumulator is safe for concurrent use, and snapshots are consistent:
+// each one reads the reports added before it started.
+//
+// Example:
+//
+//	acc := rules.NewAccumulator(rules.AccumulatorOptions{Window: 5 * time.Minute})
+//	report, _ := rules.EvaluateMetricsWithData(ctx, tree, hooks, "checkout", order)
+//	acc.Add(report)
+//
+//	snap := acc.Snapshot()
+//	rejectionRate := 1 - float64(snap.ValidTargets)/float64(snap.Targets)
+type Accumulator struct {
+	window     time.Duration
+	resolution time.Duration
+	retention  time.Duration
+	now        func() time.Time
+
+	mu      sync.RWMutex
+	buckets []accumulatorBucket // sorted by start
+}
+
+// accumulatorBucket aggregates the reports added in [start, start+resolution).
+type accumulatorBucket struct {
+	start time.Time
+	batch BatchReport
+}
+
+// NewAccumulator creates an empty accumulator.
+func NewAccumulator(opts AccumulatorOptions) *Accumulator {
+	window := opts.Window
+	if window <= 0 {
+		window = DefaultAccumulatorWindow
+	}
+	resolution := opts.Resolution
+	switch {
+	case opts.Kind == TumblingWindow:
+		resolution = window
+	case resolution <= 0:
+		resolution = max(window/DefaultSlidingBuckets, 1)
+	default:
+		resolution = min(resolution, window)
+	}
+	now := opts.Now
+	if now == nil {
+		now = time.Now
+	}
+	return &Accumulator{
+		window:     window,
+		resolution: resolution,
+		retention:  max(opts.Retention, window),
+		now:        now,
+	}
+}
+
+// Add adds reports at the current time.
+func (a *Accumulator) Add(reports ...Report) {
+	a.AddAt(a.now(), reports...)
+}
+
+// AddAt adds reports at the given time, e.g. the time stored reports were
+// produced at. Reports older than the retention are ignored.
+func (a *Accumulator) AddAt(at time.Time, reports ...Report) {
+	if len(reports) == 0 {
+		return
+	}
+	batch := MergeReports(reports...)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	cutoff := a.now().Add(-a.retention)
+	if !at.After(cutoff) {
+		return
+	}
+	start := at.Truncate(a.resolution)
+	i, found := slices.BinarySearchFunc(a.buckets, start, func(b accumulatorBucket, t time.Time) int {
+		return b.start.Compare(t)
+	})
+	if found {
+		a.buckets[i].batch.merge(batch)
+	} else {
+		a.buckets = slices.Insert(a.buckets, i, accumulatorBucket{start: start, batch: batch})
+	}
+
+	expired := 0
+	for expired < len(a.buckets) && a.expired(a.buckets[expired], cutoff) {
+		expired++
+	}
+	clear(a.buckets[:expired])
+	a.buckets = a.buckets[expired:]
+}
+
+// expired reports whether every report of bucket is older than cutoff.
+func (a *Accumulator) expired(bucket accumulatorBucket, cutoff time.Time) bool {
+	return !bucket.start.Add(a.resolution).After(cutoff)
+}
+
+// Snapshot returns the aggregate of the current window: the last Window for
+// sliding windows, and the window in progress for tumbling windows.
+func (a *Accumulator) Snapshot() WindowSnapshot {
+	return a.SnapshotAt(a.now())
+}
+
+// SnapshotAt returns the aggregate of the window ending with the bucket of t
+// for sliding windows, or containing t for tumbling windows. Windows
+// reaching back beyond the retention only hold the reports still retained.
+func (a *Accumulator) SnapshotAt(t time.Time) WindowSnapshot {
+	end := t.Truncate(a.resolution).Add(a.resolution)
+	snap := WindowSnapshot{Start: end.Add(-a.window), End: end}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	cutoff := a.now().Add(-a.retention)
+	from, _ := slices.BinarySearchFunc(a.buckets, snap.Start, func(b accumulatorBucket, t time.Time) int {
+		return b.start.Compare(t)
+	})
+	for _, bucket := range a.buckets[from:] {
+		if !bucket.start.Before(snap.End) {
+			break
+		}
+		if !a.expired(bucket, cutoff) {
+			snap.merge(bucket.batch)
+		}
+	}
+	if snap.Metrics == nil {
+		snap.BatchReport = MergeReports()
+	}
+	return snap
+}
@@ -0,0 +1,178 @@
+package rules
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// windowReport returns a report of one evaluation with a "rejected" KindValid
+// metric, a "latency" histogram and a "risk" score.
+func windowReport(approved bool, latency, risk float64) Report {
+	h := NewHistogram([]float64{10, 100})
+	h.Observe(latency)
+	report := Report{Valid: approved, Metrics: map[string]Outcome{
+		"approved": {Kind: KindValid, Name: "approved", Valid: approved},
+		"latency":  {Kind: KindHistogram, Name: "latency", Histogram: h},
+		"risk":     {Kind: KindScore, Name: "risk", Score: risk},
+	}}
+	if !approved {
+		report.Errors = []error{Error{Field: "order", Err: "rejected", Code: "REJECTED"}}
+	}
+	return report
+}
+
+func TestAccumulator_SlidingWindow(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	acc := NewAccumulator(AccumulatorOptions{
+		Window:     5 * time.Minute,
+		Resolution: 30 * time.Second,
+		Retention:  10 * time.Minute,
+		Now:        clock.Now,
+	})
+
+	acc.Add(windowReport(true, 5, 0.2), windowReport(false, 50, 0.8))
+	clock.Advance(3 * time.Minute)
+	acc.Add(windowReport(true, 500, 0.5))
+
+	snap := acc.Snapshot()
+	if snap.Targets != 3 || snap.ValidTargets != 2 {
+		t.Errorf("targets = %d, valid = %d, want 3 and 2", snap.Targets, snap.ValidTargets)
+	}
+	if got := snap.Metrics["approved"].Score; math.Abs(got-2.0/3) > 1e-9 {
+		t.Errorf("approval rate = %v, want 2/3", got)
+	}
+	if h := snap.Metrics["latency"].Histogram; h.Total != 3 || h.Counts[0] != 1 || h.Counts[1] != 2 {
+		t.Errorf("latency = %+v", h)
+	}
+	if got := snap.Metrics["risk"].Score; math.Abs(got-0.5) > 1e-9 {
+		t.Errorf("risk = %v, want 0.5", got)
+	}
+	// The window ends with the 30s bucket of the read.
+	end := clock.Now().Add(30 * time.Second)
+	if !snap.Start.Equal(end.Add(-5*time.Minute)) || !snap.End.Equal(end) {
+		t.Errorf("window = [%v, %v)", snap.Start, snap.End)
+	}
+
+	// The first two reports leave the window 5 minutes after being added,
+	// but stay readable within the retention.
+	clock.Advance(2 * time.Minute)
+	if snap := acc.Snapshot(); snap.Targets != 1 || !snap.Valid() {
+		t.Errorf("after 5 minutes: targets = %d, valid = %d, want 1 valid", snap.Targets, snap.ValidTargets)
+	}
+	if past := acc.SnapshotAt(start.Add(4 * time.Minute)); past.Targets != 3 {
+		t.Errorf("past window: targets = %d, want 3", past.Targets)
+	}
+
+	// Beyond the retention the reports are dropped.
+	clock.Advance(9 * time.Minute)
+	acc.Add(windowReport(false, 1, 1))
+	if past := acc.SnapshotAt(start.Add(4 * time.Minute)); past.Targets != 0 {
+		t.Errorf("expired window: targets = %d, want 0", past.Targets)
+	}
+	if n := len(acc.buckets); n != 1 {
+		t.Errorf("retained %d buckets, want 1", n)
+	}
+}
+
+func TestAccumulator_Buckets(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	acc := NewAccumulator(AccumulatorOptions{Window: time.Minute, Now: clock.Now})
+
+	// One report per second for ten minutes: memory is bounded by the
+	// buckets of the retention, not by the number of reports.
+	for range 600 {
+		acc.Add(windowReport(true, 5, 0.5))
+		clock.Advance(time.Second)
+	}
+	if n := len(acc.buckets); n > DefaultSlidingBuckets+1 {
+		t.Errorf("retained %d buckets, want at most %d", n, DefaultSlidingBuckets+1)
+	}
+
+	// Scores are weighted by the reports of each bucket, not averaged per
+	// bucket: (0.2 + 0.4 + 1.0) / 3, not (0.3 + 1.0) / 2.
+	acc = NewAccumulator(AccumulatorOptions{Window: time.Minute, Now: clock.Now})
+	acc.Add(windowReport(true, 5, 0.2), windowReport(true, 5, 0.4))
+	clock.Advance(10 * time.Second)
+	acc.Add(windowReport(true, 5, 1.0))
+	snap := acc.Snapshot()
+	if got := snap.Metrics["risk"]; math.Abs(got.Score-1.6/3) > 1e-9 || got.Weight != 3 {
+		t.Errorf("risk = %v with weight %v, want 0.5333 with weight 3", got.Score, got.Weight)
+	}
+	if snap.Targets != 3 {
+		t.Errorf("targets = %d, want 3", snap.Targets)
+	}
+}
+
+func TestAccumulator_TumblingWindow(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2026, 10, 18, 12, 0, 30, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	acc := NewAccumulator(AccumulatorOptions{Window: time.Minute, Kind: TumblingWindow, Retention: time.Hour, Now: clock.Now})
+
+	acc.Add(windowReport(false, 5, 0.9))
+	clock.Advance(29 * time.Second) // 12:00:59
+	acc.Add(windowReport(true, 5, 0.1))
+	clock.Advance(time.Second) // 12:01:00 opens a new window
+	acc.Add(windowReport(true, 5, 0.3))
+	// A late report is filed under the window of its own time.
+	acc.AddAt(start.Add(-10*time.Second), windowReport(true, 5, 0.2))
+
+	current := acc.Snapshot()
+	if current.Targets != 1 || !current.Start.Equal(clock.Now()) || !current.End.Equal(clock.Now().Add(time.Minute)) {
+		t.Errorf("current window [%v, %v) has %d targets, want 1", current.Start, current.End, current.Targets)
+	}
+	previous := acc.SnapshotAt(start)
+	if previous.Targets != 3 || previous.ValidTargets != 2 {
+		t.Errorf("previous window: targets = %d, valid = %d, want 3 and 2", previous.Targets, previous.ValidTargets)
+	}
+	if got := previous.Metrics["risk"].Score; math.Abs(got-0.4) > 1e-9 {
+		t.Errorf("previous risk = %v, want 0.4", got)
+	}
+
+	// Reports older than the retention are not added.
+	acc.AddAt(start.Add(-2*time.Hour), windowReport(true, 5, 0))
+	if past := acc.SnapshotAt(start.Add(-2 * time.Hour)); past.Targets != 0 {
+		t.Errorf("expired report was added")
+	}
+}
+
+func TestAccumulator_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	acc := NewAccumulator(AccumulatorOptions{Window: time.Hour})
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				acc.Add(windowReport(i%2 == 0, 5, 0.5))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				snap := acc.Snapshot()
+				if snap.Targets > 0 && snap.Metrics["latency"].Histogram.Total != uint64(snap.Targets) {
+					t.Errorf("inconsistent snapshot: %d targets, %d observations",
+						snap.Targets, snap.Metrics["latency"].Histogram.Total)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if snap := acc.Snapshot(); snap.Targets != 800 || snap.ValidTargets != 400 {
+		t.Errorf("targets = %d, valid = %d, want 800 and 400", snap.Targets, snap.ValidTargets)
+	}
+}
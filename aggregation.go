@@ -0,0 +1,126 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrAggregationRegistered is returned by RegisterAggregation when the name
+// is already taken by a built-in or registered aggregation.
+var ErrAggregationRegistered = errors.New("rules: aggregation already registered")
+
+// AggregationFunc combines the outcomes of a series into one. The group is
+// never empty, holds outcomes of the same name, labels and kind, in emission
+// order, and must not be modified.
+type AggregationFunc func(group []Outcome) Outcome
+
+// aggregationRegistry holds the aggregations registered with
+// RegisterAggregation.
+var aggregationRegistry = struct {
+	mu     sync.RWMutex
+	funcs  map[Aggregation]AggregationFunc
+	names  map[Aggregation]string
+	byName map[string]Aggregation
+}{
+	funcs:  make(map[Aggregation]AggregationFunc),
+	names:  make(map[Aggregation]string),
+	byName: make(map[string]Aggregation),
+}
+
+// RegisterAggregation registers a custom aggregation and returns the value
+// outcomes reference it with in their Aggregation field. Reports and batch
+// reports then combine the series with fn, keeping the Kind, Name, Field,
+// Labels and Aggregation of the first outcome and joining the errors of the
+// group into Err, so fn only computes the value. fn also runs for a series
+// of a single outcome.
+//
+// The name identifies the aggregation in the JSON encoding of reports, so a
+// process decoding reports must register the same names. Registering a name
+// twice, or the name of a built-in aggregation, fails with
+// ErrAggregationRegistered. Aggregations are registered for the lifetime of
+// the process, typically from package-level variables or init.
+//
+// The returned value depends on the order of registration, so it may differ
+// between processes and builds: never persist or exchange it as a number,
+// only through its name (Aggregation.String, or the JSON encoding).
+//
+// Example:
+//
+//	var aggP90, _ = rules.RegisterAggregation("p90", func(group []rules.Outcome) rules.Outcome {
+//	    s := rules.NewSummary(0)
+//	    for _, o := range group {
+//	        s.Observe(o.Score)
+//	    }
+//	    return rules.Outcome{Score: s.Quantile(0.9)}
+//	})
+//
+//	o := rules.ScoreValue(risk, 1)
+//	o.Aggregation = aggP90
+func RegisterAggregation(name string, fn AggregationFunc) (Aggregation, error) {
+	if name == "" || fn == nil {
+		return AggNone, errors.New("rules: aggregation needs a name and a function")
+	}
+	if _, ok := builtinAggregation(name); ok {
+		return AggNone, fmt.Errorf("%w: %q is built in", ErrAggregationRegistered, name)
+	}
+
+	reg := &aggregationRegistry
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if _, ok := reg.byName[name]; ok {
+		return AggNone, fmt.Errorf("%w: %q", ErrAggregationRegistered, name)
+	}
+	agg := AggAllTrue + 1 + Aggregation(len(reg.funcs))
+	reg.funcs[agg] = fn
+	reg.names[agg] = name
+	reg.byName[name] = agg
+	return agg, nil
+}
+
+// registeredAggregationFunc returns the function of a registered aggregation.
+func registeredAggregationFunc(agg Aggregation) (AggregationFunc, bool) {
+	if agg <= AggAllTrue {
+		return nil, false
+	}
+	reg := &aggregationRegistry
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	fn, ok := reg.funcs[agg]
+	return fn, ok
+}
+
+// registeredAggregationName returns the name of a registered aggregation.
+func registeredAggregationName(agg Aggregation) (string, bool) {
+	reg := &aggregationRegistry
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	name, ok := reg.names[agg]
+	return name, ok
+}
+
+// builtinAggregation returns the built-in aggregation named s.
+func builtinAggregation(s string) (Aggregation, bool) {
+	for a := AggNone; a <= AggAllTrue; a++ {
+		if a.String() == s {
+			return a, true
+		}
+	}
+	return AggNone, false
+}
+
+// parseAggregation returns the built-in or registered aggregation named s;
+// the empty string is AggNone.
+func parseAggregation(s string) (Aggregation, bool) {
+	if s == "" {
+		return AggNone, true
+	}
+	if a, ok := builtinAggregation(s); ok {
+		return a, true
+	}
+	reg := &aggregationRegistry
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	a, ok := reg.byName[s]
+	return a, ok
+}
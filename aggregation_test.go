@@ -0,0 +1,196 @@
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+// aggRange is a custom aggregation keeping the spread of the scores.
+var aggRange, _ = RegisterAggregation("test_range", func(group []Outcome) Outcome {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, o := range group {
+		lo, hi = min(lo, o.Score), max(hi, o.Score)
+	}
+	return Outcome{Score: hi - lo}
+})
+
+// emitAll returns a tree whose rule emits every outcome under the name
+// "metric" with the aggregation agg.
+func emitAll(agg Aggregation, outcomes ...Outcome) Evaluable {
+	return Rules(NewTypedRule("emit", func(ctx context.Context, _ string) error {
+		for _, o := range outcomes {
+			o.Name, o.Aggregation = "metric", agg
+			Emit(ctx, o)
+		}
+		return nil
+	}))
+}
+
+func TestAggregations(t *testing.T) {
+	t.Parallel()
+
+	valid := func(v ...bool) []Outcome {
+		outcomes := make([]Outcome, len(v))
+		for i, b := range v {
+			outcomes[i] = ValidValue(b, nil)
+		}
+		return outcomes
+	}
+	scores := []Outcome{ScoreValue(0.9, 1), ScoreValue(0.1, 5), ScoreValue(0.4, 1), ScoreValue(0.3, 1)}
+
+	testCases := []struct {
+		testName string
+		agg      Aggregation
+		outcomes []Outcome
+		check    func(o Outcome) bool
+	}{
+		{
+			testName: "median score of even group",
+			agg:      AggMedian,
+			outcomes: scores,
+			check:    func(o Outcome) bool { return math.Abs(o.Score-0.35) < 1e-9 },
+		},
+		{
+			testName: "median counter",
+			agg:      AggMedian,
+			outcomes: []Outcome{CounterValue(7), CounterValue(1), CounterValue(100)},
+			check:    func(o Outcome) bool { return o.Count == 7 },
+		},
+		{
+			testName: "median gauge",
+			agg:      AggMedian,
+			outcomes: []Outcome{{Kind: KindGauge, Value: 3}, {Kind: KindGauge, Value: -1}, {Kind: KindGauge, Value: 2}},
+			check:    func(o Outcome) bool { return o.Value == 2 },
+		},
+		{
+			testName: "count scores",
+			agg:      AggCount,
+			outcomes: scores,
+			check:    func(o Outcome) bool { return o.Score == 4 },
+		},
+		{
+			testName: "count single counter",
+			agg:      AggCount,
+			outcomes: []Outcome{CounterValue(250)},
+			check:    func(o Outcome) bool { return o.Count == 1 },
+		},
+		{
+			testName: "count valid",
+			agg:      AggCount,
+			outcomes: valid(true, false, true),
+			check:    func(o Outcome) bool { return o.Count == 3 && !o.Valid },
+		},
+		{
+			testName: "count single valid",
+			agg:      AggCount,
+			outcomes: valid(false),
+			check:    func(o Outcome) bool { return o.Count == 1 && !o.Valid },
+		},
+		{
+			testName: "any true",
+			agg:      AggAnyTrue,
+			outcomes: valid(false, true, false),
+			check:    func(o Outcome) bool { return o.Valid },
+		},
+		{
+			testName: "any true none valid",
+			agg:      AggAnyTrue,
+			outcomes: valid(false, false),
+			check:    func(o Outcome) bool { return !o.Valid },
+		},
+		{
+			testName: "max valid is any true",
+			agg:      AggMax,
+			outcomes: valid(false, true),
+			check:    func(o Outcome) bool { return o.Valid },
+		},
+		{
+			testName: "all true",
+			agg:      AggAllTrue,
+			outcomes: valid(true, false, true),
+			check:    func(o Outcome) bool { return !o.Valid },
+		},
+		{
+			testName: "min valid is all true",
+			agg:      AggMin,
+			outcomes: valid(true, true),
+			check:    func(o Outcome) bool { return o.Valid },
+		},
+		{
+			testName: "default valid is all true",
+			agg:      AggNone,
+			outcomes: valid(true, false),
+			check:    func(o Outcome) bool { return !o.Valid },
+		},
+		{
+			testName: "last valid",
+			agg:      AggLast,
+			outcomes: valid(false, true),
+			check:    func(o Outcome) bool { return o.Valid },
+		},
+		{
+			testName: "custom",
+			agg:      aggRange,
+			outcomes: scores,
+			check:    func(o Outcome) bool { return math.Abs(o.Score-0.8) < 1e-9 && o.Kind == KindScore && o.Name == "metric" },
+		},
+		{
+			testName: "custom single outcome",
+			agg:      aggRange,
+			outcomes: scores[:1],
+			check:    func(o Outcome) bool { return o.Score == 0 },
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			t.Parallel()
+
+			report, err := EvaluateMetricsWithData(context.Background(), emitAll(tc.agg, tc.outcomes...), ProcessingHooks{}, "agg", "data")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := report.Metrics["metric"]; !tc.check(got) {
+				t.Errorf("%s aggregated to %+v", tc.agg, got)
+			}
+		})
+	}
+}
+
+func TestRegisterAggregation(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"test_range", "median", "none"} {
+		if _, err := RegisterAggregation(name, func(group []Outcome) Outcome { return group[0] }); !errors.Is(err, ErrAggregationRegistered) {
+			t.Errorf("registering %q: error = %v, want ErrAggregationRegistered", name, err)
+		}
+	}
+	if _, err := RegisterAggregation("", nil); err == nil {
+		t.Error("registering an unnamed aggregation succeeded")
+	}
+	if got := aggRange.String(); got != "test_range" {
+		t.Errorf("String = %q, want test_range", got)
+	}
+
+	// Custom aggregations are encoded by name and carry across MergeReports.
+	report, err := EvaluateMetricsWithData(context.Background(), emitAll(aggRange, ScoreValue(0.2, 1), ScoreValue(0.5, 1)),
+		ProcessingHooks{}, "agg", "data")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, _ := json.Marshal(report)
+	var decoded Report
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if got := decoded.Metrics["metric"].Aggregation; got != aggRange {
+		t.Errorf("decoded aggregation = %v, want %v", got, aggRange)
+	}
+	other := report
+	other.Metrics = map[string]Outcome{"metric": {Kind: KindScore, Name: "metric", Score: 1, Aggregation: aggRange}}
+	if got := MergeReports(decoded, other).Metrics["metric"].Score; math.Abs(got-0.7) > 1e-9 {
+		t.Errorf("merged range = %v, want 0.7", got)
+	}
+}
@@ -0,0 +1,146 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// BatchReport summarizes the reports of a batch, e.g. those returned by
+// EvaluateMetricsMulti.
+type BatchReport struct {
+	// Targets is the number of merged reports.
+	Targets int
+	// ValidTargets is the number of merged reports that were valid.
+	ValidTargets int
+	// Metrics holds every metric aggregated across the reports, keyed by
+	// series (see SeriesKey): the metric name, plus its labels if any. Each
+	// metric is combined with the aggregation of its outcome in the first
+	// report carrying it, as within a report: counters are summed, scores
+	// weight-averaged by their summed weights and histograms merged. KindValid
+	// metrics are valid when valid in every report, and their Score holds the
+	// ratio of reports in which they were valid.
+	Metrics map[string]Outcome
+	// MetricTargets counts, per series key, the reports that carried the
+	// metric. Targets whose rule did not run (e.g. gated by a condition) are
+	// not counted, so averages are over the targets that reported a value.
+	MetricTargets map[string]int
+}
+
+// Valid reports whether every merged report was valid.
+func (b BatchReport) Valid() bool {
+	return b.ValidTargets == b.Targets
+}
+
+// MergeReports rolls per-target reports up into a BatchReport, applying the
+// kind-aware aggregations used within a report across the targets. The
+// reports are not modified. An outcome whose kind differs from the first one
+// reported under the same name is left out, and an error naming it is joined
+// into the merged outcome's Err.
+//
+// Example:
+//
+//	reports, _ := rules.EvaluateMetricsMulti(ctx, targets, hooks, "nightly")
+//	batch := rules.MergeReports(reports...)
+//	risk := batch.Metrics["risk"].Score       // average risk across the batch
+//	scored := batch.MetricTargets["risk"]     // targets that reported a risk
+func MergeReports(reports ...Report) BatchReport {
+	batch := BatchReport{
+		Targets:       len(reports),
+		Metrics:       make(map[string]Outcome),
+		MetricTargets: make(map[string]int),
+	}
+
+	groups := make(map[string][]Outcome)
+	mismatches := make(map[string][]error)
+	for i, report := range reports {
+		if report.Valid {
+			batch.ValidTargets++
+		}
+		for _, key := range slices.Sorted(maps.Keys(report.Metrics)) {
+			o := report.Metrics[key]
+			if group := groups[key]; len(group) > 0 && group[0].Kind != o.Kind {
+				mismatches[key] = append(mismatches[key],
+					fmt.Errorf("metric %q: report %d carries a %s, want a %s", key, i, o.Kind, group[0].Kind))
+				continue
+			}
+			groups[key] = append(groups[key], o)
+		}
+	}
+
+	for key, group := range groups {
+		merged := finalizeGroup(group)
+		if merged.Kind == KindValid {
+			valid := 0
+			for _, o := range group {
+				if o.Valid {
+					valid++
+				}
+			}
+			merged.Score = float64(valid) / float64(len(group))
+		}
+		if errs := mismatches[key]; len(errs) > 0 {
+			merged.Err = errors.Join(append([]error{merged.Err}, errs...)...)
+		}
+		batch.Metrics[key] = merged
+		batch.MetricTargets[key] = len(group)
+	}
+	return batch
+}
+
+// merge adds the reports summarized by other to b, as if MergeReports had
+// been given the reports of both. Averages and valid ratios are weighted by
+// the number of reports behind each side, counts add up, and the other
+// aggregations combine as they do within a report; the median and custom
+// aggregations, which cannot be rebuilt from partial results, combine the
+// two results.
+func (b *BatchReport) merge(other BatchReport) {
+	b.Targets += other.Targets
+	b.ValidTargets += other.ValidTargets
+	if b.Metrics == nil {
+		b.Metrics = make(map[string]Outcome, len(other.Metrics))
+		b.MetricTargets = make(map[string]int, len(other.Metrics))
+	}
+	for key, o := range other.Metrics {
+		n := other.MetricTargets[key]
+		current, ok := b.Metrics[key]
+		switch {
+		case !ok:
+			b.Metrics[key] = o
+			b.MetricTargets[key] = n
+		case current.Kind != o.Kind:
+			current.Err = errors.Join(current.Err, fmt.Errorf("metric %q: merged batch carries a %s, want a %s", key, o.Kind, current.Kind))
+			b.Metrics[key] = current
+		default:
+			m := b.MetricTargets[key]
+			b.Metrics[key] = mergeSummarized(current, m, o, n)
+			b.MetricTargets[key] = m + n
+		}
+	}
+}
+
+// mergeSummarized combines two outcomes of one series that MergeReports
+// aggregated over m and n reports.
+func mergeSummarized(a Outcome, m int, b Outcome, n int) Outcome {
+	merged := finalizeGroup([]Outcome{a, b})
+	weighted := func(x, y float64) float64 {
+		return (x*float64(m) + y*float64(n)) / float64(m+n)
+	}
+	agg := a.Aggregation
+	if agg == AggNone {
+		agg = defaultAggregation(a.Kind)
+	}
+	switch {
+	case a.Kind == KindValid:
+		merged.Score = weighted(a.Score, b.Score)
+		if agg == AggCount {
+			merged.Count = a.Count + b.Count
+		}
+	case agg == AggCount:
+		merged = withNumericValue(merged, numericValue(a)+numericValue(b))
+	case agg == AggAvg && a.Kind != KindScore:
+		merged = withNumericValue(merged, weighted(numericValue(a), numericValue(b)))
+	}
+	return merged
+}
@@ -0,0 +1,117 @@
+package rules
+
+import (
+	"context"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestMergeReports(t *testing.T) {
+	t.Parallel()
+
+	type account struct {
+		Balance float64
+		Risk    float64
+	}
+	latency := func(v float64) Histogram {
+		h := NewHistogram([]float64{10, 100})
+		h.Observe(v)
+		return h
+	}
+	// Only scored accounts emit a risk.
+	risk := NewTypedRule("risk", func(ctx context.Context, a account) error {
+		if a.Risk > 0 {
+			o := ScoreValue(a.Risk, 1)
+			o.Name = "risk"
+			Emit(ctx, o)
+		}
+		return nil
+	})
+	tree := Rules(
+		NewTypedMetricRule("balance", KindCounter, "", func(ctx context.Context, a account) (Outcome, error) {
+			return CounterValue(a.Balance), nil
+		}),
+		NewTypedMetricRule("funded", KindValid, "", func(ctx context.Context, a account) (Outcome, error) {
+			return ValidValue(a.Balance > 0, nil), nil
+		}),
+		NewTypedMetricRule("latency", KindHistogram, "", func(ctx context.Context, a account) (Outcome, error) {
+			return HistogramValue(latency(a.Balance)), nil
+		}),
+		risk,
+	)
+
+	accounts := []account{{Balance: 5, Risk: 0.2}, {Balance: 50, Risk: 0.6}, {Balance: 0}}
+	targets := make([]TreeAndData, len(accounts))
+	for i, a := range accounts {
+		targets[i] = TreeAndData{Tree: tree, Data: a}
+	}
+	reports, err := EvaluateMetricsMultiWithData(context.Background(), targets, ProcessingHooks{}, "nightly")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	before := slices.Clone(reports[0].Metrics["latency"].Histogram.Counts)
+
+	batch := MergeReports(reports...)
+	if batch.Targets != 3 || batch.ValidTargets != 3 || !batch.Valid() {
+		t.Errorf("targets = %d, valid = %d", batch.Targets, batch.ValidTargets)
+	}
+	if got := batch.Metrics["balance"].Count; got != 55 {
+		t.Errorf("balance = %v, want 55", got)
+	}
+	if got := batch.Metrics["risk"].Score; math.Abs(got-0.4) > 1e-9 || batch.MetricTargets["risk"] != 2 {
+		t.Errorf("risk = %v over %d targets, want 0.4 over 2", got, batch.MetricTargets["risk"])
+	}
+	funded := batch.Metrics["funded"]
+	if funded.Valid || math.Abs(funded.Score-2.0/3) > 1e-9 || batch.MetricTargets["funded"] != 3 {
+		t.Errorf("funded = %v with ratio %v", funded.Valid, funded.Score)
+	}
+	h := batch.Metrics["latency"].Histogram
+	if !slices.Equal(h.Counts, []uint64{2, 3}) || h.Total != 3 || h.Sum != 55 {
+		t.Errorf("latency = %+v", h)
+	}
+	if !slices.Equal(reports[0].Metrics["latency"].Histogram.Counts, before) {
+		t.Error("MergeReports must not modify the reports")
+	}
+}
+
+func TestMergeReports_KindMismatch(t *testing.T) {
+	t.Parallel()
+
+	batch := MergeReports(
+		Report{Valid: true, Metrics: map[string]Outcome{"x": CounterValue(1)}},
+		Report{Valid: false, Metrics: map[string]Outcome{"x": ScoreValue(3, 1)}},
+		Report{Valid: true, Metrics: map[string]Outcome{"x": CounterValue(2)}},
+	)
+	x := batch.Metrics["x"]
+	if x.Count != 3 || batch.MetricTargets["x"] != 2 {
+		t.Errorf("x = %v over %d targets, want 3 over 2", x.Count, batch.MetricTargets["x"])
+	}
+	if x.Err == nil || batch.Valid() {
+		t.Errorf("err = %v, valid = %v", x.Err, batch.Valid())
+	}
+	if batch := MergeReports(); batch.Targets != 0 || !batch.Valid() || len(batch.Metrics) != 0 {
+		t.Errorf("empty merge = %+v", batch)
+	}
+}
+
+func TestMergeReports_ScoreWeights(t *testing.T) {
+	t.Parallel()
+
+	// Three scores in the first report, one in the second: the merged score
+	// weighs the first report's average by the three scores it holds.
+	first, err := EvaluateMetricsWithData(context.Background(),
+		emitAll(AggWeightedAvg, ScoreValue(0.2, 1), ScoreValue(0.4, 1), ScoreValue(0.6, 1)), ProcessingHooks{}, "risk", "a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := first.Metrics["metric"]; math.Abs(got.Score-0.4) > 1e-9 || got.Weight != 3 {
+		t.Errorf("report score = %v with weight %v, want 0.4 with weight 3", got.Score, got.Weight)
+	}
+	second := Report{Valid: true, Metrics: map[string]Outcome{"metric": {Kind: KindScore, Name: "metric", Score: 1, Weight: 1}}}
+
+	merged := MergeReports(first, second).Metrics["metric"]
+	if math.Abs(merged.Score-0.55) > 1e-9 || merged.Weight != 4 {
+		t.Errorf("merged score = %v with weight %v, want 0.55 with weight 4", merged.Score, merged.Weight)
+	}
+}
@@ -0,0 +1,267 @@
+// Command rulesgen generates reflection-free condition and rule constructors
+// for annotated structs.
+//
+// Annotate a struct with a //rules:generate comment and run rulesgen from
+// go generate in the same package:
+//
+//	//go:generate go run github.com/mishudark/rules/cmd/rulesgen
+//
+//	//rules:generate
+//	type User struct {
+//	    Age     int    `json:"age"`
+//	    Country string `json:"country"`
+//	}
+//
+// For every exported field whose type is a predeclared basic type, rulesgen
+// emits typed constructors built on rules.NewTypedCondition and
+// rules.NewTypedRule:
+//
+//	UserAgeGTE(18)            // rules.Condition named "User.Age >= 18"
+//	UserCountryIs("US")       // rules.Condition named "User.Country == US"
+//	RequireUserAgeGTE(18)     // rules.Rule failing with VALUE_LOWER_MIN on "age"
+//
+// Numeric fields get Is, GT, GTE, LT and LTE; string and bool fields get Is.
+// The generated code reads fields directly, so evaluating it does no
+// reflection, and a renamed or retyped field is a compile error.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// annotation marks a struct for generation.
+const annotation = "//rules:generate"
+
+func main() {
+	dir := flag.String("dir", ".", "package directory to scan")
+	output := flag.String("output", "rules_gen.go", "output file name, relative to -dir")
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("rulesgen: ")
+
+	src, err := generateDir(*dir, *output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if src == nil {
+		log.Fatalf("no struct annotated with %s in %s", annotation, *dir)
+	}
+	if err := os.WriteFile(filepath.Join(*dir, *output), src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// generateDir parses the non-test Go files of dir, skipping the output file,
+// and returns the generated source, or nil when no struct is annotated.
+func generateDir(dir, output string) ([]byte, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	fset := token.NewFileSet()
+	var pkg string
+	var files []*ast.File
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") || name == output {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		if pkg != "" && file.Name.Name != pkg {
+			return nil, fmt.Errorf("found packages %s and %s in %s", pkg, file.Name.Name, dir)
+		}
+		pkg = file.Name.Name
+		files = append(files, file)
+	}
+
+	return generate(pkg, files)
+}
+
+// structDef is an annotated struct and its supported fields.
+type structDef struct {
+	name   string
+	fields []fieldDef
+}
+
+// fieldDef is a generated field.
+type fieldDef struct {
+	name     string // Go field name
+	jsonName string // error Field
+	typ      string // predeclared type name
+}
+
+// generate returns the formatted source for the annotated structs in files,
+// or nil when there are none.
+func generate(pkg string, files []*ast.File) ([]byte, error) {
+	var defs []structDef
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				ts := spec.(*ast.TypeSpec)
+				st, ok := ts.Type.(*ast.StructType)
+				if !ok || ts.TypeParams != nil || !annotated(gen.Doc, ts.Doc) {
+					continue
+				}
+				defs = append(defs, structDef{name: ts.Name.Name, fields: fields(st)})
+			}
+		}
+	}
+	if len(defs) == 0 {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by rulesgen; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+	fmt.Fprintf(&buf, "import (\n\t\"context\"\n\t\"fmt\"\n\n\t\"github.com/mishudark/rules\"\n)\n")
+
+	for _, def := range defs {
+		for _, field := range def.fields {
+			writeField(&buf, def.name, field)
+		}
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated source: %w", err)
+	}
+	return src, nil
+}
+
+// annotated reports whether either doc comment carries the annotation.
+func annotated(groups ...*ast.CommentGroup) bool {
+	for _, group := range groups {
+		if group == nil {
+			continue
+		}
+		for _, c := range group.List {
+			if strings.TrimSpace(c.Text) == annotation {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fields returns the exported fields of st whose type is supported.
+func fields(st *ast.StructType) []fieldDef {
+	var defs []fieldDef
+	for _, field := range st.Fields.List {
+		ident, ok := field.Type.(*ast.Ident)
+		if !ok || kindOf(ident.Name) == kindUnsupported {
+			continue
+		}
+
+		var tag reflect.StructTag
+		if field.Tag != nil {
+			if unquoted, err := strconv.Unquote(field.Tag.Value); err == nil {
+				tag = reflect.StructTag(unquoted)
+			}
+		}
+
+		for _, name := range field.Names {
+			if !name.IsExported() {
+				continue
+			}
+			jsonName, _, _ := strings.Cut(tag.Get("json"), ",")
+			if jsonName == "-" {
+				continue
+			}
+			if jsonName == "" {
+				jsonName = name.Name
+			}
+			defs = append(defs, fieldDef{name: name.Name, jsonName: jsonName, typ: ident.Name})
+		}
+	}
+	return defs
+}
+
+// fieldKind groups the predeclared types by the operators generated for them.
+type fieldKind int
+
+const (
+	kindUnsupported fieldKind = iota
+	kindComparable
+	kindOrdered
+)
+
+// kindOf classifies a predeclared type name.
+func kindOf(typ string) fieldKind {
+	switch typ {
+	case "int", "int8", "int16", "int32", "int64",
+		"uint", "uint8", "uint16", "uint32", "uint64", "uintptr",
+		"float32", "float64", "byte", "rune":
+		return kindOrdered
+	case "string", "bool":
+		return kindComparable
+	default:
+		return kindUnsupported
+	}
+}
+
+// operator is a generated comparison.
+type operator struct {
+	suffix string // constructor suffix
+	op     string // Go operator
+	code   string // error code of the generated rule
+	msg    string // error message verb of the generated rule
+}
+
+var (
+	equality  = []operator{{"Is", "==", "VALUE_NOT_EQUAL", "must be equal to"}}
+	orderings = []operator{
+		{"GT", ">", "VALUE_NOT_GREATER", "must be greater than"},
+		{"GTE", ">=", "VALUE_LOWER_MIN", "must be greater than or equal to"},
+		{"LT", "<", "VALUE_NOT_LESS", "must be less than"},
+		{"LTE", "<=", "VALUE_EXCEEDS_MAX", "must be less than or equal to"},
+	}
+)
+
+// writeField writes the constructors for one field.
+func writeField(buf *bytes.Buffer, typeName string, field fieldDef) {
+	ops := equality
+	if kindOf(field.typ) == kindOrdered {
+		ops = append(slices.Clip(equality), orderings...)
+	}
+
+	for _, op := range ops {
+		fn := typeName + field.name + op.suffix
+		label := fmt.Sprintf("%s.%s %s %%v", typeName, field.name, op.op)
+
+		fmt.Fprintf(buf, "\n// %s returns a condition that is true when %s.%s %s v.\n", fn, typeName, field.name, op.op)
+		fmt.Fprintf(buf, "func %s(v %s) rules.Condition {\n", fn, field.typ)
+		fmt.Fprintf(buf, "\treturn rules.NewTypedCondition[%s](fmt.Sprintf(%q, v), func(_ context.Context, data %s) bool {\n", typeName, label, typeName)
+		fmt.Fprintf(buf, "\t\treturn data.%s %s v\n\t})\n}\n", field.name, op.op)
+
+		fmt.Fprintf(buf, "\n// Require%s returns a rule that fails with %s unless %s.%s %s v.\n", fn, op.code, typeName, field.name, op.op)
+		fmt.Fprintf(buf, "func Require%s(v %s) rules.Rule {\n", fn, field.typ)
+		fmt.Fprintf(buf, "\treturn rules.NewTypedRule[%s](fmt.Sprintf(%q, v), func(_ context.Context, data %s) error {\n", typeName, label, typeName)
+		fmt.Fprintf(buf, "\t\tif data.%s %s v {\n\t\t\treturn nil\n\t\t}\n", field.name, op.op)
+		fmt.Fprintf(buf, "\t\treturn rules.Error{\n")
+		fmt.Fprintf(buf, "\t\t\tField: %q,\n", field.jsonName)
+		fmt.Fprintf(buf, "\t\t\tErr:   fmt.Sprintf(\"Value (%%v) %s %%v\", data.%s, v),\n", op.msg, field.name)
+		fmt.Fprintf(buf, "\t\t\tCode:  %q,\n\t\t}\n\t})\n}\n", op.code)
+	}
+}
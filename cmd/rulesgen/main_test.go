@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+func TestGenerateDir_Golden(t *testing.T) {
+	dir := filepath.Join("testdata", "user")
+	got, err := generateDir(dir, "rules_gen.go")
+	if err != nil {
+		t.Fatalf("generateDir: %v", err)
+	}
+
+	golden := filepath.Join(dir, "rules_gen.go.golden")
+	if *update {
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("generated source differs from %s; run go test -update to refresh\n%s", golden, got)
+	}
+}
+
+// TestGenerateDir_Compiles type-checks the generated code against the rules
+// module of this tree, by vetting the testdata package in a module of its own.
+func TestGenerateDir_Compiles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a module")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+	root, err := filepath.Abs(filepath.Join("..", ".."))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := filepath.Join("testdata", "user")
+	generated, err := generateDir(src, "rules_gen.go")
+	if err != nil {
+		t.Fatalf("generateDir: %v", err)
+	}
+	user, err := os.ReadFile(filepath.Join(src, "user.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	goMod := "module example.com/user\n\ngo 1.26\n\n" +
+		"require github.com/mishudark/rules v0.0.0\n\n" +
+		"replace github.com/mishudark/rules => " + root + "\n"
+
+	dir := t.TempDir()
+	for name, content := range map[string][]byte{
+		"go.mod":       []byte(goMod),
+		"user.go":      user,
+		"rules_gen.go": generated,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(goTool, "vet", "./...")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOWORK=off", "GOPROXY=off")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("generated code does not compile: %v\n%s", err, out)
+	}
+}
+
+func TestGenerateDir_NoAnnotation(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "plain.go"), []byte("package plain\n\ntype Plain struct{ A int }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := generateDir(dir, "rules_gen.go")
+	if err != nil {
+		t.Fatalf("generateDir: %v", err)
+	}
+	if got != nil {
+		t.Errorf("expected no output, got:\n%s", got)
+	}
+}
@@ -0,0 +1,18 @@
+package user
+
+// User is annotated for generation.
+//
+//rules:generate
+type User struct {
+	Age      int    `json:"age"`
+	Country  string `json:"country,omitempty"`
+	Verified bool
+	Balance  float64 `json:"-"`
+	Tags     []string
+	internal int
+}
+
+// Account is not annotated, so nothing is generated for it.
+type Account struct {
+	ID string
+}
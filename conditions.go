@@ -12,6 +12,7 @@ type ConditionFunc struct {
 	name      string
 	predicate func(ctx context.Context) bool
 	pure      bool
+	params    []any // parameters the predicate was built with, see Parameterized
 }
 
 // Prepare implements Condition interface. It's a no-op for pure conditions.
@@ -37,7 +38,16 @@ func (c *ConditionFunc) IsPure() bool {
 	return c.pure
 }
 
-var _ Condition = (*ConditionFunc)(nil)
+// Params returns the parameters the built-in constructors bound into the
+// predicate, such as the field name of HasField. It is nil for NewCondition.
+func (c *ConditionFunc) Params() []any {
+	return c.params
+}
+
+var (
+	_ Condition     = (*ConditionFunc)(nil)
+	_ Parameterized = (*ConditionFunc)(nil)
+)
 
 // NewCondition creates a condition with a predicate that has access to context data.
 // This is the primary way to create data-driven conditions for reusable rule trees.
@@ -75,7 +85,8 @@ func (c *typeChecker) IsValid(ctx context.Context) bool {
 	}
 	return reflect.TypeOf(data) == c.targetType
 }
-func (c *typeChecker) IsPure() bool { return true }
+func (c *typeChecker) IsPure() bool  { return true }
+func (c *typeChecker) Params() []any { return []any{c.targetType} }
 
 var _ Condition = (*typeChecker)(nil)
 
@@ -126,7 +137,8 @@ func (c *assignableChecker) IsValid(ctx context.Context) bool {
 	}
 	return reflect.TypeOf(data).AssignableTo(c.targetType)
 }
-func (c *assignableChecker) IsPure() bool { return true }
+func (c *assignableChecker) IsPure() bool  { return true }
+func (c *assignableChecker) Params() []any { return []any{c.targetType} }
 
 var _ Condition = (*assignableChecker)(nil)
 
@@ -308,7 +320,8 @@ func HasField(name string, fieldName string) Condition {
 				return false
 			}
 		},
-		pure: true,
+		pure:   true,
+		params: []any{fieldName},
 	}
 }
 
@@ -353,7 +366,8 @@ func FieldEquals(name string, fieldName string, expected any) Condition {
 
 			return reflect.DeepEqual(fieldValue.Interface(), expected)
 		},
-		pure: true,
+		pure:   true,
+		params: []any{fieldName, expected},
 	}
 }
 
@@ -374,6 +388,9 @@ type TypedConditionWithPrepare[In any, T any] struct {
 
 var _ Condition = (*TypedConditionWithPrepare[any, any])(nil)
 
+// preparedType returns T, the type of the data recorded by Prepare.
+func (c *TypedConditionWithPrepare[In, T]) preparedType() reflect.Type { return reflect.TypeFor[T]() }
+
 // Prepare retrieves typed input data from context, loads additional data, and
 // records it in the per-evaluation preparedStore keyed by this condition
 // instance.
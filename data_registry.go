@@ -2,7 +2,14 @@ package rules
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
 	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
 )
 
 type registryKey struct{}
@@ -10,28 +17,347 @@ type registryKey struct{}
 // DataRegistry holds validation data as any (interface{}).
 // It enables tree reuse by separating rule definitions from data binding.
 //
-// A registry holds a single payload. Rules that need more than one input
-// value should load the additional data in their Prepare step (see
-// NewTypedRuleWithPrepare and NewTypedConditionWithPrepare) or compose the
-// payload into one struct.
+// A registry holds a default payload, read with Get and GetAs, and any number
+// of named slots, written with Set and read with Lookup or GetKey. Slots let
+// one evaluation see the subject, the acting principal, tenant configuration
+// and request metadata side by side without composing them into one struct.
+//
+// The payload and the slots may also be computed on first use: see
+// NewLazyDataRegistry and SetComputed.
 type DataRegistry struct {
-	data any
+	data  any
+	slots map[string]any
 }
 
-// NewDataRegistry creates a registry with the provided data.
-// The data can be any type and is accessed at validation time via Get/GetAs.
+// NewDataRegistry creates a registry with the provided data as its default
+// payload. The data can be any type and is accessed at validation time via
+// Get/GetAs.
 func NewDataRegistry(data any) *DataRegistry {
 	return &DataRegistry{data: data}
 }
 
+// NewLazyDataRegistry creates a registry whose default payload is produced by
+// provider on first access, so trees that only need the expensive parts of a
+// payload in some branches don't pay for it in the others. provider runs
+// once, with the context of the first reader; concurrent readers wait for it
+// and share its result.
+//
+// When provider fails the payload reads as missing: Get and GetAs return
+// false, and typed rules report a TYPE_MISMATCH. Err returns the error. A
+// panic in provider is turned into that error. A cancellation or deadline
+// error of the reader's context is not kept: the next reader calls provider
+// again.
+//
+// Example:
+//
+//	reg := rules.NewLazyDataRegistry(func(ctx context.Context) (any, error) {
+//	    return orders.Load(ctx, orderID)
+//	})
+//	err := rules.Validate(rules.WithRegistry(ctx, reg), tree, hooks, "checkout")
+//	if loadErr := reg.Err(); loadErr != nil {
+//	    return loadErr
+//	}
+func NewLazyDataRegistry(provider func(ctx context.Context) (any, error)) *DataRegistry {
+	return &DataRegistry{data: &lazyValue{compute: provider}}
+}
+
+// ErrComputeCycle is the error of a lazy payload or computed slot whose
+// computation reads itself, directly or through other computed values.
+var ErrComputeCycle = errors.New("rules: computed registry value reads itself")
+
+// lazyValue is a registry value computed on first access and memoised.
+// Cancellation and deadline errors belong to the reader whose context they
+// come from and are not memoised: the next reader computes again.
+type lazyValue struct {
+	mu      sync.Mutex
+	done    atomic.Bool
+	call    *lazyCall // the computation in progress, if any
+	compute func(ctx context.Context) (any, error)
+	value   any
+	err     error
+}
+
+// lazyCall is a computation of a lazyValue shared by concurrent readers.
+type lazyCall struct {
+	done  chan struct{}
+	value any
+	err   error
+	cycle bool // the computation read its own value
+}
+
+// computingKey is the context key of the lazy values being computed by the
+// chain of computations a context belongs to.
+type computingKey struct{}
+
+// computingFrame is a lazy value being computed, linked to the computation
+// that read it.
+type computingFrame struct {
+	value  *lazyValue
+	call   *lazyCall
+	parent *computingFrame
+}
+
+// get returns the value, computing it with ctx on first use. Readers wait
+// for the computation in progress, without holding a lock, and give up when
+// their ctx is done. A computation reading its own value gets
+// ErrComputeCycle, which then becomes its result as well.
+func (l *lazyValue) get(ctx context.Context) (any, error) {
+	frames, _ := ctx.Value(computingKey{}).(*computingFrame)
+	for {
+		if l.done.Load() {
+			return l.value, l.err
+		}
+		for f := frames; f != nil; f = f.parent {
+			if f.value == l {
+				l.mu.Lock()
+				f.call.cycle = true
+				l.mu.Unlock()
+				return nil, ErrComputeCycle
+			}
+		}
+
+		l.mu.Lock()
+		if l.done.Load() {
+			l.mu.Unlock()
+			return l.value, l.err
+		}
+		call := l.call
+		if call == nil {
+			break
+		}
+		l.mu.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if !isContextError(call.err) {
+			return call.value, call.err
+		}
+	}
+
+	call := &lazyCall{done: make(chan struct{})}
+	l.call = call
+	l.mu.Unlock()
+
+	frame := &computingFrame{value: l, call: call, parent: frames}
+	value, err := l.run(context.WithValue(ctx, computingKey{}, frame))
+
+	l.mu.Lock()
+	if call.cycle && !errors.Is(err, ErrComputeCycle) {
+		value, err = nil, errors.Join(ErrComputeCycle, err)
+	}
+	call.value, call.err = value, err
+	l.call = nil
+	if !isContextError(err) {
+		l.value, l.err = value, err
+		l.done.Store(true)
+	}
+	l.mu.Unlock()
+	close(call.done)
+	return value, err
+}
+
+// run calls compute, turning a panic into an error.
+func (l *lazyValue) run(ctx context.Context) (value any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			value, err = nil, fmt.Errorf("rules: computing a registry value panicked: %v", r)
+		}
+	}()
+	return l.compute(ctx)
+}
+
+// failure returns the error of a value that has been computed, without
+// computing it.
+func (l *lazyValue) failure() error {
+	if !l.done.Load() {
+		return nil
+	}
+	return l.err
+}
+
+// Key identifies a typed slot in a DataRegistry. Keys are compared by name,
+// so declare each one once, typically as a package-level variable, and share
+// it between the code that fills the registry and the rules that read it.
+//
+// The zero Key (empty name) is the default slot: the payload passed to
+// NewDataRegistry and returned by Get.
+type Key[T any] struct {
+	name string
+}
+
+// NewKey returns the key of the slot called name holding values of type T.
+//
+// Example:
+//
+//	var Principal = rules.NewKey[Account]("principal")
+func NewKey[T any](name string) Key[T] {
+	return Key[T]{name: name}
+}
+
+// Name returns the slot name, which is empty for the default slot.
+func (k Key[T]) Name() string {
+	return k.name
+}
+
+// Set stores value in the slot identified by key. Setting the default key
+// replaces the default payload. Fill the registry before evaluating: Set must
+// not run concurrently with an evaluation reading the same registry.
+//
+// Example:
+//
+//	reg := rules.NewDataRegistry(order)
+//	rules.Set(reg, Principal, account)
+//	rules.Set(reg, Tenant, tenantConfig)
+//	err := rules.Validate(rules.WithRegistry(ctx, reg), tree, hooks, "checkout")
+func Set[T any](reg *DataRegistry, key Key[T], value T) {
+	if key.name == "" {
+		reg.data = value
+		return
+	}
+	if reg.slots == nil {
+		reg.slots = make(map[string]any)
+	}
+	reg.slots[key.name] = value
+}
+
+// Lookup returns the value stored in the slot identified by key. The boolean
+// is false when the slot is empty or holds a value that is not of type T.
+func Lookup[T any](reg *DataRegistry, key Key[T]) (T, bool) {
+	return lookup(nil, reg, key)
+}
+
+// lookup is Lookup with the context used to compute lazy values.
+func lookup[T any](ctx context.Context, reg *DataRegistry, key Key[T]) (T, bool) {
+	var zero T
+	data, ok := reg.slot(ctx, key.name)
+	if !ok {
+		return zero, false
+	}
+	if raw, isRecorded := data.(recordedSlot); isRecorded {
+		var typed T
+		if err := json.Unmarshal(raw, &typed); err != nil {
+			return zero, false
+		}
+		return typed, true
+	}
+	typed, ok := data.(T)
+	return typed, ok
+}
+
+// recordedSlot is a slot restored from a Cassette, decoded on each read into
+// the type of the key.
+type recordedSlot json.RawMessage
+
+// slot returns the value of the named slot; the empty name is the default
+// payload, which is always present unless it is lazy and failed. Lazy values
+// are computed with ctx, or with a context carrying only the registry when
+// ctx is nil.
+func (r *DataRegistry) slot(ctx context.Context, name string) (any, bool) {
+	data, ok := r.data, true
+	if name != "" {
+		data, ok = r.slots[name]
+	}
+	lazy, isLazy := data.(*lazyValue)
+	if !ok || !isLazy {
+		return data, ok
+	}
+
+	if ctx == nil {
+		ctx = WithRegistry(context.Background(), r)
+	}
+	data, err := lazy.get(ctx)
+	return data, err == nil
+}
+
+// SetComputed stores a slot whose value is derived on first read by compute
+// and memoised, such as "account age in days". compute runs once, with the
+// context of the first reader, which carries the registry: it may read the
+// payload and other slots, but not its own slot: a computation that reads
+// it, directly or through other computed slots, fails with ErrComputeCycle.
+// Concurrent readers wait for it, or for their context to be done, and share
+// its result; when it fails the slot reads as empty and Err returns the
+// error. As with NewLazyDataRegistry, a panic is kept as an
+// error and context errors are not kept. Setting the default key makes the
+// payload computed.
+//
+// Example:
+//
+//	var AccountAgeDays = rules.NewKey[int]("accountAgeDays")
+//
+//	rules.SetComputed(reg, AccountAgeDays, func(ctx context.Context) (int, error) {
+//	    user, _ := rules.GetAs[User](ctx)
+//	    return int(time.Since(user.CreatedAt).Hours() / 24), nil
+//	})
+func SetComputed[T any](reg *DataRegistry, key Key[T], compute func(ctx context.Context) (T, error)) {
+	lazy := &lazyValue{compute: func(ctx context.Context) (any, error) {
+		return compute(ctx)
+	}}
+	if key.name == "" {
+		reg.data = lazy
+		return
+	}
+	if reg.slots == nil {
+		reg.slots = make(map[string]any)
+	}
+	reg.slots[key.name] = lazy
+}
+
+// Err returns the errors of the lazy payload and computed slots that have
+// failed so far, joined, or nil. Values not read yet are not computed.
+func (r *DataRegistry) Err() error {
+	var errs []error
+	if lazy, ok := r.data.(*lazyValue); ok {
+		if err := lazy.failure(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(r.slots)) {
+		if lazy, ok := r.slots[name].(*lazyValue); ok {
+			if err := lazy.failure(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// registryFromContext returns the DataRegistry attached to ctx, or nil.
+func registryFromContext(ctx context.Context) *DataRegistry {
+	reg, _ := ctx.Value(registryKey{}).(*DataRegistry)
+	return reg
+}
+
+// GetKey retrieves the typed value of the slot identified by key from the
+// registry in ctx. It is the keyed counterpart of GetAs.
+//
+// Example:
+//
+//	rule := rules.NewTypedRule[Order]("ownOrder", func(ctx context.Context, order Order) error {
+//	    account, ok := rules.GetKey(ctx, Principal)
+//	    if !ok || account.ID != order.OwnerID {
+//	        return rules.Error{Field: "owner", Err: "not your order", Code: "FORBIDDEN"}
+//	    }
+//	    return nil
+//	})
+func GetKey[T any](ctx context.Context, key Key[T]) (T, bool) {
+	reg := registryFromContext(ctx)
+	if reg == nil {
+		var zero T
+		return zero, false
+	}
+	return lookup(ctx, reg, key)
+}
+
 // Get retrieves the raw data from context.
 // Returns the data and a boolean indicating if data was found.
 func Get(ctx context.Context) (any, bool) {
-	reg, ok := ctx.Value(registryKey{}).(*DataRegistry)
-	if !ok {
+	reg := registryFromContext(ctx)
+	if reg == nil {
 		return nil, false
 	}
-	return reg.data, true
+	return reg.slot(ctx, "")
 }
 
 // GetAs retrieves typed data from context with runtime type assertion.
@@ -152,12 +478,10 @@ func TypeOf(ctx context.Context) reflect.Type {
 	return reflect.TypeOf(data)
 }
 
-type preparedStoreKey struct{}
-
 // preparedStore holds the data that rules and conditions retrieve during their
-// Prepare step, keyed by the rule or condition instance. It is created by the
-// engine once per evaluation (once per target in multi-target runs) and travels
-// in the context.
+// Prepare step, keyed by the rule or condition instance. It is part of the
+// engine's per-target state (see targetState), created once per evaluation
+// (once per target in multi-target runs), and travels in the context.
 //
 // Per-evaluation scoping is what makes Prepare-based rules and conditions safe
 // to share across goroutines: the data a rule reads back in Validate comes from
@@ -184,20 +508,25 @@ func (s *preparedStore) put(key any, value any) {
 	if s == nil {
 		return
 	}
+	if s.data == nil {
+		s.data = make(map[any]any)
+	}
 	s.data[key] = value
 }
 
-// withPreparedStore returns a context carrying a fresh preparedStore and the
-// store itself.
+// withPreparedStore returns a context carrying a fresh target state, outside
+// any run, and its preparedStore.
 func withPreparedStore(ctx context.Context) (context.Context, *preparedStore) {
-	store := &preparedStore{data: make(map[any]any)}
-	return context.WithValue(ctx, preparedStoreKey{}, store), store
+	state := &targetState{}
+	return context.WithValue(ctx, targetStateKey{}, state), &state.store
 }
 
 // preparedStoreFromContext returns the preparedStore attached to ctx, or nil.
 func preparedStoreFromContext(ctx context.Context) *preparedStore {
-	store, _ := ctx.Value(preparedStoreKey{}).(*preparedStore)
-	return store
+	if state := targetStateFromContext(ctx); state != nil {
+		return &state.store
+	}
+	return nil
 }
 
 // recordPrepared stores data keyed by key in the per-evaluation preparedStore.
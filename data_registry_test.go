@@ -2,9 +2,13 @@ package rules
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type testUser struct {
@@ -669,3 +673,358 @@ func TestValidateMultiWithData_NoPreparedDataCrossTalk(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestDataRegistry_Slots(t *testing.T) {
+	t.Parallel()
+
+	principal := NewKey[testUser]("principal")
+	tenant := NewKey[string]("tenant")
+
+	reg := NewDataRegistry(testProduct{ID: 1})
+	Set(reg, principal, testUser{Name: "Alice"})
+
+	if user, ok := Lookup(reg, principal); !ok || user.Name != "Alice" {
+		t.Errorf("Lookup(principal) = %v, %v", user, ok)
+	}
+	if _, ok := Lookup(reg, tenant); ok {
+		t.Error("expected empty slot")
+	}
+	if _, ok := Lookup(reg, NewKey[int]("principal")); ok {
+		t.Error("expected type mismatch on a slot of another type")
+	}
+
+	ctx := WithRegistry(context.Background(), reg)
+	if product, ok := GetAs[testProduct](ctx); !ok || product.ID != 1 {
+		t.Error("default payload should be untouched by named slots")
+	}
+	if user, ok := GetKey(ctx, principal); !ok || user.Name != "Alice" {
+		t.Errorf("GetKey(principal) = %v, %v", user, ok)
+	}
+	if _, ok := GetKey(context.Background(), principal); ok {
+		t.Error("expected no data without a registry")
+	}
+
+	// The zero key is the default slot.
+	Set(reg, Key[testProduct]{}, testProduct{ID: 2})
+	if product, ok := GetAs[testProduct](ctx); !ok || product.ID != 2 {
+		t.Errorf("default payload = %v, want ID 2", product)
+	}
+}
+
+func TestTypedRuleWithKey(t *testing.T) {
+	t.Parallel()
+
+	principal := NewKey[testUser]("principal")
+	tenant := NewKey[string]("tenant")
+
+	tree := Node(
+		NewTypedConditionWithKey("isEnterprise", tenant, func(ctx context.Context, plan string) bool {
+			return plan == "enterprise"
+		}),
+		Rules(
+			NewTypedRuleWithKey("isAdult", principal, func(ctx context.Context, user testUser) error {
+				if user.Age < 18 {
+					return Error{Field: "principal", Err: "must be an adult", Code: "UNDERAGE"}
+				}
+				return nil
+			}),
+			NewTypedRule("hasPrice", func(ctx context.Context, product testProduct) error {
+				if product.Price <= 0 {
+					return Error{Field: "price", Err: "must be positive", Code: "NO_PRICE"}
+				}
+				return nil
+			}),
+		),
+	)
+
+	testCases := []struct {
+		testName string
+		fill     func(reg *DataRegistry)
+		wantCode string
+	}{
+		{
+			testName: "all slots valid",
+			fill: func(reg *DataRegistry) {
+				Set(reg, tenant, "enterprise")
+				Set(reg, principal, testUser{Age: 30})
+			},
+		},
+		{
+			testName: "condition reads its slot",
+			fill: func(reg *DataRegistry) {
+				Set(reg, tenant, "free")
+				Set(reg, principal, testUser{Age: 12})
+			},
+		},
+		{
+			testName: "rule reads its slot",
+			fill: func(reg *DataRegistry) {
+				Set(reg, tenant, "enterprise")
+				Set(reg, principal, testUser{Age: 12})
+			},
+			wantCode: "UNDERAGE",
+		},
+		{
+			testName: "empty slot",
+			fill:     func(reg *DataRegistry) { Set(reg, tenant, "enterprise") },
+			wantCode: ErrorCodeTypeMismatch,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			t.Parallel()
+
+			reg := NewDataRegistry(testProduct{Price: 10})
+			tc.fill(reg)
+			err := Validate(WithRegistry(context.Background(), reg), tree, ProcessingHooks{}, "checkout")
+
+			var re Error
+			switch {
+			case tc.wantCode == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tc.wantCode != "" && (!errors.As(err, &re) || re.Code != tc.wantCode):
+				t.Errorf("error = %v, want code %s", err, tc.wantCode)
+			}
+		})
+	}
+}
+
+func TestLazyDataRegistry(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int64
+	newReg := func(user testUser, err error) *DataRegistry {
+		return NewLazyDataRegistry(func(ctx context.Context) (any, error) {
+			calls.Add(1)
+			return user, err
+		})
+	}
+	adult := NewTypedRule("adult", func(ctx context.Context, u testUser) error {
+		if u.Age < 18 {
+			return Error{Field: "age", Err: "must be an adult", Code: "MINOR"}
+		}
+		return nil
+	})
+	tree := Node(NewConditionPure("never", func() bool { return false }), Rules(adult))
+
+	t.Run("not loaded when unused", func(t *testing.T) {
+		reg := newReg(testUser{Age: 30}, nil)
+		if err := Validate(WithRegistry(context.Background(), reg), tree, ProcessingHooks{}, "lazy"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if calls.Load() != 0 {
+			t.Errorf("provider called %d times for an untaken branch", calls.Load())
+		}
+	})
+
+	t.Run("loaded once", func(t *testing.T) {
+		calls.Store(0)
+		reg := newReg(testUser{Age: 12}, nil)
+		ctx := WithRegistry(context.Background(), reg)
+		var wg sync.WaitGroup
+		for range 8 {
+			wg.Go(func() {
+				if u, ok := GetAs[testUser](ctx); !ok || u.Age != 12 {
+					t.Errorf("GetAs = %v, %v", u, ok)
+				}
+			})
+		}
+		wg.Wait()
+		err := Validate(ctx, Rules(adult), ProcessingHooks{}, "lazy")
+		var re Error
+		if !errors.As(err, &re) || re.Code != "MINOR" {
+			t.Errorf("expected MINOR, got %v", err)
+		}
+		if calls.Load() != 1 {
+			t.Errorf("provider calls = %d, want 1", calls.Load())
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		loadErr := errors.New("order service down")
+		reg := newReg(testUser{}, loadErr)
+		if reg.Err() != nil {
+			t.Error("Err must not load the payload")
+		}
+		err := Validate(WithRegistry(context.Background(), reg), Rules(adult), ProcessingHooks{}, "lazy")
+		var re Error
+		if !errors.As(err, &re) || re.Code != ErrorCodeTypeMismatch {
+			t.Errorf("expected TYPE_MISMATCH, got %v", err)
+		}
+		if !errors.Is(reg.Err(), loadErr) {
+			t.Errorf("Err = %v, want %v", reg.Err(), loadErr)
+		}
+	})
+}
+
+func TestLazyDataRegistry_ContextErrorsAndPanics(t *testing.T) {
+	t.Parallel()
+
+	t.Run("canceled reader does not poison the payload", func(t *testing.T) {
+		t.Parallel()
+
+		var calls atomic.Int64
+		reg := NewLazyDataRegistry(func(ctx context.Context) (any, error) {
+			calls.Add(1)
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			return testUser{Age: 30}, nil
+		})
+		canceled, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, ok := GetAs[testUser](WithRegistry(canceled, reg)); ok {
+			t.Error("canceled reader got the payload")
+		}
+		if reg.Err() != nil {
+			t.Errorf("Err = %v, want nil after a canceled read", reg.Err())
+		}
+		if u, ok := GetAs[testUser](WithRegistry(context.Background(), reg)); !ok || u.Age != 30 {
+			t.Errorf("GetAs = %v, %v after a canceled read", u, ok)
+		}
+		if calls.Load() != 2 {
+			t.Errorf("provider calls = %d, want 2", calls.Load())
+		}
+	})
+
+	t.Run("panic is kept as an error", func(t *testing.T) {
+		t.Parallel()
+
+		slot := NewKey[int]("exploding")
+		reg := NewDataRegistry(testUser{})
+		SetComputed(reg, slot, func(ctx context.Context) (int, error) { panic("boom") })
+		for range 2 {
+			if _, ok := Lookup(reg, slot); ok {
+				t.Error("a panicking computed slot should read as empty")
+			}
+		}
+		if err := reg.Err(); err == nil || !strings.Contains(err.Error(), "boom") {
+			t.Errorf("Err = %v, want the panic", err)
+		}
+	})
+}
+
+func TestSetComputed(t *testing.T) {
+	t.Parallel()
+
+	ageInMonths := NewKey[int]("ageInMonths")
+	broken := NewKey[string]("broken")
+	computeErr := errors.New("cannot derive")
+
+	var calls atomic.Int64
+	reg := NewDataRegistry(testUser{Age: 3})
+	SetComputed(reg, ageInMonths, func(ctx context.Context) (int, error) {
+		calls.Add(1)
+		u, _ := GetAs[testUser](ctx)
+		return u.Age * 12, nil
+	})
+	SetComputed(reg, broken, func(ctx context.Context) (string, error) {
+		return "", computeErr
+	})
+
+	rule := func(name string) Rule {
+		return NewTypedRuleWithKey(name, ageInMonths, func(ctx context.Context, months int) error {
+			if months < 48 {
+				return Error{Field: "age", Err: "too young", Code: "YOUNG"}
+			}
+			return nil
+		})
+	}
+	err := Validate(WithRegistry(context.Background(), reg), Rules(rule("a"), rule("b")), ProcessingHooks{}, "computed")
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 2 {
+		t.Errorf("got %d errors, want 2: %v", n, err)
+	}
+	if months, ok := Lookup(reg, ageInMonths); !ok || months != 36 {
+		t.Errorf("Lookup = %d, %v", months, ok)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("compute calls = %d, want 1", calls.Load())
+	}
+
+	if _, ok := Lookup(reg, broken); ok {
+		t.Error("a failed computed slot should read as empty")
+	}
+	if !errors.Is(reg.Err(), computeErr) {
+		t.Errorf("Err = %v, want %v", reg.Err(), computeErr)
+	}
+}
+
+func TestSetComputed_Cycles(t *testing.T) {
+	t.Parallel()
+
+	t.Run("self", func(t *testing.T) {
+		t.Parallel()
+
+		self := NewKey[int]("self")
+		reg := NewDataRegistry(testUser{})
+		SetComputed(reg, self, func(ctx context.Context) (int, error) {
+			n, _ := GetKey(ctx, self)
+			return n + 1, nil
+		})
+		if _, ok := Lookup(reg, self); ok {
+			t.Error("a slot reading itself should read as empty")
+		}
+		if !errors.Is(reg.Err(), ErrComputeCycle) {
+			t.Errorf("Err = %v, want %v", reg.Err(), ErrComputeCycle)
+		}
+	})
+
+	t.Run("mutual", func(t *testing.T) {
+		t.Parallel()
+
+		a, b := NewKey[int]("a"), NewKey[int]("b")
+		reg := NewDataRegistry(testUser{})
+		SetComputed(reg, a, func(ctx context.Context) (int, error) {
+			n, _ := GetKey(ctx, b)
+			return n + 1, nil
+		})
+		SetComputed(reg, b, func(ctx context.Context) (int, error) {
+			n, ok := GetKey(ctx, a)
+			if !ok {
+				return 0, errors.New("a is missing")
+			}
+			return n + 1, nil
+		})
+		if _, ok := Lookup(reg, a); ok {
+			t.Error("mutually reading slots should read as empty")
+		}
+		if !errors.Is(reg.Err(), ErrComputeCycle) {
+			t.Errorf("Err = %v, want %v", reg.Err(), ErrComputeCycle)
+		}
+	})
+}
+
+func TestSetComputed_WaitersHonourContext(t *testing.T) {
+	t.Parallel()
+
+	slow := NewKey[int]("slow")
+	started, release := make(chan struct{}), make(chan struct{})
+	reg := NewDataRegistry(testUser{})
+	SetComputed(reg, slow, func(ctx context.Context) (int, error) {
+		close(started)
+		<-release
+		return 7, nil
+	})
+
+	first := make(chan int)
+	go func() {
+		n, _ := Lookup(reg, slow)
+		first <- n
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(WithRegistry(context.Background(), reg), 10*time.Millisecond)
+	defer cancel()
+	if _, ok := GetKey(ctx, slow); ok {
+		t.Error("a waiter whose context is done should give up")
+	}
+
+	close(release)
+	if n := <-first; n != 7 {
+		t.Errorf("first reader got %d, want 7", n)
+	}
+	if n, ok := Lookup(reg, slow); !ok || n != 7 {
+		t.Errorf("Lookup = %d, %v after the computation", n, ok)
+	}
+}
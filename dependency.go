@@ -0,0 +1,112 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+)
+
+// Dependency is a named prepare step shared by several rules and conditions.
+// The preparedStore is keyed by rule or condition instance, so three rules
+// that each load "the user's account" would fetch it three times; declaring
+// the fetch once as a Dependency makes the engine resolve it once per target
+// and hand the same typed value to every consumer.
+//
+// In is the input type read from the data registry and T the resolved type.
+// Like rules, a Dependency keeps no state: the resolved value is recorded in
+// the per-evaluation preparedStore keyed by the Dependency itself, so
+// GetPreparedAs[T](ctx, dep) reads it back and a tree using it can be shared
+// across goroutines.
+//
+// Example:
+//
+//	var account = rules.NewDependency("account", func(ctx context.Context, u User) (Account, error) {
+//	    return db.Account(ctx, u.AccountID)
+//	})
+//
+//	tree := rules.Rules(
+//	    rules.NewTypedRuleWithPrepare("accountActive", account.Prepare,
+//	        func(ctx context.Context, u User, a Account) error { return checkActive(a) }),
+//	    rules.NewTypedRuleWithPrepare("accountInGoodStanding", account.Prepare,
+//	        func(ctx context.Context, u User, a Account) error { return checkStanding(a) }),
+//	)
+type Dependency[In any, T any] struct {
+	name    string
+	resolve func(ctx context.Context, input In) (T, error)
+}
+
+// dependencyErrKey keys the memoised error of a dependency in the
+// preparedStore, next to its value keyed by the dependency itself.
+type dependencyErrKey struct {
+	dep any
+}
+
+// NewDependency creates a Dependency resolved by resolve.
+func NewDependency[In any, T any](name string, resolve func(ctx context.Context, input In) (T, error)) *Dependency[In, T] {
+	return &Dependency[In, T]{name: name, resolve: resolve}
+}
+
+// Name returns the dependency name.
+func (d *Dependency[In, T]) Name() string {
+	return d.name
+}
+
+// Prepare returns the value of the dependency for the current target,
+// resolving it on first use and memoising the value (or the error) for the
+// rest of the evaluation. Its signature matches the prepare function of
+// NewTypedRuleWithPrepare and NewTypedConditionWithPrepare, so it can be
+// passed to them directly.
+//
+// Outside the engine (no preparedStore in ctx) nothing is memoised and every
+// call resolves again.
+func (d *Dependency[In, T]) Prepare(ctx context.Context, input In) (T, error) {
+	store := preparedStoreFromContext(ctx)
+	if value, ok := store.get(d); ok {
+		typed, _ := value.(T)
+		return typed, nil
+	}
+	if err, ok := store.get(dependencyErrKey{d}); ok {
+		var zero T
+		return zero, err.(error)
+	}
+
+	if d.resolve == nil {
+		var zero T
+		return zero, Error{
+			Field: d.name,
+			Err:   "dependency function is nil",
+			Code:  ErrorCodeRuleFuncNil,
+		}
+	}
+
+	value, err := d.resolve(ctx, input)
+	if err != nil {
+		store.put(dependencyErrKey{d}, err)
+		return value, err
+	}
+	store.put(d, value)
+	return value, nil
+}
+
+// Resolve is like Prepare but reads its input from the data registry. Custom
+// rules and conditions call it from their own Prepare. It returns a
+// TYPE_MISMATCH error when the registered data is not an In.
+func (d *Dependency[In, T]) Resolve(ctx context.Context) (T, error) {
+	input, ok := GetAs[In](ctx)
+	if !ok {
+		var zero T
+		var zeroIn In
+		return zero, Error{
+			Field: d.name,
+			Err:   fmt.Sprintf("expected input of type %T, got different type", zeroIn),
+			Code:  ErrorCodeTypeMismatch,
+		}
+	}
+	return d.Prepare(ctx, input)
+}
+
+// Get returns the value resolved for the current target. The boolean is false
+// when the dependency has not been resolved, or failed to resolve, in this
+// evaluation. It is equivalent to GetPreparedAs[T](ctx, d).
+func (d *Dependency[In, T]) Get(ctx context.Context) (T, bool) {
+	return GetPreparedAs[T](ctx, d)
+}
@@ -0,0 +1,121 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+type depAccount struct {
+	Active  bool
+	Balance int
+}
+
+func TestDependency_ResolvedOncePerTarget(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int64
+	account := NewDependency("account", func(ctx context.Context, u testUser) (depAccount, error) {
+		calls.Add(1)
+		return depAccount{Active: u.Age >= 18, Balance: u.Age}, nil
+	})
+
+	tree := Node(
+		NewTypedConditionWithPrepare("hasAccount", account.Prepare,
+			func(ctx context.Context, u testUser, a depAccount) bool { return a.Balance > 0 }),
+		Rules(
+			NewTypedRuleWithPrepare("active", account.Prepare,
+				func(ctx context.Context, u testUser, a depAccount) error {
+					if !a.Active {
+						return Error{Field: "account", Err: "inactive", Code: "INACTIVE"}
+					}
+					return nil
+				}),
+			NewTypedRuleWithPrepare("funded", account.Prepare,
+				func(ctx context.Context, u testUser, a depAccount) error {
+					if a.Balance < 20 {
+						return Error{Field: "account", Err: "insufficient balance", Code: "NO_FUNDS"}
+					}
+					return nil
+				}),
+			NewTypedRule("sharedRead", func(ctx context.Context, u testUser) error {
+				// Any consumer can read the resolved value back typed.
+				a, ok := GetPreparedAs[depAccount](ctx, account)
+				if got, _ := account.Get(ctx); !ok || got != a {
+					return errors.New("dependency not visible through GetPreparedAs")
+				}
+				return nil
+			}),
+		),
+	)
+
+	err := ValidateMultiWithData(context.Background(), []TreeAndData{
+		{Tree: tree, Data: testUser{Age: 30}},
+		{Tree: tree, Data: testUser{Age: 12}},
+	}, ProcessingHooks{}, "accounts")
+
+	var codes []string
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var re Error
+		if errors.As(e, &re) {
+			codes = append(codes, re.Code)
+		}
+	}
+	if len(codes) != 2 || codes[0] != "INACTIVE" || codes[1] != "NO_FUNDS" {
+		t.Errorf("codes = %v, want [INACTIVE NO_FUNDS] for the second target", codes)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("resolve calls = %d, want one per target", calls.Load())
+	}
+}
+
+func TestDependency_ErrorMemoised(t *testing.T) {
+	t.Parallel()
+
+	boom := errors.New("account service down")
+	var calls atomic.Int64
+	account := NewDependency("account", func(ctx context.Context, u testUser) (depAccount, error) {
+		calls.Add(1)
+		return depAccount{}, boom
+	})
+
+	consumer := func(name string) Rule {
+		return NewTypedRuleWithPrepare(name, account.Prepare,
+			func(ctx context.Context, u testUser, a depAccount) error { return nil })
+	}
+	tree := Rules(consumer("a"), consumer("b"), consumer("c"))
+
+	err := ValidateWithData(context.Background(), tree, ProcessingHooks{}, "accounts", testUser{})
+	if !errors.Is(err, boom) {
+		t.Errorf("error = %v, want %v", err, boom)
+	}
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 3 {
+		t.Errorf("got %d errors, want one per consumer", n)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("resolve calls = %d, want 1", calls.Load())
+	}
+}
+
+func TestDependency_Resolve(t *testing.T) {
+	t.Parallel()
+
+	account := NewDependency("account", func(ctx context.Context, u testUser) (depAccount, error) {
+		return depAccount{Balance: u.Age}, nil
+	})
+
+	ctx := WithRegistry(context.Background(), NewDataRegistry(testUser{Age: 7}))
+	if a, err := account.Resolve(ctx); err != nil || a.Balance != 7 {
+		t.Errorf("Resolve = %v, %v", a, err)
+	}
+	if _, ok := account.Get(ctx); ok {
+		t.Error("nothing is memoised outside the engine")
+	}
+
+	ctx = WithRegistry(context.Background(), NewDataRegistry(testProduct{}))
+	var re Error
+	if _, err := account.Resolve(ctx); !errors.As(err, &re) || re.Code != ErrorCodeTypeMismatch {
+		t.Errorf("expected TYPE_MISMATCH, got %v", err)
+	}
+}
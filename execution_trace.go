@@ -20,9 +20,11 @@ type traceKey struct{}
 // goroutines, §6.3 in AGENTS.md). Reading paths with Path after evaluation
 // completes is safe from any goroutine.
 type ExecutionTrace struct {
-	mu       sync.Mutex
-	paths    map[Rule]string
-	segments []string
+	mu          sync.Mutex
+	paths       map[Rule]string
+	segments    []string
+	treeVersion TreeVersion
+	fingerprint string
 }
 
 // WithExecutionTrace returns a context carrying an ExecutionTrace and the
@@ -50,6 +52,30 @@ func (t *ExecutionTrace) Path(rule Rule) string {
 	return t.paths[rule]
 }
 
+// TreeVersion returns the version of the tree that was evaluated when it came
+// from a TreeStore, or the zero TreeVersion otherwise.
+func (t *ExecutionTrace) TreeVersion() TreeVersion {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.treeVersion
+}
+
+// Fingerprint returns the Fingerprint of the evaluated tree, or an empty
+// string if no tree was evaluated with this trace.
+func (t *ExecutionTrace) Fingerprint() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.fingerprint
+}
+
+// setTree records the version and fingerprint of the evaluated tree.
+func (t *ExecutionTrace) setTree(v TreeVersion, fingerprint string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.treeVersion = v
+	t.fingerprint = fingerprint
+}
+
 // push appends a segment to the current path stack. Called by nodes while
 // traversing down into their children.
 func (t *ExecutionTrace) push(segment string) {
@@ -0,0 +1,319 @@
+package rules
+
+import (
+	"math"
+	"slices"
+)
+
+const (
+	// ExponentialMaxScale is the scale new exponential histograms start at.
+	// Buckets at this scale are about 0.00007% wide; the histogram lowers its
+	// scale as observations spread.
+	ExponentialMaxScale = 20
+	// DefaultExponentialBuckets is the bucket limit per sign used when
+	// NewExponentialHistogram is given a non-positive limit.
+	DefaultExponentialBuckets = 160
+	// MinExponentialBuckets is the smallest bucket limit per sign: values on
+	// both sides of 1 fall in two buckets at any scale, so a lower limit
+	// could never hold them.
+	MinExponentialBuckets = 2
+)
+
+// ExponentialHistogram is a native exponential histogram, as in OpenTelemetry
+// and Prometheus native histograms: bucket boundaries are the powers of
+// base = 2^(2^-Scale), so bucket i of Positive holds the observations in
+// (base^i, base^(i+1)] and bucket i of Negative their negative counterparts.
+// The layout needs no configuration: the histogram starts at
+// ExponentialMaxScale and halves its resolution whenever the observations of
+// one sign would need more than MaxBuckets buckets.
+//
+// Unlike Histogram, exponential histograms of different scales merge without
+// losing counts: the finer one is downscaled to the coarser scale first.
+//
+// Example:
+//
+//	latency := rules.NewExponentialHistogram(0)
+//	latency.Observe(12.5)
+//	o := rules.ExponentialHistogramValue(latency)
+type ExponentialHistogram struct {
+	Scale      int
+	MaxBuckets int // bucket limit per sign
+	ZeroCount  uint64
+	Positive   ExponentialBucketCounts
+	Negative   ExponentialBucketCounts // indexed by absolute value
+	Total      uint64
+	Sum        float64
+	Min        float64 // smallest observation, when Total > 0
+	Max        float64 // largest observation, when Total > 0
+}
+
+// ExponentialBucketCounts holds consecutive bucket counts: Counts[i] is the
+// count of bucket Offset+i.
+type ExponentialBucketCounts struct {
+	Offset int
+	Counts []uint64
+}
+
+// NewExponentialHistogram creates an empty exponential histogram holding at
+// most maxBuckets buckets per sign (DefaultExponentialBuckets when maxBuckets
+// is not positive, and at least MinExponentialBuckets).
+func NewExponentialHistogram(maxBuckets int) ExponentialHistogram {
+	if maxBuckets <= 0 {
+		maxBuckets = DefaultExponentialBuckets
+	}
+	maxBuckets = max(maxBuckets, MinExponentialBuckets)
+	return ExponentialHistogram{Scale: ExponentialMaxScale, MaxBuckets: maxBuckets}
+}
+
+// ExponentialHistogramValue returns a KindHistogram outcome carrying an
+// exponential histogram.
+func ExponentialHistogramValue(h ExponentialHistogram) Outcome {
+	return Outcome{Kind: KindHistogram, Exponential: h}
+}
+
+// Observe adds an observation. NaN and infinite values are ignored.
+func (h *ExponentialHistogram) Observe(v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+	h.init()
+	if h.Total == 0 || v < h.Min {
+		h.Min = v
+	}
+	if h.Total == 0 || v > h.Max {
+		h.Max = v
+	}
+	h.Total++
+	h.Sum += v
+
+	if v == 0 {
+		h.ZeroCount++
+		return
+	}
+	buckets := &h.Positive
+	if v < 0 {
+		buckets, v = &h.Negative, -v
+	}
+	idx := exponentialIndex(v, h.Scale)
+	for !buckets.fits(idx, idx, h.MaxBuckets) {
+		h.downscale(1)
+		idx = exponentialIndex(v, h.Scale)
+	}
+	buckets.add(idx, 1)
+}
+
+// Merge adds the observations of other to h. Both histograms end at the
+// coarser of their scales, lowered further when the merged buckets would
+// exceed h's bucket limit. other is not modified.
+func (h *ExponentialHistogram) Merge(other ExponentialHistogram) {
+	if other.Total == 0 {
+		return
+	}
+	if h.Total == 0 && h.MaxBuckets <= 0 {
+		// A zero histogram takes the layout of the first one merged into it.
+		*h = other.clone()
+		h.init()
+		return
+	}
+	h.init()
+	other = other.clone()
+
+	scale := min(h.Scale, other.Scale)
+	h.downscale(h.Scale - scale)
+	other.downscale(other.Scale - scale)
+	for {
+		lowP, highP := spanUnion(h.Positive, other.Positive)
+		lowN, highN := spanUnion(h.Negative, other.Negative)
+		if highP-lowP < h.MaxBuckets && highN-lowN < h.MaxBuckets {
+			break
+		}
+		h.downscale(1)
+		other.downscale(1)
+	}
+
+	for i, c := range other.Positive.Counts {
+		h.Positive.add(other.Positive.Offset+i, c)
+	}
+	for i, c := range other.Negative.Counts {
+		h.Negative.add(other.Negative.Offset+i, c)
+	}
+	if h.Total == 0 || other.Min < h.Min {
+		h.Min = other.Min
+	}
+	if h.Total == 0 || other.Max > h.Max {
+		h.Max = other.Max
+	}
+	h.ZeroCount += other.ZeroCount
+	h.Total += other.Total
+	h.Sum += other.Sum
+}
+
+// Quantile estimates the q-quantile (0 <= q <= 1) of the observations by
+// linear interpolation within the bucket the quantile falls in, clamped to
+// [Min, Max]. It returns NaN for an empty histogram; q is clamped to [0, 1].
+func (h ExponentialHistogram) Quantile(q float64) float64 {
+	if h.Total == 0 || math.IsNaN(q) {
+		return math.NaN()
+	}
+	rank := min(max(q, 0), 1) * float64(h.Total)
+	clamp := func(v float64) float64 { return min(max(v, h.Min), h.Max) }
+
+	var seen float64
+	// in reports whether the quantile falls in a bucket of count c spanning
+	// [lower, upper], and where.
+	in := func(c uint64, lower, upper float64) (float64, bool) {
+		if c == 0 || seen+float64(c) < rank {
+			seen += float64(c)
+			return 0, false
+		}
+		return clamp(lower + (upper-lower)*(rank-seen)/float64(c)), true
+	}
+
+	neg := h.Negative
+	for i := len(neg.Counts) - 1; i >= 0; i-- {
+		idx := neg.Offset + i
+		if v, ok := in(neg.Counts[i], -exponentialLowerBound(idx+1, h.Scale), -exponentialLowerBound(idx, h.Scale)); ok {
+			return v
+		}
+	}
+	if v, ok := in(h.ZeroCount, 0, 0); ok {
+		return v
+	}
+	for i, c := range h.Positive.Counts {
+		idx := h.Positive.Offset + i
+		if v, ok := in(c, exponentialLowerBound(idx, h.Scale), exponentialLowerBound(idx+1, h.Scale)); ok {
+			return v
+		}
+	}
+	return h.Max
+}
+
+// init prepares a zero histogram for use, sets the default bucket limit on a
+// histogram built without one and raises a limit below
+// MinExponentialBuckets.
+func (h *ExponentialHistogram) init() {
+	switch {
+	case h.MaxBuckets >= MinExponentialBuckets:
+	case h.MaxBuckets > 0:
+		h.MaxBuckets = MinExponentialBuckets
+	case h.Total == 0:
+		*h = NewExponentialHistogram(0)
+	default:
+		h.MaxBuckets = DefaultExponentialBuckets
+	}
+}
+
+// clone returns a copy of h that shares no slices with it.
+func (h ExponentialHistogram) clone() ExponentialHistogram {
+	h.Positive.Counts = slices.Clone(h.Positive.Counts)
+	h.Negative.Counts = slices.Clone(h.Negative.Counts)
+	return h
+}
+
+// downscale lowers the scale by the given amount, merging each group of
+// 2^by adjacent buckets into one.
+func (h *ExponentialHistogram) downscale(by int) {
+	if by <= 0 {
+		return
+	}
+	h.Scale -= by
+	h.Positive.downscale(by)
+	h.Negative.downscale(by)
+}
+
+// downscale merges each group of 2^by adjacent buckets into one.
+func (b *ExponentialBucketCounts) downscale(by int) {
+	if len(b.Counts) == 0 {
+		b.Offset >>= by
+		return
+	}
+	offset := b.Offset >> by
+	counts := make([]uint64, ((b.Offset+len(b.Counts)-1)>>by)-offset+1)
+	for i, c := range b.Counts {
+		counts[((b.Offset+i)>>by)-offset] += c
+	}
+	b.Offset, b.Counts = offset, counts
+}
+
+// fits reports whether the buckets would span at most limit buckets once
+// extended to [low, high].
+func (b ExponentialBucketCounts) fits(low, high, limit int) bool {
+	if len(b.Counts) > 0 {
+		low = min(low, b.Offset)
+		high = max(high, b.Offset+len(b.Counts)-1)
+	}
+	return high-low < limit
+}
+
+// add adds c to bucket idx, growing the counts as needed.
+func (b *ExponentialBucketCounts) add(idx int, c uint64) {
+	if c == 0 {
+		return
+	}
+	if len(b.Counts) == 0 {
+		b.Offset, b.Counts = idx, []uint64{c}
+		return
+	}
+	if idx < b.Offset {
+		b.Counts = append(make([]uint64, b.Offset-idx), b.Counts...)
+		b.Offset = idx
+	}
+	if end := b.Offset + len(b.Counts); idx >= end {
+		b.Counts = append(b.Counts, make([]uint64, idx-end+1)...)
+	}
+	b.Counts[idx-b.Offset] += c
+}
+
+// spanUnion returns the lowest and highest bucket index of a and b, or 0, -1
+// when both are empty.
+func spanUnion(a, b ExponentialBucketCounts) (low, high int) {
+	low, high = 0, -1
+	for _, s := range []ExponentialBucketCounts{a, b} {
+		if len(s.Counts) == 0 {
+			continue
+		}
+		if high < low {
+			low, high = s.Offset, s.Offset+len(s.Counts)-1
+			continue
+		}
+		low = min(low, s.Offset)
+		high = max(high, s.Offset+len(s.Counts)-1)
+	}
+	return low, high
+}
+
+// exponentialIndex returns the index of the bucket holding v > 0 at scale:
+// the i for which base^i < v <= base^(i+1).
+func exponentialIndex(v float64, scale int) int {
+	frac, exp := math.Frexp(v) // v = frac * 2^exp, frac in [0.5, 1)
+	if scale <= 0 {
+		idx := exp - 1
+		if frac == 0.5 {
+			idx-- // powers of two are the upper bound of their bucket
+		}
+		return idx >> -scale
+	}
+	if frac == 0.5 {
+		return (exp-1)<<scale - 1
+	}
+	return int(math.Ceil(math.Log2(v)*math.Ldexp(1, scale))) - 1
+}
+
+// exponentialLowerBound returns base^idx at scale, the lower bound of bucket
+// idx.
+func exponentialLowerBound(idx, scale int) float64 {
+	if scale <= 0 {
+		return math.Ldexp(1, idx<<-scale)
+	}
+	return math.Exp2(float64(idx) / math.Ldexp(1, scale))
+}
+
+// mergeExponentialHistograms merges the exponential histograms of a group.
+// The outcomes are not modified.
+func mergeExponentialHistograms(group []Outcome) ExponentialHistogram {
+	res := group[0].Exponential.clone()
+	for _, o := range group[1:] {
+		res.Merge(o.Exponential)
+	}
+	return res
+}
@@ -0,0 +1,198 @@
+package rules
+
+import (
+	"context"
+	"math"
+	"slices"
+	"testing"
+)
+
+// bucketTotal returns the number of observations held in buckets.
+func bucketTotal(h ExponentialHistogram) uint64 {
+	total := h.ZeroCount
+	for _, c := range h.Positive.Counts {
+		total += c
+	}
+	for _, c := range h.Negative.Counts {
+		total += c
+	}
+	return total
+}
+
+func TestExponentialIndex(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		v     float64
+		scale int
+		want  int
+	}{
+		{v: 1, scale: 0, want: -1}, // (0.5, 1]
+		{v: 1.5, scale: 0, want: 0},
+		{v: 2, scale: 0, want: 0}, // (1, 2]
+		{v: 3, scale: 0, want: 1},
+		{v: 8, scale: -1, want: 1}, // (4, 16]
+		{v: 2, scale: 1, want: 1},  // (sqrt2, 2]
+		{v: 1.5, scale: 1, want: 1},
+		{v: 1.2, scale: 1, want: 0}, // (1, sqrt2]
+		{v: 0.25, scale: 3, want: -17},
+	}
+	for _, tc := range testCases {
+		idx := exponentialIndex(tc.v, tc.scale)
+		if idx != tc.want {
+			t.Errorf("exponentialIndex(%v, %d) = %d, want %d", tc.v, tc.scale, idx, tc.want)
+			continue
+		}
+		lower, upper := exponentialLowerBound(idx, tc.scale), exponentialLowerBound(idx+1, tc.scale)
+		if !(tc.v > lower && tc.v <= upper) {
+			t.Errorf("%v is outside bucket %d = (%v, %v]", tc.v, idx, lower, upper)
+		}
+	}
+}
+
+func TestExponentialHistogram_Observe(t *testing.T) {
+	t.Parallel()
+
+	h := NewExponentialHistogram(20)
+	for v := 1.0; v <= 1000; v++ {
+		h.Observe(v)
+		h.Observe(-v / 10)
+	}
+	h.Observe(0)
+	h.Observe(math.NaN())
+
+	if h.Total != 2001 || bucketTotal(h) != 2001 || h.ZeroCount != 1 {
+		t.Errorf("Total = %d, in buckets = %d, zero = %d", h.Total, bucketTotal(h), h.ZeroCount)
+	}
+	if len(h.Positive.Counts) > 20 || len(h.Negative.Counts) > 20 {
+		t.Errorf("bucket limit exceeded: %d positive, %d negative", len(h.Positive.Counts), len(h.Negative.Counts))
+	}
+	if h.Scale >= ExponentialMaxScale || h.Min != -100 || h.Max != 1000 {
+		t.Errorf("Scale = %d, Min = %v, Max = %v", h.Scale, h.Min, h.Max)
+	}
+
+	// The zero value is usable.
+	var zero ExponentialHistogram
+	zero.Observe(3)
+	if zero.Total != 1 || zero.MaxBuckets != DefaultExponentialBuckets || zero.Scale != ExponentialMaxScale {
+		t.Errorf("zero value after Observe = %+v", zero)
+	}
+}
+
+func TestExponentialHistogram_Quantile(t *testing.T) {
+	t.Parallel()
+
+	h := NewExponentialHistogram(0)
+	for v := 1.0; v <= 1000; v++ {
+		h.Observe(v)
+	}
+	for _, tc := range []struct{ q, want float64 }{{0.5, 500}, {0.95, 950}, {0.99, 990}, {0, 1}, {1, 1000}} {
+		// At the default limit, buckets are a few percent wide.
+		if got := h.Quantile(tc.q); math.Abs(got-tc.want)/tc.want > 0.05 {
+			t.Errorf("Quantile(%v) = %v, want about %v", tc.q, got, tc.want)
+		}
+	}
+
+	mixed := NewExponentialHistogram(0)
+	for _, v := range []float64{-8, -2, 0, 0, 4} {
+		mixed.Observe(v)
+	}
+	if got := mixed.Quantile(0.5); got != 0 {
+		t.Errorf("mixed median = %v, want 0", got)
+	}
+	if got := mixed.Quantile(0.1); got >= -4 {
+		t.Errorf("mixed p10 = %v, want below -4", got)
+	}
+	if got := (ExponentialHistogram{}).Quantile(0.5); !math.IsNaN(got) {
+		t.Errorf("empty quantile = %v, want NaN", got)
+	}
+}
+
+func TestExponentialHistogram_MergeAcrossScales(t *testing.T) {
+	t.Parallel()
+
+	narrow := NewExponentialHistogram(0)
+	for v := 1.0; v <= 2; v += 0.01 {
+		narrow.Observe(v)
+	}
+	wide := NewExponentialHistogram(10)
+	for v := 1.0; v <= 1e6; v *= 3 {
+		wide.Observe(v)
+		wide.Observe(-v)
+	}
+	if narrow.Scale <= wide.Scale {
+		t.Fatalf("scales %d and %d should differ", narrow.Scale, wide.Scale)
+	}
+	wideScale, wideCounts := wide.Scale, slices.Clone(wide.Positive.Counts)
+
+	merged := narrow.clone()
+	merged.Merge(wide)
+	if merged.Total != narrow.Total+wide.Total || bucketTotal(merged) != merged.Total {
+		t.Errorf("merged Total = %d, in buckets = %d, want %d", merged.Total, bucketTotal(merged), narrow.Total+wide.Total)
+	}
+	if merged.Scale > wide.Scale || merged.Min != wide.Min || merged.Max != wide.Max {
+		t.Errorf("merged Scale = %d, Min = %v, Max = %v", merged.Scale, merged.Min, merged.Max)
+	}
+	if wide.Scale != wideScale || !slices.Equal(wide.Positive.Counts, wideCounts) {
+		t.Error("Merge must not modify its argument")
+	}
+
+	// Report aggregation merges exponential histograms too.
+	emit := func(h ExponentialHistogram) Rule {
+		return NewTypedRule[string]("latency", func(ctx context.Context, _ string) error {
+			o := ExponentialHistogramValue(h)
+			o.Name = "latency"
+			Emit(ctx, o)
+			return nil
+		})
+	}
+	report, err := EvaluateMetricsWithData(context.Background(), Rules(emit(narrow), emit(wide)), ProcessingHooks{}, "check", "data")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := report.Metrics["latency"].Exponential; got.Total != merged.Total || bucketTotal(got) != merged.Total {
+		t.Errorf("report latency Total = %d, in buckets = %d, want %d", got.Total, bucketTotal(got), merged.Total)
+	}
+}
+
+func TestExponentialHistogram_SmallBucketLimits(t *testing.T) {
+	t.Parallel()
+
+	values := []float64{0.5, 2, 1e300, 1e-300, -0.5, -3}
+	testCases := []struct {
+		testName string
+		hist     func() ExponentialHistogram
+	}{
+		{testName: "limit 1", hist: func() ExponentialHistogram { return NewExponentialHistogram(1) }},
+		{testName: "limit 2", hist: func() ExponentialHistogram { return NewExponentialHistogram(2) }},
+		{testName: "limit 1 set by hand", hist: func() ExponentialHistogram { return ExponentialHistogram{MaxBuckets: 1} }},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			t.Parallel()
+
+			h := tc.hist()
+			for _, v := range values {
+				h.Observe(v)
+			}
+			if h.MaxBuckets != MinExponentialBuckets {
+				t.Errorf("MaxBuckets = %d, want %d", h.MaxBuckets, MinExponentialBuckets)
+			}
+			if len(h.Positive.Counts) > 2 || len(h.Negative.Counts) > 2 {
+				t.Errorf("buckets exceed the limit: %+v, %+v", h.Positive, h.Negative)
+			}
+			if got := bucketTotal(h); got != uint64(len(values)) || h.Total != got {
+				t.Errorf("bucket total = %d, Total = %d, want %d", got, h.Total, len(values))
+			}
+
+			// Merging histograms on both sides of 1 terminates too.
+			low, high := tc.hist(), tc.hist()
+			low.Observe(0.25)
+			high.Observe(4)
+			low.Merge(high)
+			if low.Total != 2 || bucketTotal(low) != 2 || len(low.Positive.Counts) > 2 {
+				t.Errorf("merged = %+v", low)
+			}
+		})
+	}
+}
@@ -0,0 +1,353 @@
+package rules
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parameterized is implemented by rules, conditions and custom Evaluables
+// that were built with parameters not visible in their name, such as the
+// field and expected value of FieldEquals. Fingerprint hashes the returned
+// values, so two trees that differ only in a parameter get different
+// fingerprints.
+//
+// Params must be deterministic: it is called every time a fingerprint is
+// computed and its result must not depend on the evaluated data.
+type Parameterized interface {
+	Params() []any
+}
+
+// Fingerprint returns a deterministic hash of the tree's policy: its shape,
+// the kind of every node, and the type, name and parameters (see
+// Parameterized) of every condition and rule. Two trees built the same way
+// get the same fingerprint in any process, so it can be used as a cache key
+// for compiled or memoised results and recorded alongside decisions to prove
+// which policy made them.
+//
+// Functions cannot be compared, so the behaviour of a closure is not part of
+// the fingerprint: give closures names that change when their logic does, or
+// expose their parameters through Params. Parameters are hashed by walking
+// their structure; see FingerprintStringer for values that describe
+// themselves.
+//
+// Fingerprint hashes the tree on every call. The engine hashes a tree once
+// per run, and a TreeStore once per published version.
+//
+// A VersionedTree fingerprints as the tree it wraps, so publishing the same
+// policy twice in a TreeStore yields the same fingerprint under two versions.
+//
+// Example:
+//
+//	a := rules.Node(rules.FieldEquals("isAdmin", "Role", "admin"), rules.Rules(rule))
+//	b := rules.Node(rules.FieldEquals("isAdmin", "Role", "admin"), rules.Rules(rule))
+//	rules.Fingerprint(a) == rules.Fingerprint(b) // true
+func Fingerprint(tree Evaluable) string {
+	if vt, ok := tree.(*VersionedTree); ok {
+		if vt.Fingerprint != "" {
+			return vt.Fingerprint
+		}
+		return Fingerprint(vt.Tree)
+	}
+
+	f := &fingerprinter{h: sha256.New()}
+	f.evaluable(tree)
+	return hex.EncodeToString(f.h.Sum(nil))
+}
+
+// FingerprintStringer is implemented by parameter values whose
+// FingerprintString describes them completely and deterministically.
+// Fingerprint hashes that string instead of walking the value by reflection,
+// e.g. for values holding caches or handles that do not change their meaning.
+// A String method is never used for this: it may omit fields or vary between
+// equal values.
+type FingerprintStringer interface {
+	FingerprintString() string
+}
+
+// paramsOf returns the parameters of v, or nil when it is not Parameterized.
+// Wrappers use it to forward the parameters of the rule or condition they
+// wrap.
+func paramsOf(v any) []any {
+	if p, ok := v.(Parameterized); ok {
+		return p.Params()
+	}
+	return nil
+}
+
+// maxParamDepth bounds the recursion into deeply nested parameter values.
+// Cycles and shared references are cut by the visited set of fingerprinter.
+const maxParamDepth = 32
+
+// fingerprinter writes a length-prefixed encoding of a tree into h, so no two
+// different trees produce the same byte stream.
+type fingerprinter struct {
+	h   hash.Hash
+	buf [binary.MaxVarintLen64]byte
+	// content disables the FingerprintString shortcut of value, so every
+	// field of a value contributes to the hash (see payloadHash).
+	content bool
+	// visited numbers the pointers, maps and slices already written, in
+	// visiting order. A value reached again, through a cycle or a shared
+	// reference, is written as a back-reference to its number, so a value is
+	// walked once and cyclic values terminate.
+	visited map[visitKey]int
+}
+
+// visitKey identifies a pointer, map or slice by address. Slices of the same
+// array with different lengths are different values, and so are pointers of
+// different types to the same address (a struct and its first field).
+type visitKey struct {
+	typ reflect.Type
+	ptr uintptr
+	len int
+}
+
+// visit reports whether v is reached for the first time. Otherwise it writes a
+// back-reference to the first visit.
+func (f *fingerprinter) visit(v reflect.Value) bool {
+	key := visitKey{typ: v.Type(), ptr: v.Pointer()}
+	if v.Kind() == reflect.Slice {
+		key.len = v.Len()
+	}
+	if i, ok := f.visited[key]; ok {
+		f.str("ref")
+		f.count(i)
+		return false
+	}
+	if f.visited == nil {
+		f.visited = make(map[visitKey]int)
+	}
+	f.visited[key] = len(f.visited)
+	return true
+}
+
+// str writes a length-prefixed string.
+func (f *fingerprinter) str(s string) {
+	n := binary.PutUvarint(f.buf[:], uint64(len(s)))
+	f.h.Write(f.buf[:n])
+	f.h.Write([]byte(s))
+}
+
+// count writes a length prefix for a list of n items.
+func (f *fingerprinter) count(n int) {
+	f.str(strconv.Itoa(n))
+}
+
+// typeName writes the dynamic type of v.
+func (f *fingerprinter) typeName(v any) {
+	if v == nil {
+		f.str("nil")
+		return
+	}
+	f.str(reflect.TypeOf(v).String())
+}
+
+// evaluable writes a node and, recursively, its children.
+func (f *fingerprinter) evaluable(e Evaluable) {
+	switch n := e.(type) {
+	case nil:
+		f.str("nil")
+	case *VersionedTree:
+		f.evaluable(n.Tree)
+	case *LeafNode:
+		f.str("leaf")
+		f.rules(n.Rules)
+	case *ConditionNode:
+		f.str("node")
+		f.condition(n.Condition)
+		f.evaluables(n.Evaluables)
+	case *AllOfNode:
+		f.str("allOf")
+		f.evaluables(n.Children)
+	case *AnyOfNode:
+		f.str("anyOf")
+		f.str(n.name)
+		f.evaluables(n.Children)
+	case *ConditionEither:
+		f.str("either")
+		f.condition(n.Condition)
+		f.evaluables(n.Left)
+		f.evaluables(n.Right)
+	case *ScorecardNode:
+		f.str("scorecard")
+		f.str(n.Name)
+		f.str(strconv.FormatFloat(n.Threshold, 'g', -1, 64))
+		f.str(strconv.Itoa(int(n.Aggregation)))
+		f.evaluables(n.Children)
+	default:
+		f.str("custom")
+		f.typeName(e)
+		f.params(e)
+	}
+}
+
+// evaluables writes a list of nodes.
+func (f *fingerprinter) evaluables(list []Evaluable) {
+	f.count(len(list))
+	for _, e := range list {
+		f.evaluable(e)
+	}
+}
+
+// condition writes a condition's type, name and parameters.
+func (f *fingerprinter) condition(c Condition) {
+	f.typeName(c)
+	if c == nil {
+		return
+	}
+	f.str(c.Name())
+	if not, ok := c.(*NotCondition); ok {
+		f.condition(not.condition)
+		return
+	}
+	f.params(c)
+}
+
+// rules writes a list of rules, recursing into the built-in composites.
+func (f *fingerprinter) rules(list []Rule) {
+	f.count(len(list))
+	for _, r := range list {
+		f.typeName(r)
+		if r == nil {
+			continue
+		}
+		f.str(r.Name())
+		switch composite := r.(type) {
+		case *ChainRules:
+			f.rules(composite.Rules)
+		case *OrRules:
+			f.rules(composite.Rules)
+		default:
+			f.params(r)
+		}
+	}
+}
+
+// params writes the parameters of v when it is Parameterized.
+func (f *fingerprinter) params(v any) {
+	p, ok := v.(Parameterized)
+	if !ok {
+		f.count(0)
+		return
+	}
+	params := p.Params()
+	f.count(len(params))
+	for _, param := range params {
+		f.value(reflect.ValueOf(param), 0)
+	}
+}
+
+// value writes a parameter value. Pointers are followed so the encoding never
+// depends on addresses, map entries are sorted by the encoding of their key,
+// and values that cannot be compared (functions, channels) contribute only
+// their type. Types, regular expressions and times are written in canonical
+// form; other values are walked by reflection unless they implement
+// FingerprintStringer.
+func (f *fingerprinter) value(v reflect.Value, depth int) {
+	if !v.IsValid() {
+		f.str("nil")
+		return
+	}
+	f.str(v.Type().String())
+	if depth > maxParamDepth {
+		return
+	}
+
+	if v.CanInterface() && (v.Kind() != reflect.Pointer || !v.IsNil()) {
+		switch x := v.Interface().(type) {
+		case reflect.Type:
+			f.str(x.String())
+			return
+		case *regexp.Regexp:
+			f.str(x.String())
+			return
+		case time.Time:
+			// Without the monotonic clock reading, which is not part of the
+			// instant, and with the location by name.
+			f.str(x.Round(0).Format(time.RFC3339Nano))
+			f.str(x.Location().String())
+			return
+		case FingerprintStringer:
+			if !f.content {
+				f.str(x.FingerprintString())
+				return
+			}
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		f.str(strconv.FormatBool(v.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		f.str(strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		f.str(strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		f.str(strconv.FormatUint(math.Float64bits(v.Float()), 16))
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		f.str(strconv.FormatUint(math.Float64bits(real(c)), 16))
+		f.str(strconv.FormatUint(math.Float64bits(imag(c)), 16))
+	case reflect.String:
+		f.str(v.String())
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			f.str("nil")
+			return
+		}
+		if v.Kind() == reflect.Pointer && !f.visit(v) {
+			return
+		}
+		f.value(v.Elem(), depth+1)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			f.str("nil")
+			return
+		}
+		if v.Kind() == reflect.Slice && v.Len() > 0 && !f.visit(v) {
+			return
+		}
+		f.count(v.Len())
+		for i := range v.Len() {
+			f.value(v.Index(i), depth+1)
+		}
+	case reflect.Map:
+		if !v.IsNil() && !f.visit(v) {
+			return
+		}
+		f.count(v.Len())
+		// Values are written in the order of their keys' encodings, so the
+		// numbering of visited values does not depend on iteration order.
+		type entry struct {
+			key   string
+			value reflect.Value
+		}
+		entries := make([]entry, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := &fingerprinter{h: sha256.New(), content: f.content}
+			key.value(iter.Key(), depth+1)
+			entries = append(entries, entry{key: string(key.h.Sum(nil)), value: iter.Value()})
+		}
+		slices.SortFunc(entries, func(a, b entry) int { return strings.Compare(a.key, b.key) })
+		for _, e := range entries {
+			f.str(e.key)
+			f.value(e.value, depth+1)
+		}
+	case reflect.Struct:
+		f.count(v.NumField())
+		for i := range v.NumField() {
+			f.str(v.Type().Field(i).Name)
+			f.value(v.Field(i), depth+1)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package rules
+
+import (
+	"context"
+	"regexp"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// policy builds the same tree every time it is called, with a configurable
+// role parameter.
+func policy(role string) Evaluable {
+	return Root(
+		Node(FieldEquals("isRole", "Name", role),
+			Rules(NewRulePure("ruleA", func() error { return nil })),
+		),
+		Either(IsA[*testUser]("isUser"),
+			[]Evaluable{Rules(NewChainRules(&NopRule{}, NewRulePure("ruleB", func() error { return nil })))},
+			[]Evaluable{AllOf(Rules(&NopRule{}))},
+		),
+	)
+}
+
+func TestFingerprint_Deterministic(t *testing.T) {
+	t.Parallel()
+
+	a, b := Fingerprint(policy("admin")), Fingerprint(policy("admin"))
+	if a != b {
+		t.Fatalf("fingerprints differ for identical trees: %s != %s", a, b)
+	}
+	if len(a) != 64 {
+		t.Errorf("fingerprint %q is not a hex sha256", a)
+	}
+
+	// Map parameters are hashed in sorted order.
+	labels := map[string]any{"a": 1, "b": []string{"x"}, "c": map[string]int{"z": 1, "y": 2}}
+	first := Fingerprint(Node(FieldEquals("labels", "Labels", labels), Rules(&NopRule{})))
+	for range 20 {
+		if got := Fingerprint(Node(FieldEquals("labels", "Labels", labels), Rules(&NopRule{}))); got != first {
+			t.Fatal("fingerprint of a map parameter is not stable")
+		}
+	}
+}
+
+func TestFingerprint_Differences(t *testing.T) {
+	t.Parallel()
+
+	nop := Rules(&NopRule{})
+	base := Fingerprint(policy("admin"))
+
+	testCases := []struct {
+		testName string
+		tree     Evaluable
+	}{
+		{testName: "parameter", tree: policy("member")},
+		{testName: "node kind", tree: AllOf(nop)},
+		{testName: "anyOf vs allOf", tree: AnyOf(nop)},
+		{testName: "condition type", tree: Node(IsA[testUser]("isUser"), nop)},
+		{testName: "condition name", tree: Node(IsA[*testUser]("isOther"), nop)},
+		{testName: "negated condition", tree: Node(Not(IsA[*testUser]("isUser")), nop)},
+		{testName: "rule name", tree: Rules(NewRulePure("other", func() error { return nil }))},
+		{testName: "rule count", tree: Rules(&NopRule{}, &NopRule{})},
+		{testName: "field name", tree: Node(HasField("has", "Email"), nop)},
+		{testName: "other field name", tree: Node(HasField("has", "Phone"), nop)},
+		{testName: "branch side", tree: Either(IsA[*testUser]("isUser"), nil, []Evaluable{nop})},
+		{testName: "metric rule", tree: Rules(NewMetricRulePure("m", KindCounter, "orders", nil))},
+		{testName: "metric kind", tree: Rules(NewMetricRulePure("m", KindScore, "orders", nil))},
+		{testName: "metric field", tree: Rules(NewMetricRulePure("m", KindCounter, "refunds", nil))},
+		{testName: "metric aggregation", tree: Rules(NewMetricRulePure("m", KindCounter, "orders", nil, WithMetricAggregation(AggMax)))},
+		{testName: "metric labels", tree: Rules(NewMetricRulePure("m", KindCounter, "orders", nil, WithMetricLabels(map[string]string{"region": "eu"})))},
+		{testName: "retried rule", tree: Rules(RetryRule(RetryPolicy{MaxAttempts: 2}, &NopRule{}))},
+		{testName: "retry policy", tree: Rules(RetryRule(RetryPolicy{MaxAttempts: 3}, &NopRule{}))},
+		{testName: "retried metric rule", tree: Rules(RetryRule(RetryPolicy{MaxAttempts: 2}, NewMetricRulePure("m", KindCounter, "orders", nil)))},
+		{testName: "retried metric rule field", tree: Rules(RetryRule(RetryPolicy{MaxAttempts: 2}, NewMetricRulePure("m", KindCounter, "refunds", nil)))},
+		{testName: "retried condition", tree: Node(RetryCondition(RetryPolicy{MaxAttempts: 2}, HasField("has", "Email")), nop)},
+		{testName: "retried condition parameter", tree: Node(RetryCondition(RetryPolicy{MaxAttempts: 2}, HasField("has", "Phone")), nop)},
+		{testName: "cached rule", tree: Rules(CachedRule(NewPrepareCache(PrepareCacheOptions{TTL: time.Minute}), &NopRule{}, fingerprintKey))},
+		{testName: "cache ttl", tree: Rules(CachedRule(NewPrepareCache(PrepareCacheOptions{TTL: time.Hour}), &NopRule{}, fingerprintKey))},
+		{testName: "cached condition", tree: Node(CachedCondition(NewPrepareCache(PrepareCacheOptions{TTL: time.Minute}), HasField("has", "Email"), fingerprintKey), nop)},
+	}
+
+	seen := map[string]string{base: "base"}
+	for _, tc := range testCases {
+		fp := Fingerprint(tc.tree)
+		if prev, ok := seen[fp]; ok {
+			t.Errorf("%s: fingerprint collides with %s", tc.testName, prev)
+		}
+		seen[fp] = tc.testName
+	}
+}
+
+func TestFingerprint_VersionedTree(t *testing.T) {
+	t.Parallel()
+
+	store := NewTreeStore()
+	v1 := store.Set("policy", policy("admin"))
+	v2 := store.Set("policy", policy("admin"))
+
+	want := Fingerprint(policy("admin"))
+	if v1.Fingerprint != want || v2.Fingerprint != want {
+		t.Errorf("store fingerprints = %s, %s, want %s", v1.Fingerprint, v2.Fingerprint, want)
+	}
+	if got := Fingerprint(&VersionedTree{Tree: policy("admin")}); got != want {
+		t.Errorf("Fingerprint(VersionedTree) = %s, want %s", got, want)
+	}
+}
+
+func TestFingerprint_StampedOnReportAndTrace(t *testing.T) {
+	t.Parallel()
+
+	tree := policy("admin")
+	want := Fingerprint(tree)
+
+	ctx, trace := WithExecutionTrace(context.Background())
+	report, err := EvaluateMetricsWithData(ctx, tree, ProcessingHooks{}, "policy", &testUser{Name: "admin"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Fingerprint != want {
+		t.Errorf("report fingerprint = %s, want %s", report.Fingerprint, want)
+	}
+	if trace.Fingerprint() != want {
+		t.Errorf("trace fingerprint = %s, want %s", trace.Fingerprint(), want)
+	}
+
+	targets := []TreeAndData{
+		{Tree: tree, Data: &testUser{}},
+		{Tree: Rules(&NopRule{}), Data: &testUser{}},
+	}
+	reports, err := EvaluateMetricsMultiWithData(context.Background(), targets, ProcessingHooks{}, "batch")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reports[0].Fingerprint != want || reports[1].Fingerprint != Fingerprint(targets[1].Tree) {
+		t.Errorf("batch fingerprints = %s, %s", reports[0].Fingerprint, reports[1].Fingerprint)
+	}
+
+}
+
+// countingParamsRule counts how often the fingerprint reads its parameters.
+type countingParamsRule struct {
+	NopRule
+	calls *atomic.Int64
+}
+
+func (r *countingParamsRule) Params() []any {
+	r.calls.Add(1)
+	return []any{"limit", 10}
+}
+
+func TestFingerprint_HashedPerRun(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int64
+	tree := Rules(&countingParamsRule{calls: &calls})
+	for range 3 {
+		ctx, _ := WithExecutionTrace(context.Background())
+		if _, err := EvaluateMetricsWithData(ctx, tree, ProcessingHooks{}, "runs", "data"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if calls.Load() != 3 {
+		t.Errorf("tree hashed %d times over 3 runs, want 3", calls.Load())
+	}
+
+	// A tree changed after an evaluation gets a new fingerprint.
+	card := Scorecard("risk", 0.5, Rules(&NopRule{}))
+	before, _ := EvaluateMetricsWithData(context.Background(), card, ProcessingHooks{}, "card", "data")
+	card.Threshold = 0.8
+	after, _ := EvaluateMetricsWithData(context.Background(), card, ProcessingHooks{}, "card", "data")
+	if before.Fingerprint == after.Fingerprint || after.Fingerprint != Fingerprint(card) {
+		t.Errorf("fingerprint after the change = %s, want %s", after.Fingerprint, Fingerprint(card))
+	}
+
+	// Published trees are hashed once, by the store.
+	calls.Store(0)
+	store := NewTreeStore()
+	published := store.Set("runs", Rules(&countingParamsRule{calls: &calls}))
+	for range 3 {
+		if _, err := EvaluateMetricsWithData(context.Background(), published, ProcessingHooks{}, "runs", "data"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("published tree hashed %d times, want 1", calls.Load())
+	}
+}
+
+// partialStringer is a parameter whose String omits a field.
+type partialStringer struct {
+	name  string
+	limit int
+}
+
+func (p partialStringer) String() string { return p.name }
+
+// canonicalParam opts into being hashed by its FingerprintString.
+type canonicalParam struct {
+	id    string
+	cache map[string]int
+}
+
+func (p canonicalParam) FingerprintString() string { return p.id }
+
+func TestFingerprint_ParameterValues(t *testing.T) {
+	t.Parallel()
+
+	fp := func(param any) string {
+		return Fingerprint(Node(FieldEquals("eq", "Field", param), Rules(&NopRule{})))
+	}
+
+	// String methods are not trusted to describe a value.
+	if fp(partialStringer{name: "a", limit: 1}) == fp(partialStringer{name: "a", limit: 2}) {
+		t.Error("values with the same String collide")
+	}
+	// Values opting in are hashed by their FingerprintString.
+	if fp(canonicalParam{id: "a", cache: map[string]int{"x": 1}}) != fp(canonicalParam{id: "a"}) {
+		t.Error("FingerprintString is not used")
+	}
+	// The monotonic clock reading is not part of a time.
+	now := time.Now()
+	if fp(now) != fp(now.Round(0)) {
+		t.Error("the monotonic clock reading changes the fingerprint")
+	}
+	if fp(now) == fp(now.Add(time.Nanosecond)) || fp(now.UTC()) == fp(now.In(time.FixedZone("X", 3600))) {
+		t.Error("different times collide")
+	}
+	if fp(regexp.MustCompile(`^a+$`)) == fp(regexp.MustCompile(`^b+$`)) {
+		t.Error("different patterns collide")
+	}
+}
+
+// fingerprintKey is the cache key of the cached rules of the tests.
+func fingerprintKey(u testUser) string { return u.Name }
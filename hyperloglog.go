@@ -0,0 +1,156 @@
+package rules
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+const (
+	// MinHyperLogLogPrecision and MaxHyperLogLogPrecision bound the
+	// precision of a HyperLogLog.
+	MinHyperLogLogPrecision = 4
+	MaxHyperLogLogPrecision = 18
+	// DefaultHyperLogLogPrecision is used when NewHyperLogLog is given a
+	// precision out of bounds: 4096 registers, for a standard error of about
+	// 1.6%.
+	DefaultHyperLogLogPrecision = 12
+)
+
+// HyperLogLog estimates the number of distinct items added to it in a fixed
+// amount of memory: 2^Precision one-byte registers, for a standard error of
+// about 1.04/sqrt(2^Precision). Sketches merge without double counting, so
+// per-target sketches add up to the distinct count of the whole batch.
+// Items are hashed with a fixed function, so sketches built by different
+// processes merge too.
+//
+// Example:
+//
+//	merchants := rules.NewHyperLogLog(0)
+//	for _, p := range order.Payments {
+//	    merchants.Add(p.MerchantID)
+//	}
+//	o := rules.DistinctValue(merchants)
+type HyperLogLog struct {
+	Precision uint8
+	Registers []uint8
+}
+
+// NewHyperLogLog creates an empty sketch with 2^precision registers
+// (DefaultHyperLogLogPrecision when precision is out of bounds).
+func NewHyperLogLog(precision uint8) HyperLogLog {
+	if precision < MinHyperLogLogPrecision || precision > MaxHyperLogLogPrecision {
+		precision = DefaultHyperLogLogPrecision
+	}
+	return HyperLogLog{Precision: precision, Registers: make([]uint8, 1<<precision)}
+}
+
+// DistinctValue returns a KindDistinct outcome carrying a sketch.
+func DistinctValue(h HyperLogLog) Outcome {
+	return Outcome{Kind: KindDistinct, Distinct: h}
+}
+
+// Add adds an item.
+func (h *HyperLogLog) Add(item string) {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(item))
+	h.AddHash(mix64(hash.Sum64()))
+}
+
+// AddHash adds an item by its 64-bit hash, which must be uniformly
+// distributed, for items that are not strings.
+func (h *HyperLogLog) AddHash(x uint64) {
+	if len(h.Registers) == 0 {
+		*h = NewHyperLogLog(h.Precision)
+	}
+	p := h.Precision
+	idx := x >> (64 - p)
+	rank := uint8(bits.LeadingZeros64(x<<p|1<<(p-1)) + 1)
+	h.Registers[idx] = max(h.Registers[idx], rank)
+}
+
+// Merge adds the items of other to h. When the precisions differ, the result
+// has the lower one. other is not modified.
+func (h *HyperLogLog) Merge(other HyperLogLog) {
+	if len(other.Registers) == 0 {
+		return
+	}
+	if len(h.Registers) == 0 {
+		h.Precision, h.Registers = other.Precision, append([]uint8(nil), other.Registers...)
+		return
+	}
+	if other.Precision < h.Precision {
+		*h = h.reduce(other.Precision)
+	} else if other.Precision > h.Precision {
+		other = other.reduce(h.Precision)
+	}
+	for i, r := range other.Registers {
+		h.Registers[i] = max(h.Registers[i], r)
+	}
+}
+
+// Estimate returns the estimated number of distinct items.
+func (h HyperLogLog) Estimate() uint64 {
+	if len(h.Registers) == 0 {
+		return 0
+	}
+	m := float64(len(h.Registers))
+	var sum float64
+	zeros := 0
+	for _, r := range h.Registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	var alpha float64
+	switch len(h.Registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// Linear counting is more accurate for small cardinalities.
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(math.Round(estimate))
+}
+
+// reduce returns a copy of h folded to the lower precision p. A register's
+// index bits beyond p become the leading bits of the remaining hash, which
+// is exactly the sketch the items would have built at precision p.
+func (h HyperLogLog) reduce(p uint8) HyperLogLog {
+	d := h.Precision - p
+	res := NewHyperLogLog(p)
+	for i, r := range h.Registers {
+		if r == 0 {
+			continue
+		}
+		dropped := uint64(i) & (1<<d - 1)
+		rank := r + d
+		if dropped != 0 {
+			rank = uint8(bits.LeadingZeros64(dropped<<(64-d)) + 1)
+		}
+		j := i >> d
+		res.Registers[j] = max(res.Registers[j], rank)
+	}
+	return res
+}
+
+// mix64 is the finalizer of MurmurHash3, spreading the bits of an FNV hash
+// so every bit of the result depends on every bit of the item.
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
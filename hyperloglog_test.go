@@ -0,0 +1,72 @@
+package rules
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestHyperLogLog_Estimate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		testName  string
+		precision uint8
+		distinct  int
+		tolerance float64
+	}{
+		{testName: "empty", precision: 12, distinct: 0},
+		{testName: "small", precision: 12, distinct: 100, tolerance: 0.02},
+		{testName: "large", precision: 12, distinct: 100_000, tolerance: 0.05},
+		{testName: "low precision", precision: 6, distinct: 10_000, tolerance: 0.4},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			t.Parallel()
+
+			h := NewHyperLogLog(tc.precision)
+			for i := range tc.distinct {
+				h.Add(strconv.Itoa(i))
+				h.Add(strconv.Itoa(i)) // duplicates are not counted
+			}
+			got := float64(h.Estimate())
+			if math.Abs(got-float64(tc.distinct)) > tc.tolerance*float64(tc.distinct) {
+				t.Errorf("Estimate = %v, want %d within %v%%", got, tc.distinct, tc.tolerance*100)
+			}
+		})
+	}
+}
+
+func TestHyperLogLog_Merge(t *testing.T) {
+	t.Parallel()
+
+	// Two overlapping halves: 0..5999 and 4000..9999.
+	a, b := NewHyperLogLog(14), NewHyperLogLog(11)
+	for i := range 6000 {
+		a.Add("merchant-" + strconv.Itoa(i))
+		b.Add("merchant-" + strconv.Itoa(i+4000))
+	}
+	bRegisters := append([]uint8(nil), b.Registers...)
+
+	var merged HyperLogLog
+	merged.Merge(a)
+	merged.Merge(b)
+	if merged.Precision != 11 {
+		t.Errorf("Precision = %d, want the lower precision 11", merged.Precision)
+	}
+	if got := float64(merged.Estimate()); math.Abs(got-10_000) > 500 {
+		t.Errorf("Estimate = %v, want about 10000", got)
+	}
+	if string(b.Registers) != string(bRegisters) || a.Precision != 14 {
+		t.Error("Merge must not modify its argument")
+	}
+
+	// Folding to a lower precision gives the sketch built at that precision.
+	direct := NewHyperLogLog(11)
+	for i := range 6000 {
+		direct.Add("merchant-" + strconv.Itoa(i))
+	}
+	if string(a.reduce(11).Registers) != string(direct.Registers) {
+		t.Error("reduce(11) differs from a sketch built at precision 11")
+	}
+}
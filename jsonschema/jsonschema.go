@@ -0,0 +1,735 @@
+// Package jsonschema builds rule trees from JSON Schema documents.
+//
+// A compiled schema is an ordinary rules.Evaluable over decoded JSON data
+// (map[string]any, []any, string, float64, bool and nil, as produced by
+// encoding/json), so schema checks run through the same engine as any other
+// tree: Validate, ValidateMulti, EvaluateMetrics, execution tracing and the
+// TreeStore all work unchanged.
+//
+// The supported subset of draft 2020-12 is: type, enum, const, minimum,
+// maximum, exclusiveMinimum, exclusiveMaximum, minLength, maxLength, pattern,
+// format (email, uri, hostname, ipv4, ipv6), required, properties, items,
+// minItems, maxItems, allOf, anyOf, oneOf, not and if/then/else, plus the
+// boolean schemas true and false. $ref is rejected rather than ignored.
+// Other keywords are annotations and are ignored.
+//
+// Combinators map onto the engine's nodes: allOf onto rules.AllOf; anyOf and
+// oneOf onto rules.Either gating a rules.AnyOf of the matching branches; not
+// and if/then/else onto rules.Either. Errors are rules.Error values whose
+// Field is the JSON pointer (RFC 6901) of the offending value, e.g.
+// "/address/zip" or "/items/2".
+package jsonschema
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mishudark/rules"
+	"github.com/mishudark/rules/validators"
+)
+
+// Compile parses a JSON Schema document and builds the rule tree that
+// validates data against it. The tree reads the decoded JSON value from the
+// data registry and is safe to share across goroutines.
+//
+// Example:
+//
+//	tree, err := jsonschema.Compile(schemaJSON)
+//	if err != nil {
+//	    return err
+//	}
+//	var payload map[string]any
+//	_ = json.Unmarshal(body, &payload)
+//	err = rules.ValidateWithData(ctx, tree, hooks, "createUser", payload)
+func Compile(document []byte) (rules.Evaluable, error) {
+	var raw any
+	if err := json.Unmarshal(document, &raw); err != nil {
+		return nil, fmt.Errorf("jsonschema: %w", err)
+	}
+	return CompileValue(raw)
+}
+
+// CompileValue is like Compile for a schema that is already decoded.
+func CompileValue(raw any) (rules.Evaluable, error) {
+	s, err := parse(raw, "#")
+	if err != nil {
+		return nil, err
+	}
+	return s.tree(nil), nil
+}
+
+// schema is a parsed (sub)schema.
+type schema struct {
+	raw    any   // the decoded schema
+	always *bool // set for the boolean schemas true and false
+
+	types    []string
+	enum     []any
+	hasConst bool
+	constVal any
+
+	minimum, maximum                   *float64
+	exclusiveMinimum, exclusiveMaximum *float64
+	minLength, maxLength               *int
+	minItems, maxItems                 *int
+	pattern                            *regexp.Regexp
+	format                             string
+
+	required   []string
+	properties map[string]*schema
+	items      *schema
+
+	allOf, anyOf, oneOf []*schema
+	not                 *schema
+	ifS, thenS, elseS   *schema
+}
+
+// parse converts a decoded schema into a schema. loc is the schema location
+// used in parse errors.
+func parse(raw any, loc string) (*schema, error) {
+	if b, ok := raw.(bool); ok {
+		return &schema{raw: b, always: &b}, nil
+	}
+	obj, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("jsonschema: %s: schema must be an object or a boolean", loc)
+	}
+	if _, ok := obj["$ref"]; ok {
+		return nil, fmt.Errorf("jsonschema: %s: $ref is not supported", loc)
+	}
+
+	s := &schema{raw: obj}
+	var err error
+
+	switch t := obj["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []any:
+		for _, v := range t {
+			name, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("jsonschema: %s/type: expected strings", loc)
+			}
+			s.types = append(s.types, name)
+		}
+	default:
+		return nil, fmt.Errorf("jsonschema: %s/type: expected a string or an array", loc)
+	}
+
+	if v, ok := obj["enum"]; ok {
+		if s.enum, ok = v.([]any); !ok {
+			return nil, fmt.Errorf("jsonschema: %s/enum: expected an array", loc)
+		}
+	}
+	s.constVal, s.hasConst = obj["const"]
+
+	for keyword, dst := range map[string]**float64{
+		"minimum":          &s.minimum,
+		"maximum":          &s.maximum,
+		"exclusiveMinimum": &s.exclusiveMinimum,
+		"exclusiveMaximum": &s.exclusiveMaximum,
+	} {
+		if *dst, err = number(obj, keyword, loc); err != nil {
+			return nil, err
+		}
+	}
+	for keyword, dst := range map[string]**int{
+		"minLength": &s.minLength,
+		"maxLength": &s.maxLength,
+		"minItems":  &s.minItems,
+		"maxItems":  &s.maxItems,
+	} {
+		if *dst, err = count(obj, keyword, loc); err != nil {
+			return nil, err
+		}
+	}
+
+	if v, ok := obj["pattern"]; ok {
+		pattern, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("jsonschema: %s/pattern: expected a string", loc)
+		}
+		if s.pattern, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("jsonschema: %s/pattern: %w", loc, err)
+		}
+	}
+	if v, ok := obj["format"]; ok {
+		if s.format, ok = v.(string); !ok {
+			return nil, fmt.Errorf("jsonschema: %s/format: expected a string", loc)
+		}
+	}
+
+	if v, ok := obj["required"]; ok {
+		list, ok := v.([]any)
+		if !ok {
+			return nil, fmt.Errorf("jsonschema: %s/required: expected an array", loc)
+		}
+		for _, name := range list {
+			prop, ok := name.(string)
+			if !ok {
+				return nil, fmt.Errorf("jsonschema: %s/required: expected strings", loc)
+			}
+			s.required = append(s.required, prop)
+		}
+	}
+
+	if v, ok := obj["properties"]; ok {
+		props, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("jsonschema: %s/properties: expected an object", loc)
+		}
+		s.properties = make(map[string]*schema, len(props))
+		for name, sub := range props {
+			if s.properties[name], err = parse(sub, loc+"/properties/"+escape(name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for keyword, dst := range map[string]**schema{
+		"items": &s.items,
+		"not":   &s.not,
+		"if":    &s.ifS,
+		"then":  &s.thenS,
+		"else":  &s.elseS,
+	} {
+		if v, ok := obj[keyword]; ok {
+			if *dst, err = parse(v, loc+"/"+keyword); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for keyword, dst := range map[string]*[]*schema{
+		"allOf": &s.allOf,
+		"anyOf": &s.anyOf,
+		"oneOf": &s.oneOf,
+	} {
+		v, ok := obj[keyword]
+		if !ok {
+			continue
+		}
+		list, ok := v.([]any)
+		if !ok || len(list) == 0 {
+			return nil, fmt.Errorf("jsonschema: %s/%s: expected a non-empty array", loc, keyword)
+		}
+		for i, sub := range list {
+			parsed, err := parse(sub, fmt.Sprintf("%s/%s/%d", loc, keyword, i))
+			if err != nil {
+				return nil, err
+			}
+			*dst = append(*dst, parsed)
+		}
+	}
+
+	return s, nil
+}
+
+// number reads an optional numeric keyword.
+func number(obj map[string]any, keyword, loc string) (*float64, error) {
+	v, ok := obj[keyword]
+	if !ok {
+		return nil, nil
+	}
+	f, ok := toFloat(v)
+	if !ok {
+		return nil, fmt.Errorf("jsonschema: %s/%s: expected a number", loc, keyword)
+	}
+	return &f, nil
+}
+
+// count reads an optional non-negative integer keyword.
+func count(obj map[string]any, keyword, loc string) (*int, error) {
+	f, err := number(obj, keyword, loc)
+	if err != nil || f == nil {
+		return nil, err
+	}
+	if *f < 0 || *f != math.Trunc(*f) {
+		return nil, fmt.Errorf("jsonschema: %s/%s: expected a non-negative integer", loc, keyword)
+	}
+	n := int(*f)
+	return &n, nil
+}
+
+// tree builds the Evaluable validating the value at the JSON pointer made of
+// tokens. Every node it returns evaluates to true, so subtrees can be
+// combined with rules.AllOf without one branch hiding another's rules.
+func (s *schema) tree(tokens []string) rules.Evaluable {
+	ptr := pointer(tokens)
+	children := []rules.Evaluable{
+		rules.Rules(&schemaRule{name: "schema[" + ptr + "]", tokens: tokens, check: s.local, params: []any{s.localRaw()}}),
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(s.properties)) {
+		sub := append(slices.Clip(tokens), name)
+		children = append(children, rules.Either(
+			present("present["+pointer(sub)+"]", sub),
+			[]rules.Evaluable{s.properties[name].tree(sub)},
+			nil,
+		))
+	}
+
+	if len(s.allOf) > 0 {
+		subtrees := make([]rules.Evaluable, len(s.allOf))
+		for i, sub := range s.allOf {
+			subtrees[i] = sub.tree(tokens)
+		}
+		children = append(children, rules.AllOf(subtrees...))
+	}
+
+	if len(s.anyOf) > 0 {
+		children = append(children, s.combinator("anyOf", s.anyOf, tokens, func(n int) bool { return n > 0 },
+			"ANY_OF_MISMATCH", "value does not match any schema in anyOf"))
+	}
+	if len(s.oneOf) > 0 {
+		children = append(children, s.combinator("oneOf", s.oneOf, tokens, func(n int) bool { return n == 1 },
+			"ONE_OF_MISMATCH", "value must match exactly one schema in oneOf"))
+	}
+
+	if s.not != nil {
+		children = append(children, rules.Either(
+			matches("not["+ptr+"]", s.not, tokens),
+			[]rules.Evaluable{rules.Rules(failRule("not["+ptr+"]", ptr, "NOT_MISMATCH", "value must not match the schema in not"))},
+			nil,
+		))
+	}
+
+	if s.ifS != nil && (s.thenS != nil || s.elseS != nil) {
+		var then, otherwise []rules.Evaluable
+		if s.thenS != nil {
+			then = []rules.Evaluable{s.thenS.tree(tokens)}
+		}
+		if s.elseS != nil {
+			otherwise = []rules.Evaluable{s.elseS.tree(tokens)}
+		}
+		children = append(children, rules.Either(matches("if["+ptr+"]", s.ifS, tokens), then, otherwise))
+	}
+
+	if len(children) == 1 {
+		return children[0]
+	}
+	return rules.AllOf(children...)
+}
+
+// treeKeywords are the keywords whose subschemas tree builds into nodes of
+// their own, which rules.Fingerprint hashes where they appear.
+var treeKeywords = []string{"properties", "allOf", "anyOf", "oneOf", "then", "else"}
+
+// localRaw returns the decoded schema without the subschemas of
+// treeKeywords, exposed to rules.Fingerprint by the schema's rule. Each
+// subschema is then hashed once, by its own nodes, rather than again at every
+// enclosing level.
+func (s *schema) localRaw() any {
+	obj, ok := s.raw.(map[string]any)
+	if !ok {
+		return s.raw
+	}
+	local := maps.Clone(obj)
+	for _, keyword := range treeKeywords {
+		delete(local, keyword)
+	}
+	return local
+}
+
+// combinator builds anyOf and oneOf: an Either gated on how many branches
+// match, whose true side traverses the matching branches through an AnyOf and
+// whose false side fails with code.
+func (s *schema) combinator(keyword string, subs []*schema, tokens []string, ok func(matched int) bool, code, msg string) rules.Evaluable {
+	ptr := pointer(tokens)
+	branches := make([]rules.Evaluable, len(subs))
+	for i, sub := range subs {
+		branches[i] = rules.Node(matches(fmt.Sprintf("%s[%s]/%d", keyword, ptr, i), sub, tokens), sub.tree(tokens))
+	}
+
+	gate := rules.NewCondition(keyword+"["+ptr+"]", func(ctx context.Context) bool {
+		value, found := resolve(ctx, tokens)
+		if !found {
+			return true
+		}
+		return ok(countMatches(subs, value, ptr))
+	})
+
+	return rules.Either(gate,
+		[]rules.Evaluable{rules.AnyOf(branches...)},
+		[]rules.Evaluable{rules.Rules(failRule(keyword+"["+ptr+"]", ptr, code, msg))},
+	)
+}
+
+// check validates v, located at ptr, against the whole schema. It backs the
+// conditions used by the combinators.
+func (s *schema) check(v any, ptr string) []error {
+	errs := s.local(v, ptr)
+
+	if obj, ok := v.(map[string]any); ok {
+		for _, name := range slices.Sorted(maps.Keys(s.properties)) {
+			if prop, ok := obj[name]; ok {
+				errs = append(errs, s.properties[name].check(prop, ptr+"/"+escape(name))...)
+			}
+		}
+	}
+
+	for _, sub := range s.allOf {
+		errs = append(errs, sub.check(v, ptr)...)
+	}
+	if len(s.anyOf) > 0 && countMatches(s.anyOf, v, ptr) == 0 {
+		errs = append(errs, schemaError(ptr, "ANY_OF_MISMATCH", "value does not match any schema in anyOf"))
+	}
+	if len(s.oneOf) > 0 && countMatches(s.oneOf, v, ptr) != 1 {
+		errs = append(errs, schemaError(ptr, "ONE_OF_MISMATCH", "value must match exactly one schema in oneOf"))
+	}
+	if s.not != nil && len(s.not.check(v, ptr)) == 0 {
+		errs = append(errs, schemaError(ptr, "NOT_MISMATCH", "value must not match the schema in not"))
+	}
+	if s.ifS != nil {
+		if len(s.ifS.check(v, ptr)) == 0 {
+			if s.thenS != nil {
+				errs = append(errs, s.thenS.check(v, ptr)...)
+			}
+		} else if s.elseS != nil {
+			errs = append(errs, s.elseS.check(v, ptr)...)
+		}
+	}
+
+	return errs
+}
+
+// countMatches returns how many schemas in subs v is valid against.
+func countMatches(subs []*schema, v any, ptr string) int {
+	matched := 0
+	for _, sub := range subs {
+		if len(sub.check(v, ptr)) == 0 {
+			matched++
+		}
+	}
+	return matched
+}
+
+// local validates the keywords that apply to v itself: everything except
+// properties and the combinators, which the tree expresses as nodes. Array
+// items are checked here because their pointers are only known at runtime.
+func (s *schema) local(v any, ptr string) []error {
+	if s.always != nil {
+		if *s.always {
+			return nil
+		}
+		return []error{schemaError(ptr, "FALSE_SCHEMA", "no value is allowed here")}
+	}
+
+	if len(s.types) > 0 && !slices.ContainsFunc(s.types, func(t string) bool { return hasType(v, t) }) {
+		return []error{schemaError(ptr, "INVALID_TYPE",
+			fmt.Sprintf("expected %s, got %s", strings.Join(s.types, " or "), typeName(v)))}
+	}
+
+	var errs []error
+	if s.enum != nil && !slices.ContainsFunc(s.enum, func(e any) bool { return equal(e, v) }) {
+		errs = append(errs, schemaError(ptr, "ENUM_MISMATCH", "value is not one of the allowed values"))
+	}
+	if s.hasConst && !equal(s.constVal, v) {
+		errs = append(errs, schemaError(ptr, "CONST_MISMATCH", "value does not match the constant"))
+	}
+
+	if f, ok := toFloat(v); ok {
+		if s.minimum != nil {
+			errs = appendRule(errs, ptr, validators.MinValue(ptr, f, *s.minimum))
+		}
+		if s.maximum != nil {
+			errs = appendRule(errs, ptr, validators.MaxValue(ptr, f, *s.maximum))
+		}
+		if s.exclusiveMinimum != nil && f <= *s.exclusiveMinimum {
+			errs = append(errs, schemaError(ptr, "VALUE_LOWER_MIN",
+				fmt.Sprintf("Value (%v) must be greater than %v", f, *s.exclusiveMinimum)))
+		}
+		if s.exclusiveMaximum != nil && f >= *s.exclusiveMaximum {
+			errs = append(errs, schemaError(ptr, "VALUE_EXCEEDS_MAX",
+				fmt.Sprintf("Value (%v) must be less than %v", f, *s.exclusiveMaximum)))
+		}
+	}
+
+	if str, ok := v.(string); ok {
+		if s.minLength != nil {
+			errs = appendRule(errs, ptr, validators.MinLengthString(ptr, str, *s.minLength))
+		}
+		if s.maxLength != nil {
+			errs = appendRule(errs, ptr, validators.MaxLengthString(ptr, str, *s.maxLength))
+		}
+		if s.pattern != nil && !s.pattern.MatchString(str) {
+			errs = append(errs, schemaError(ptr, "PATTERN_MISMATCH",
+				fmt.Sprintf("value does not match pattern %q", s.pattern.String())))
+		}
+		if rule := formatRule(s.format, ptr, str); rule != nil {
+			errs = appendRule(errs, ptr, rule)
+		}
+	}
+
+	if obj, ok := v.(map[string]any); ok {
+		for _, name := range s.required {
+			if _, ok := obj[name]; !ok {
+				errs = append(errs, schemaError(ptr+"/"+escape(name), "REQUIRED", "value is required"))
+			}
+		}
+	}
+
+	if arr, ok := v.([]any); ok {
+		if s.minItems != nil {
+			errs = appendRule(errs, ptr, validators.MinLengthSlice(ptr, arr, *s.minItems))
+		}
+		if s.maxItems != nil {
+			errs = appendRule(errs, ptr, validators.MaxLengthSlice(ptr, arr, *s.maxItems))
+		}
+		if s.items != nil {
+			for i, item := range arr {
+				errs = append(errs, s.items.check(item, fmt.Sprintf("%s/%d", ptr, i))...)
+			}
+		}
+	}
+
+	return errs
+}
+
+// formatRule returns the validator for a format keyword, or nil when the
+// format is not asserted. The validators treat an empty string as absent,
+// which is left to Required in Go code; no email, URI or hostname is empty,
+// so a schema accepts "" only through other keywords, e.g. in an anyOf.
+func formatRule(format, ptr, value string) rules.Rule {
+	if strings.TrimSpace(value) == "" {
+		if code, ok := emptyFormatCodes[format]; ok {
+			return rules.NewRulePure("format", func() error {
+				return schemaError(ptr, code, fmt.Sprintf("an empty string is not a valid %s", format))
+			})
+		}
+	}
+	switch format {
+	case "email":
+		return validators.Email(ptr, value, nil)
+	case "uri":
+		return validators.URL(value, nil)
+	case "hostname":
+		return validators.ValidDomainNameAdvanced(ptr, value, true)
+	case "ipv4":
+		return validators.IPv4Address(value)
+	case "ipv6":
+		return validators.IPv6Address(value)
+	default:
+		return nil
+	}
+}
+
+// emptyFormatCodes are the error codes of the formats whose validators
+// accept an empty string, by format.
+var emptyFormatCodes = map[string]string{
+	"email":    "INVALID_EMAIL_FORMAT",
+	"uri":      "INVALID_URL_FORMAT",
+	"hostname": "INVALID_DOMAIN_STRUCTURE",
+}
+
+// appendRule runs a pure validator and appends its error, with the pointer as
+// Field, to errs.
+func appendRule(errs []error, ptr string, rule rules.Rule) []error {
+	err := rule.Validate(context.Background())
+	if err == nil {
+		return errs
+	}
+	if re, ok := err.(rules.Error); ok {
+		re.Field = ptr
+		err = re
+	}
+	return append(errs, err)
+}
+
+// schemaError builds a schema violation error.
+func schemaError(ptr, code, msg string) error {
+	return rules.Error{Field: ptr, Err: msg, Code: code}
+}
+
+// schemaRule validates the local keywords of a schema against the value at
+// its pointer. It is skipped when the value is absent: presence is enforced
+// by the parent's required keyword.
+type schemaRule struct {
+	rules.RuleBase
+	name   string
+	tokens []string
+	check  func(v any, ptr string) []error
+	params []any
+}
+
+var (
+	_ rules.Rule          = (*schemaRule)(nil)
+	_ rules.Parameterized = (*schemaRule)(nil)
+)
+
+// Name returns the rule name.
+func (r *schemaRule) Name() string { return r.name }
+
+// Params returns the schema the rule checks, so trees compiled from different
+// schemas get different fingerprints.
+func (r *schemaRule) Params() []any { return r.params }
+
+// Prepare is a no-op: schema rules are pure.
+func (r *schemaRule) Prepare(context.Context) (any, error) { return nil, nil }
+
+// Validate checks the value at the rule's pointer.
+func (r *schemaRule) Validate(ctx context.Context) error {
+	value, found := resolve(ctx, r.tokens)
+	if !found {
+		return nil
+	}
+	return errors.Join(r.check(value, pointer(r.tokens))...)
+}
+
+// failRule returns a rule that always fails with the given code.
+func failRule(name, ptr, code, msg string) rules.Rule {
+	return &schemaRule{name: name, check: func(any, string) []error {
+		return []error{schemaError(ptr, code, msg)}
+	}}
+}
+
+// present returns a condition that is true when a value exists at tokens.
+func present(name string, tokens []string) rules.Condition {
+	return rules.NewCondition(name, func(ctx context.Context) bool {
+		_, found := resolve(ctx, tokens)
+		return found
+	})
+}
+
+// matches returns a condition that is true when the value at tokens is valid
+// against sub.
+func matches(name string, sub *schema, tokens []string) rules.Condition {
+	ptr := pointer(tokens)
+	return rules.NewCondition(name, func(ctx context.Context) bool {
+		value, found := resolve(ctx, tokens)
+		return found && len(sub.check(value, ptr)) == 0
+	})
+}
+
+// resolve returns the value at tokens inside the registry data.
+func resolve(ctx context.Context, tokens []string) (any, bool) {
+	value, ok := rules.Get(ctx)
+	if !ok {
+		return nil, false
+	}
+	for _, token := range tokens {
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = obj[token]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// pointer renders tokens as a JSON pointer; the root is "".
+func pointer(tokens []string) string {
+	var b strings.Builder
+	for _, token := range tokens {
+		b.WriteByte('/')
+		b.WriteString(escape(token))
+	}
+	return b.String()
+}
+
+// escape escapes a JSON pointer reference token.
+func escape(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+// hasType reports whether v is an instance of the JSON Schema type name.
+func hasType(v any, name string) bool {
+	switch name {
+	case "null":
+		return v == nil
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "number":
+		_, ok := toFloat(v)
+		return ok
+	case "integer":
+		f, ok := toFloat(v)
+		return ok && f == math.Trunc(f) && !math.IsInf(f, 0)
+	default:
+		return false
+	}
+}
+
+// typeName returns the JSON type name of v for error messages.
+func typeName(v any) string {
+	for _, name := range []string{"null", "boolean", "string", "object", "array", "integer", "number"} {
+		if hasType(v, name) {
+			return name
+		}
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// toFloat converts a JSON number (or a Go numeric value) to float64.
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// equal compares two JSON values, treating numbers by value.
+func equal(a, b any) bool {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+	switch av := a.(type) {
+	case []any:
+		bv, ok := b.([]any)
+		return ok && slices.EqualFunc(av, bv, equal)
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		return ok && maps.EqualFunc(av, bv, equal)
+	default:
+		return reflect.DeepEqual(a, b)
+	}
+}
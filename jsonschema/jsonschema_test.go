@@ -0,0 +1,203 @@
+package jsonschema
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/mishudark/rules"
+)
+
+const userSchema = `{
+	"type": "object",
+	"required": ["email", "age"],
+	"properties": {
+		"email": {"type": "string", "format": "email"},
+		"age": {"type": "integer", "minimum": 18, "exclusiveMaximum": 130},
+		"name": {"type": "string", "minLength": 2, "maxLength": 10, "pattern": "^[A-Z]"},
+		"role": {"enum": ["admin", "member"]},
+		"tags": {"type": "array", "maxItems": 2, "items": {"type": "string"}},
+		"address": {
+			"type": "object",
+			"required": ["zip"],
+			"properties": {"zip": {"type": "string", "minLength": 5}}
+		},
+		"contact": {
+			"anyOf": [
+				{"type": "string", "format": "email"},
+				{"type": "string", "format": "ipv4"},
+				{"const": ""}
+			]
+		},
+		"id": {"oneOf": [{"type": "integer"}, {"type": "number", "minimum": 0}]},
+		"nickname": {"not": {"const": "root"}}
+	},
+	"if": {"properties": {"role": {"const": "admin"}}, "required": ["role"]},
+	"then": {"required": ["mfa"]},
+	"else": {"properties": {"mfa": false}}
+}`
+
+// codesAt returns "field:code" for every rules.Error in err, sorted.
+func codesAt(err error) []string {
+	var out []string
+	var walk func(error)
+	walk = func(err error) {
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, e := range joined.Unwrap() {
+				walk(e)
+			}
+			return
+		}
+		var re rules.Error
+		if errors.As(err, &re) {
+			out = append(out, re.Field+":"+re.Code)
+		}
+	}
+	if err != nil {
+		walk(err)
+	}
+	slices.Sort(out)
+	return out
+}
+
+func TestCompile(t *testing.T) {
+	tree, err := Compile([]byte(userSchema))
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	testCases := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{name: "valid", data: `{"email": "a@b.co", "age": 30}`},
+		{name: "missing required", data: `{"age": 30}`, want: []string{"/email:REQUIRED"}},
+		{name: "wrong type", data: `{"email": "a@b.co", "age": "30"}`, want: []string{"/age:INVALID_TYPE"}},
+		{name: "not an integer", data: `{"email": "a@b.co", "age": 30.5}`, want: []string{"/age:INVALID_TYPE"}},
+		{name: "minimum", data: `{"email": "a@b.co", "age": 12}`, want: []string{"/age:VALUE_LOWER_MIN"}},
+		{name: "exclusive maximum", data: `{"email": "a@b.co", "age": 130}`, want: []string{"/age:VALUE_EXCEEDS_MAX"}},
+		{name: "format", data: `{"email": "nope", "age": 30}`, want: []string{"/email:INVALID_EMAIL_FORMAT"}},
+		{name: "empty format", data: `{"email": "", "age": 30}`, want: []string{"/email:INVALID_EMAIL_FORMAT"}},
+		{name: "empty allowed by anyOf", data: `{"email": "a@b.co", "age": 30, "contact": ""}`},
+		{
+			name: "string keywords",
+			data: `{"email": "a@b.co", "age": 30, "name": "a"}`,
+			want: []string{"/name:MIN_LENGTH_STRING", "/name:PATTERN_MISMATCH"},
+		},
+		{name: "enum", data: `{"email": "a@b.co", "age": 30, "role": "owner"}`, want: []string{"/role:ENUM_MISMATCH"}},
+		{
+			name: "array items",
+			data: `{"email": "a@b.co", "age": 30, "tags": ["a", 1, "c"]}`,
+			want: []string{"/tags/1:INVALID_TYPE", "/tags:MAX_LENGTH_SLICE"},
+		},
+		{name: "nested required", data: `{"email": "a@b.co", "age": 30, "address": {}}`, want: []string{"/address/zip:REQUIRED"}},
+		{name: "nested keyword", data: `{"email": "a@b.co", "age": 30, "address": {"zip": "1"}}`, want: []string{"/address/zip:MIN_LENGTH_STRING"}},
+		{name: "anyOf match", data: `{"email": "a@b.co", "age": 30, "contact": "10.0.0.1"}`},
+		{name: "anyOf mismatch", data: `{"email": "a@b.co", "age": 30, "contact": "nope"}`, want: []string{"/contact:ANY_OF_MISMATCH"}},
+		{name: "oneOf mismatch", data: `{"email": "a@b.co", "age": 30, "id": 7}`, want: []string{"/id:ONE_OF_MISMATCH"}},
+		{name: "oneOf match", data: `{"email": "a@b.co", "age": 30, "id": 7.5}`},
+		{name: "not", data: `{"email": "a@b.co", "age": 30, "nickname": "root"}`, want: []string{"/nickname:NOT_MISMATCH"}},
+		{name: "if then", data: `{"email": "a@b.co", "age": 30, "role": "admin"}`, want: []string{"/mfa:REQUIRED"}},
+		{name: "if else", data: `{"email": "a@b.co", "age": 30, "mfa": true}`, want: []string{"/mfa:FALSE_SCHEMA"}},
+		{name: "root type", data: `[1, 2]`, want: []string{":INVALID_TYPE"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var data any
+			if err := json.Unmarshal([]byte(tc.data), &data); err != nil {
+				t.Fatal(err)
+			}
+			err := rules.ValidateWithData(context.Background(), tree, rules.ProcessingHooks{}, "user", data)
+			if got := codesAt(err); !slices.Equal(got, tc.want) {
+				t.Errorf("errors = %v, want %v (err: %v)", got, tc.want, err)
+			}
+		})
+	}
+}
+
+func TestCompile_AllOfAndAnyOf(t *testing.T) {
+	tree, err := Compile([]byte(`{
+		"allOf": [
+			{"properties": {"a": {"type": "string"}}},
+			{"properties": {"b": {"minimum": 1}}}
+		],
+		"anyOf": [{"required": ["a"]}, {"required": ["b"]}]
+	}`))
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	report, err := rules.EvaluateMetricsWithData(context.Background(), tree, rules.ProcessingHooks{},
+		"schema", map[string]any{"a": 1, "b": 0})
+	if got, want := codesAt(err), []string{"/a:INVALID_TYPE", "/b:VALUE_LOWER_MIN"}; !slices.Equal(got, want) {
+		t.Errorf("errors = %v, want %v", got, want)
+	}
+	if report.Valid {
+		t.Error("report should be invalid")
+	}
+
+	err = rules.ValidateWithData(context.Background(), tree, rules.ProcessingHooks{}, "schema", map[string]any{})
+	if got, want := codesAt(err), []string{":ANY_OF_MISMATCH"}; !slices.Equal(got, want) {
+		t.Errorf("errors = %v, want %v", got, want)
+	}
+}
+
+func TestCompile_Errors(t *testing.T) {
+	for _, doc := range []string{
+		`not json`,
+		`42`,
+		`{"$ref": "#/$defs/x"}`,
+		`{"type": 7}`,
+		`{"minLength": -1}`,
+		`{"pattern": "("}`,
+		`{"anyOf": []}`,
+		`{"properties": {"a": "nope"}}`,
+	} {
+		if _, err := Compile([]byte(doc)); err == nil {
+			t.Errorf("Compile(%s): expected error", doc)
+		}
+	}
+}
+
+func TestCompile_Fingerprint(t *testing.T) {
+	compile := func(doc string) string {
+		tree, err := Compile([]byte(doc))
+		if err != nil {
+			t.Fatalf("Compile: %v", err)
+		}
+		return rules.Fingerprint(tree)
+	}
+
+	a := compile(`{"properties": {"age": {"minimum": 18}, "name": {"type": "string"}}}`)
+	b := compile(`{"properties": {"name": {"type": "string"}, "age": {"minimum": 18}}}`)
+	c := compile(`{"properties": {"age": {"minimum": 21}, "name": {"type": "string"}}}`)
+	if a != b {
+		t.Error("key order changed the fingerprint")
+	}
+	if a == c {
+		t.Error("different minimum produced the same fingerprint")
+	}
+
+	// Subschemas that become nodes are hashed by their nodes only, not again
+	// in the parameters of every enclosing schema.
+	s, err := parse(map[string]any{
+		"required":   []any{"user"},
+		"properties": map[string]any{"user": map[string]any{"properties": map[string]any{"age": map[string]any{"minimum": 18.0}}}},
+		"not":        map[string]any{"type": "null"},
+	}, "#")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	local := s.localRaw().(map[string]any)
+	if _, ok := local["properties"]; ok || local["required"] == nil || local["not"] == nil {
+		t.Errorf("local schema = %v, want required and not without properties", local)
+	}
+	deep := compile(`{"properties": {"a": {"properties": {"b": {"not": {"minimum": 1}}}}}}`)
+	if deep == compile(`{"properties": {"a": {"properties": {"b": {"not": {"minimum": 2}}}}}}`) {
+		t.Error("a change in a nested not schema kept the fingerprint")
+	}
+}
@@ -0,0 +1,263 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrKeyNotFound is returned by Loader.Get, and by the thunk Loader.Load
+// returns, when the batch function returned no value for the key.
+var ErrKeyNotFound = errors.New("rules: loader key not found")
+
+// ErrLoaderPanic is returned for the keys of a batch whose batch function
+// panicked, to the goroutines waiting for them.
+var ErrLoaderPanic = errors.New("rules: loader batch function panicked")
+
+// BatchFunc fetches the values of keys in one round-trip. Keys are unique.
+// Keys missing from the returned map resolve to ErrKeyNotFound; a non-nil
+// error is returned for every key of the batch.
+type BatchFunc[K comparable, V any] func(ctx context.Context, keys []K) (map[K]V, error)
+
+// Thunk returns a loaded value. It is returned by Loader.Load during Prepare
+// and called from IsValid or Validate, once the engine has flushed the batch.
+type Thunk[V any] func() (V, error)
+
+// Loader batches and deduplicates the keys that rules and conditions request
+// during Prepare. The engine flushes every pending key once at the end of
+// phase 1 (PrepareConditions) and once at the end of phase 3 (rule Prepare),
+// across all targets of a ValidateMulti or EvaluateMetricsMulti run, so a tree
+// that loads one record per rule costs one round-trip per phase.
+//
+// A Loader keeps no state: pending keys and loaded values live in a
+// per-evaluation session carried by the context, so results are cached for
+// one run and never leak between runs. Declare loaders once and share them
+// across goroutines like any other part of a tree.
+//
+// Example:
+//
+//	var customers = rules.NewLoader(func(ctx context.Context, ids []int) (map[int]Customer, error) {
+//	    return db.CustomersByID(ctx, ids)
+//	})
+//
+//	rule := rules.NewTypedRuleWithPrepare("customerActive",
+//	    func(ctx context.Context, o Order) (rules.Thunk[Customer], error) {
+//	        return customers.Load(ctx, o.CustomerID), nil
+//	    },
+//	    func(ctx context.Context, o Order, customer rules.Thunk[Customer]) error {
+//	        c, err := customer()
+//	        if err != nil {
+//	            return err
+//	        }
+//	        if !c.Active {
+//	            return rules.Error{Field: "customer", Err: "customer is inactive", Code: "CUSTOMER_INACTIVE"}
+//	        }
+//	        return nil
+//	    },
+//	)
+type Loader[K comparable, V any] struct {
+	fetch BatchFunc[K, V]
+}
+
+// NewLoader creates a Loader backed by fetch.
+func NewLoader[K comparable, V any](fetch BatchFunc[K, V]) *Loader[K, V] {
+	return &Loader[K, V]{fetch: fetch}
+}
+
+// Load registers key for the next flush and returns a thunk that reads its
+// value. Call it from Prepare; call the thunk from IsValid or Validate. Keys
+// already loaded in this evaluation are served from the cache.
+//
+// Outside the engine (no session in ctx), the thunk fetches the key on its
+// own when called.
+func (l *Loader[K, V]) Load(ctx context.Context, key K) Thunk[V] {
+	if session := loaderSessionFromContext(ctx); session != nil {
+		session.mu.Lock()
+		batchFor(session, l).register(key)
+		session.mu.Unlock()
+	}
+	return func() (V, error) { return l.Get(ctx, key) }
+}
+
+// Get returns the value of key. A key loaded during Prepare is read from the
+// flushed batch; any other key flushes this loader's pending keys right away,
+// so Get also works in IsValid, Validate, or outside the engine. A key whose
+// batch is being fetched by another goroutine is waited for, until ctx is
+// done.
+func (l *Loader[K, V]) Get(ctx context.Context, key K) (V, error) {
+	session := loaderSessionFromContext(ctx)
+	if session == nil {
+		return l.fetchOne(ctx, key)
+	}
+
+	session.mu.Lock()
+	b := batchFor(session, l)
+	entry := b.register(key)
+	session.mu.Unlock()
+
+	select {
+	case <-entry.ready:
+	default:
+		b.dispatch(ctx, session)
+		select {
+		case <-entry.ready:
+		case <-ctx.Done():
+			var zero V
+			return zero, ctx.Err()
+		}
+	}
+	return entry.value, entry.err
+}
+
+// fetchOne loads a single key without a session.
+func (l *Loader[K, V]) fetchOne(ctx context.Context, key K) (V, error) {
+	var zero V
+	values, err := l.fetch(ctx, []K{key})
+	if err != nil {
+		return zero, err
+	}
+	value, ok := values[key]
+	if !ok {
+		return zero, ErrKeyNotFound
+	}
+	return value, nil
+}
+
+type loaderSessionKey struct{}
+
+// loaderSession holds the pending keys and loaded values of every Loader used
+// during one run. It is shared by all targets of the run, and only created
+// once a Loader is used.
+type loaderSession struct {
+	mu      sync.Mutex
+	batches map[any]dispatcher // keyed by *Loader
+	order   []dispatcher       // flush order: first use
+}
+
+// dispatcher is a loader batch with its key and value types erased.
+type dispatcher interface {
+	// dispatch fetches the pending keys and reports whether there were any.
+	dispatch(ctx context.Context, session *loaderSession) bool
+}
+
+// withLoaderSession returns a context carrying session.
+func withLoaderSession(ctx context.Context, session *loaderSession) context.Context {
+	return context.WithValue(ctx, loaderSessionKey{}, session)
+}
+
+// loaderSessionFromContext returns the loaderSession of the run evaluating
+// ctx, creating it on first use, or the session attached with
+// withLoaderSession, or nil.
+func loaderSessionFromContext(ctx context.Context) *loaderSession {
+	if state := targetStateFromContext(ctx); state != nil && state.run != nil {
+		return state.run.loaderSession()
+	}
+	session, _ := ctx.Value(loaderSessionKey{}).(*loaderSession)
+	return session
+}
+
+// newLoaderSession creates an empty session.
+func newLoaderSession() *loaderSession {
+	return &loaderSession{batches: make(map[any]dispatcher)}
+}
+
+// flush dispatches every pending key. A batch function may itself load keys
+// (from this or another loader), so flush repeats until nothing is pending.
+func (s *loaderSession) flush(ctx context.Context) {
+	for {
+		s.mu.Lock()
+		batches := append([]dispatcher(nil), s.order...)
+		s.mu.Unlock()
+
+		fetched := false
+		for _, b := range batches {
+			if b.dispatch(ctx, s) {
+				fetched = true
+			}
+		}
+		if !fetched {
+			return
+		}
+	}
+}
+
+// loaderEntry is the cached result of one key. ready is closed once value
+// and err are set.
+type loaderEntry[V any] struct {
+	value V
+	err   error
+	ready chan struct{}
+}
+
+// loaderBatch is the per-session state of one Loader.
+type loaderBatch[K comparable, V any] struct {
+	loader  *Loader[K, V]
+	entries map[K]*loaderEntry[V]
+	pending []K
+}
+
+// batchFor returns the batch of l in session, creating it on first use. The
+// caller must hold session.mu.
+func batchFor[K comparable, V any](session *loaderSession, l *Loader[K, V]) *loaderBatch[K, V] {
+	if b, ok := session.batches[l]; ok {
+		return b.(*loaderBatch[K, V])
+	}
+	b := &loaderBatch[K, V]{loader: l, entries: make(map[K]*loaderEntry[V])}
+	session.batches[l] = b
+	session.order = append(session.order, b)
+	return b
+}
+
+// register returns the entry of key, queueing it when it is new. The caller
+// must hold the session lock.
+func (b *loaderBatch[K, V]) register(key K) *loaderEntry[V] {
+	if entry, ok := b.entries[key]; ok {
+		return entry
+	}
+	entry := &loaderEntry[V]{ready: make(chan struct{})}
+	b.entries[key] = entry
+	b.pending = append(b.pending, key)
+	return entry
+}
+
+// dispatch fetches the pending keys in one call and fans the results, or the
+// batch error, out to their entries, releasing the goroutines waiting for
+// them. The session lock is not held while the batch function runs; if it
+// panics, the entries fail with ErrLoaderPanic before the panic propagates.
+func (b *loaderBatch[K, V]) dispatch(ctx context.Context, session *loaderSession) bool {
+	session.mu.Lock()
+	keys := b.pending
+	b.pending = nil
+	session.mu.Unlock()
+
+	if len(keys) == 0 {
+		return false
+	}
+
+	var values map[K]V
+	err := ErrLoaderPanic
+	defer func() {
+		session.mu.Lock()
+		defer session.mu.Unlock()
+		b.resolve(keys, values, err)
+	}()
+	values, err = b.loader.fetch(ctx, keys)
+	return true
+}
+
+// resolve sets the results of keys and closes their entries. The caller must
+// hold the session lock.
+func (b *loaderBatch[K, V]) resolve(keys []K, values map[K]V, err error) {
+	for _, key := range keys {
+		entry := b.entries[key]
+		switch value, ok := values[key]; {
+		case err != nil:
+			entry.err = err
+		case !ok:
+			entry.err = ErrKeyNotFound
+		default:
+			entry.value = value
+		}
+		close(entry.ready)
+	}
+}
@@ -0,0 +1,289 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// recordingFetch returns a batch function over a fixed table that records the
+// keys of every call.
+func recordingFetch(table map[int]string, fail error) (BatchFunc[int, string], func() [][]int) {
+	var mu sync.Mutex
+	var calls [][]int
+	fetch := func(ctx context.Context, keys []int) (map[int]string, error) {
+		mu.Lock()
+		calls = append(calls, slices.Sorted(slices.Values(keys)))
+		mu.Unlock()
+		if fail != nil {
+			return nil, fail
+		}
+		out := make(map[int]string, len(keys))
+		for _, k := range keys {
+			if v, ok := table[k]; ok {
+				out[k] = v
+			}
+		}
+		return out, nil
+	}
+	return fetch, func() [][]int {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(calls)
+	}
+}
+
+type loaderOrder struct {
+	ID       int
+	Customer int
+	Seller   int
+}
+
+// loaderTree gates on the seller (loaded in phase 1) and checks the customer
+// (loaded in phase 3).
+func loaderTree(names *Loader[int, string]) Evaluable {
+	return Node(
+		NewTypedConditionWithPrepare("sellerKnown",
+			func(ctx context.Context, o loaderOrder) (Thunk[string], error) {
+				return names.Load(ctx, o.Seller), nil
+			},
+			func(ctx context.Context, o loaderOrder, seller Thunk[string]) bool {
+				_, err := seller()
+				return err == nil
+			},
+		),
+		Rules(NewTypedRuleWithPrepare("customerNotBanned",
+			func(ctx context.Context, o loaderOrder) (Thunk[string], error) {
+				return names.Load(ctx, o.Customer), nil
+			},
+			func(ctx context.Context, o loaderOrder, customer Thunk[string]) error {
+				name, err := customer()
+				if err != nil {
+					return err
+				}
+				if name == "mallory" {
+					return Error{Field: "customer", Err: "customer is banned", Code: "BANNED"}
+				}
+				return nil
+			},
+		)),
+	)
+}
+
+func TestLoader_BatchesPerPhaseAcrossTargets(t *testing.T) {
+	t.Parallel()
+
+	fetch, calls := recordingFetch(map[int]string{1: "alice", 2: "bob", 3: "mallory", 10: "shop", 11: "store"}, nil)
+	tree := loaderTree(NewLoader(fetch))
+
+	err := ValidateMultiWithData(context.Background(), []TreeAndData{
+		{Tree: tree, Data: loaderOrder{ID: 1, Customer: 1, Seller: 10}},
+		{Tree: tree, Data: loaderOrder{ID: 2, Customer: 2, Seller: 11}},
+		{Tree: tree, Data: loaderOrder{ID: 3, Customer: 3, Seller: 10}},
+	}, ProcessingHooks{}, "orders")
+
+	var re Error
+	if !errors.As(err, &re) || re.Code != "BANNED" {
+		t.Errorf("expected BANNED error, got %v", err)
+	}
+
+	// Phase 1 loads the sellers, deduplicated; phase 3 loads the customers.
+	want := [][]int{{10, 11}, {1, 2, 3}}
+	if got := calls(); !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("batch calls = %v, want %v", got, want)
+	}
+}
+
+func TestLoader_ErrorFanOut(t *testing.T) {
+	t.Parallel()
+
+	boom := errors.New("database down")
+	fetch, calls := recordingFetch(nil, boom)
+	names := NewLoader(fetch)
+
+	tree := Rules(
+		NewTypedRuleWithPrepare("first",
+			func(ctx context.Context, o loaderOrder) (Thunk[string], error) {
+				return names.Load(ctx, o.Customer), nil
+			},
+			func(ctx context.Context, o loaderOrder, v Thunk[string]) error { _, err := v(); return err },
+		),
+		NewTypedRuleWithPrepare("second",
+			func(ctx context.Context, o loaderOrder) (Thunk[string], error) { return names.Load(ctx, o.Seller), nil },
+			func(ctx context.Context, o loaderOrder, v Thunk[string]) error { _, err := v(); return err },
+		),
+	)
+
+	err := ValidateWithData(context.Background(), tree, ProcessingHooks{}, "order", loaderOrder{Customer: 1, Seller: 2})
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok || len(joined.Unwrap()) != 2 {
+		t.Fatalf("expected both rules to fail, got %v", err)
+	}
+	for _, e := range joined.Unwrap() {
+		if !errors.Is(e, boom) {
+			t.Errorf("error = %v, want %v", e, boom)
+		}
+	}
+	if got := len(calls()); got != 1 {
+		t.Errorf("batch calls = %d, want 1", got)
+	}
+}
+
+func TestLoader_MissingKeyAndLateGet(t *testing.T) {
+	t.Parallel()
+
+	fetch, calls := recordingFetch(map[int]string{1: "alice", 2: "bob"}, nil)
+	names := NewLoader(fetch)
+
+	var late string
+	tree := Rules(
+		NewTypedRuleWithPrepare("missing",
+			func(ctx context.Context, o loaderOrder) (Thunk[string], error) { return names.Load(ctx, 99), nil },
+			func(ctx context.Context, o loaderOrder, v Thunk[string]) error { _, err := v(); return err },
+		),
+		NewTypedRule("late", func(ctx context.Context, o loaderOrder) error {
+			// Not registered during Prepare: fetched on demand, and cached
+			// for the rest of the run.
+			v, err := names.Get(ctx, 2)
+			late = v
+			if err != nil {
+				return err
+			}
+			_, err = names.Get(ctx, 2)
+			return err
+		}),
+	)
+
+	err := ValidateWithData(context.Background(), tree, ProcessingHooks{}, "order", loaderOrder{})
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("error = %v, want ErrKeyNotFound", err)
+	}
+	if late != "bob" {
+		t.Errorf("late Get = %q, want bob", late)
+	}
+	if want := [][]int{{99}, {2}}; !slices.EqualFunc(calls(), want, slices.Equal) {
+		t.Errorf("batch calls = %v, want %v", calls(), want)
+	}
+}
+
+func TestLoader_CacheIsPerRun(t *testing.T) {
+	t.Parallel()
+
+	fetch, calls := recordingFetch(map[int]string{1: "alice", 10: "shop"}, nil)
+	tree := loaderTree(NewLoader(fetch))
+
+	for i := range 2 {
+		if err := ValidateWithData(context.Background(), tree, ProcessingHooks{}, "order",
+			loaderOrder{Customer: 1, Seller: 10}); err != nil {
+			t.Fatalf("run %d: unexpected error: %v", i, err)
+		}
+	}
+	if got := len(calls()); got != 4 {
+		t.Errorf("batch calls = %d, want 4 (two per run)", got)
+	}
+}
+
+func TestLoader_OutsideEngine(t *testing.T) {
+	t.Parallel()
+
+	fetch, calls := recordingFetch(map[int]string{1: "alice"}, nil)
+	names := NewLoader(fetch)
+	ctx := context.Background()
+
+	if v, err := names.Load(ctx, 1)(); err != nil || v != "alice" {
+		t.Errorf("Load thunk = %q, %v", v, err)
+	}
+	if _, err := names.Get(ctx, 2); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Get(2) error = %v, want ErrKeyNotFound", err)
+	}
+	if got := fmt.Sprint(calls()); got != "[[1] [2]]" {
+		t.Errorf("batch calls = %s", got)
+	}
+}
+
+func TestLoader_ConcurrentGetInFlight(t *testing.T) {
+	t.Parallel()
+
+	started, release := make(chan struct{}), make(chan struct{})
+	names := NewLoader(func(ctx context.Context, keys []int) (map[int]string, error) {
+		close(started)
+		<-release
+		return map[int]string{1: "alice"}, nil
+	})
+	ctx := withLoaderSession(context.Background(), newLoaderSession())
+
+	type result struct {
+		value string
+		err   error
+	}
+	leader := make(chan result)
+	go func() {
+		v, err := names.Get(ctx, 1)
+		leader <- result{v, err}
+	}()
+	<-started
+
+	// The key is in flight: a canceled waiter gives up, the others wait.
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := names.Get(canceled, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled Get error = %v, want context.Canceled", err)
+	}
+	var wg sync.WaitGroup
+	waiters := make([]result, 4)
+	for i := range waiters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := names.Get(ctx, 1)
+			waiters[i] = result{v, err}
+		}()
+	}
+	close(release)
+	wg.Wait()
+
+	if got := <-leader; got.value != "alice" || got.err != nil {
+		t.Errorf("leader Get = %q, %v", got.value, got.err)
+	}
+	for i, got := range waiters {
+		if got.value != "alice" || got.err != nil {
+			t.Errorf("waiter %d Get = %q, %v, want alice", i, got.value, got.err)
+		}
+	}
+}
+
+func TestLoader_PanicReleasesWaiters(t *testing.T) {
+	t.Parallel()
+
+	started, release := make(chan struct{}), make(chan struct{})
+	names := NewLoader(func(ctx context.Context, keys []int) (map[int]string, error) {
+		close(started)
+		<-release
+		panic("database driver bug")
+	})
+	ctx := withLoaderSession(context.Background(), newLoaderSession())
+
+	recovered := make(chan any)
+	go func() {
+		defer func() { recovered <- recover() }()
+		_, _ = names.Get(ctx, 1)
+	}()
+	<-started
+
+	waited := make(chan error)
+	go func() {
+		_, err := names.Get(ctx, 1)
+		waited <- err
+	}()
+	close(release)
+
+	if r := <-recovered; r != "database driver bug" {
+		t.Errorf("leader recovered %v, want the batch function's panic", r)
+	}
+	if err := <-waited; !errors.Is(err, ErrLoaderPanic) {
+		t.Errorf("waiter error = %v, want ErrLoaderPanic", err)
+	}
+}
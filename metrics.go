@@ -4,7 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"math"
+	"reflect"
 	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Kind identifies the type of metric an outcome carries.
@@ -21,6 +27,15 @@ const (
 	KindHistogram
 	// KindScore is a numeric score, optionally weighted (e.g. risk, health).
 	KindScore
+	// KindGauge is a point-in-time value with its timestamp (e.g. queue
+	// depth, balance).
+	KindGauge
+	// KindDistinct is a cardinality estimate of the items seen (e.g. unique
+	// merchants), carried as a mergeable HyperLogLog sketch.
+	KindDistinct
+	// KindSummary is a streaming quantile sketch of observed values,
+	// mergeable across targets.
+	KindSummary
 )
 
 func (k Kind) String() string {
@@ -33,13 +48,20 @@ func (k Kind) String() string {
 		return "histogram"
 	case KindScore:
 		return "score"
+	case KindGauge:
+		return "gauge"
+	case KindDistinct:
+		return "distinct"
+	case KindSummary:
+		return "summary"
 	default:
 		return fmt.Sprintf("kind(%d)", int(k))
 	}
 }
 
 // Aggregation defines how multiple outcomes that share the same metric name
-// are combined when the report is built.
+// are combined when the report is built. Besides the built-in aggregations,
+// custom ones can be registered with RegisterAggregation.
 type Aggregation int
 
 const (
@@ -47,21 +69,68 @@ const (
 	AggNone Aggregation = iota
 	// AggSum adds values (counter, score).
 	AggSum
-	// AggMax keeps the largest value.
+	// AggMax keeps the largest value; for KindValid, like AggAnyTrue.
 	AggMax
-	// AggMin keeps the smallest value.
+	// AggMin keeps the smallest value; for KindValid, like AggAllTrue.
 	AggMin
 	// AggAvg averages the values.
 	AggAvg
 	// AggWeightedAvg computes a weighted average using each outcome's Weight
 	// (score only).
 	AggWeightedAvg
-	// AggMerge combines histograms bucket-wise (histogram only).
+	// AggMerge combines histograms bucket-wise, and merges the sketches of
+	// distinct counts and summaries.
 	AggMerge
-	// AggLast keeps the last emitted value.
+	// AggLast keeps the last emitted value; for gauges, the one with the
+	// latest Time.
 	AggLast
+	// AggMedian keeps the median value (counter, score, gauge), the mean of
+	// the two middle values for groups of even size.
+	AggMedian
+	// AggCount replaces the value with the number of outcomes: Count, Score
+	// or Value for counters, scores and gauges, and Count for KindValid,
+	// whose outcome stays valid when every outcome is.
+	AggCount
+	// AggAnyTrue makes a KindValid outcome valid when any outcome is valid.
+	AggAnyTrue
+	// AggAllTrue makes a KindValid outcome valid when every outcome is valid
+	// (the KindValid default).
+	AggAllTrue
 )
 
+func (a Aggregation) String() string {
+	switch a {
+	case AggNone:
+		return "none"
+	case AggSum:
+		return "sum"
+	case AggMax:
+		return "max"
+	case AggMin:
+		return "min"
+	case AggAvg:
+		return "avg"
+	case AggWeightedAvg:
+		return "weighted_avg"
+	case AggMerge:
+		return "merge"
+	case AggLast:
+		return "last"
+	case AggMedian:
+		return "median"
+	case AggCount:
+		return "count"
+	case AggAnyTrue:
+		return "any_true"
+	case AggAllTrue:
+		return "all_true"
+	}
+	if name, ok := registeredAggregationName(a); ok {
+		return name
+	}
+	return fmt.Sprintf("aggregation(%d)", int(a))
+}
+
 // Histogram is a distribution of observed values over le (less-than-or-equal)
 // bucket boundaries. Counts are cumulative per boundary, following standard
 // Prometheus-style histogram semantics.
@@ -101,26 +170,145 @@ func (h *Histogram) Observe(v float64) {
 	}
 }
 
+// Quantile estimates the q-quantile (0 <= q <= 1, e.g. 0.95 for p95) of the
+// observations by linear interpolation within the bucket the quantile falls
+// in, as Prometheus' histogram_quantile does. The lower bound of the first
+// bucket is 0 when its boundary is positive. A quantile that falls above the
+// largest finite boundary is reported as that boundary. Quantile returns NaN
+// for a histogram without observations or buckets; q is clamped to [0, 1].
+//
+// Example:
+//
+//	p99 := report.Metrics["latency_ms"].Histogram.Quantile(0.99)
+func (h Histogram) Quantile(q float64) float64 {
+	if h.Total == 0 || len(h.Buckets) == 0 || math.IsNaN(q) {
+		return math.NaN()
+	}
+	rank := min(max(q, 0), 1) * float64(h.Total)
+
+	lower, prev := 0.0, uint64(0)
+	for i, upper := range h.Buckets {
+		if i >= len(h.Counts) {
+			break
+		}
+		if math.IsInf(upper, 1) {
+			// The quantile is beyond the last finite boundary.
+			break
+		}
+		if i == 0 && upper <= 0 {
+			lower = upper
+		}
+		if count := h.Counts[i]; float64(count) >= rank {
+			inBucket := float64(count - prev)
+			if inBucket == 0 {
+				return lower
+			}
+			return lower + (upper-lower)*(rank-float64(prev))/inBucket
+		}
+		lower, prev = upper, h.Counts[i]
+	}
+	return lower
+}
+
+// LinearBuckets returns count le boundaries starting at start and spaced by
+// width, e.g. LinearBuckets(10, 10, 5) is [10 20 30 40 50]. It returns nil
+// when count is not positive.
+func LinearBuckets(start, width float64, count int) []float64 {
+	if count < 1 {
+		return nil
+	}
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start + float64(i)*width
+	}
+	return buckets
+}
+
+// ExponentialBuckets returns count le boundaries starting at start, each one
+// factor times the previous, e.g. ExponentialBuckets(1, 2, 5) is
+// [1 2 4 8 16]. It returns nil when count is not positive, start is not
+// positive or factor is not greater than 1.
+//
+// Example:
+//
+//	latency := rules.NewHistogram(rules.ExponentialBuckets(5, 2, 10)) // 5ms .. 2.56s
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	if count < 1 || start <= 0 || factor <= 1 {
+		return nil
+	}
+	buckets := make([]float64, count)
+	buckets[0] = start
+	for i := 1; i < count; i++ {
+		buckets[i] = buckets[i-1] * factor
+	}
+	return buckets
+}
+
 // Outcome is a metric value carried by a rule. The value fields that matter
-// depend on Kind: Count for KindCounter, Histogram for KindHistogram, Score
-// and Weight for KindScore, Valid and Err for KindValid.
+// depend on Kind: Count for KindCounter, Histogram or Exponential for
+// KindHistogram, Score and Weight for KindScore, Valid and Err for KindValid,
+// Value and Time for KindGauge, Distinct for KindDistinct and Summary for
+// KindSummary.
 type Outcome struct {
 	Kind  Kind
-	Name  string // metric name, used as the report key
+	Name  string // metric name, the report key together with Labels
 	Field string // label/dimension (e.g. "revenue", "latency_ms")
 	Valid bool
 	Err   error
 
 	Count     float64
 	Histogram Histogram
-	Score     float64
-	Weight    float64 // weight used by AggWeightedAvg (default 1)
+	// Exponential is a native exponential histogram, which merges across
+	// outcomes without the boundary constraints of Histogram.
+	Exponential ExponentialHistogram
+	Score       float64
+	Weight      float64 // weight used by AggWeightedAvg (default 1)
+	Value       float64
+	Time        time.Time // when Value was read
+	Distinct    HyperLogLog
+	Summary     Summary
 
 	// Aggregation overrides how this outcome combines with same-name
 	// outcomes during report aggregation. AggNone uses the kind default.
 	Aggregation Aggregation
 
-	Labels map[string]string // extra fixed dimensions
+	Labels map[string]string // extra dimensions, each label set is its own series
+}
+
+// MetricName returns the name the outcome is reported under: its Name, or its
+// Field when Name is empty.
+func (o Outcome) MetricName() string {
+	if o.Name != "" {
+		return o.Name
+	}
+	return o.Field
+}
+
+// SeriesKey returns the Report.Metrics key of the series of metric name with
+// the given labels: the name alone when there are no labels, and otherwise
+// the name followed by the labels sorted by key, with quoted values.
+//
+// Example:
+//
+//	key := rules.SeriesKey("latency", map[string]string{"tier": "gold", "region": "eu"})
+//	// latency{region="eu",tier="gold"}
+func SeriesKey(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, k := range slices.Sorted(maps.Keys(labels)) {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[k]))
+	}
+	b.WriteByte('}')
+	return b.String()
 }
 
 // CounterValue returns a KindCounter outcome carrying a numeric count.
@@ -134,6 +322,13 @@ func ScoreValue(score, weight float64) Outcome {
 	return Outcome{Kind: KindScore, Score: score, Weight: weight}
 }
 
+// GaugeValue returns a KindGauge outcome carrying a value read at the given
+// time. Same-name gauges keep the latest value by default; AggMax and AggMin
+// keep the extreme value together with its time.
+func GaugeValue(v float64, at time.Time) Outcome {
+	return Outcome{Kind: KindGauge, Value: v, Time: at}
+}
+
 // HistogramValue returns a KindHistogram outcome carrying a distribution.
 func HistogramValue(h Histogram) Outcome {
 	return Outcome{Kind: KindHistogram, Histogram: h}
@@ -180,7 +375,8 @@ func (c *outcomeCollector) add(o Outcome) {
 // control the report key.
 //
 // When the tree is evaluated with Validate instead of EvaluateMetrics there
-// is no collector in the context and Emit is a no-op.
+// is no collector in the context and Emit only forwards the outcome to the
+// OutcomeSink, if any.
 //
 // Example:
 //
@@ -192,15 +388,107 @@ func Emit(ctx context.Context, o Outcome) {
 	if collector := outcomeCollectorFromContext(ctx); collector != nil {
 		collector.add(o)
 	}
+	if sink := outcomeSinkFromContext(ctx); sink != nil {
+		sink.RecordOutcome(ctx, runNameFromContext(ctx), o)
+	}
+	scorecardScopeFromContext(ctx).collect(o)
+}
+
+// OutcomeSink receives every outcome emitted during an evaluation, through
+// Emit or the metric rules, before any aggregation. tree is the name passed
+// to the evaluation entry point. Implementations must be safe for concurrent
+// use: concurrent evaluations share the sink.
+//
+// See the rulesotel module for a sink forwarding outcomes to OpenTelemetry.
+type OutcomeSink interface {
+	RecordOutcome(ctx context.Context, tree string, o Outcome)
+}
+
+// outcomeSinkKey is the context key for the outcome sink.
+type outcomeSinkKey struct{}
+
+// WithOutcomeSink returns a context whose evaluations forward every emitted
+// outcome to sink, with Validate as well as EvaluateMetrics.
+//
+// Example:
+//
+//	ctx := rules.WithOutcomeSink(context.Background(), sink)
+//	err := rules.ValidateWithData(ctx, tree, hooks, "checkout", order)
+func WithOutcomeSink(ctx context.Context, sink OutcomeSink) context.Context {
+	return context.WithValue(ctx, outcomeSinkKey{}, sink)
+}
+
+// outcomeSinkFromContext returns the sink attached to ctx, or nil.
+func outcomeSinkFromContext(ctx context.Context) OutcomeSink {
+	sink, _ := ctx.Value(outcomeSinkKey{}).(OutcomeSink)
+	return sink
+}
+
+// MetricOption configures the outcomes of a metric rule. The configuration
+// is part of the rule's fingerprint.
+type MetricOption func(*metricConfig)
+
+// metricConfig holds the MetricOptions of a metric rule.
+type metricConfig struct {
+	aggregation Aggregation
+	labels      map[string]string
+}
+
+// WithMetricAggregation sets the aggregation of the rule's outcomes, unless
+// the rule function sets one itself.
+//
+// Example:
+//
+//	rule := rules.NewTypedMetricRule[Order]("latency", rules.KindGauge, "latency",
+//	    func(ctx context.Context, o Order) (rules.Outcome, error) {
+//	        return rules.GaugeValue(o.Latency, time.Now()), nil
+//	    }, rules.WithMetricAggregation(rules.AggMax))
+func WithMetricAggregation(agg Aggregation) MetricOption {
+	return func(c *metricConfig) { c.aggregation = agg }
+}
+
+// WithMetricLabels adds labels to the rule's outcomes. Labels set by the
+// rule function take precedence over these.
+//
+// Example:
+//
+//	rule := rules.NewMetricRulePure("orders", rules.KindCounter, "orders", count,
+//	    rules.WithMetricLabels(map[string]string{"region": "eu"}))
+func WithMetricLabels(labels map[string]string) MetricOption {
+	return func(c *metricConfig) { c.labels = maps.Clone(labels) }
+}
+
+// newMetricConfig applies opts.
+func newMetricConfig(opts []MetricOption) metricConfig {
+	var c metricConfig
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// params returns the parameters of a metric rule with the given kind and
+// field. Aggregations are hashed by name: the values of registered
+// aggregations depend on registration order.
+func (c metricConfig) params(kind Kind, field string) []any {
+	return []any{kind, field, c.aggregation.String(), c.labels}
 }
 
-// fillOutcome stamps the constructor-declared name, field and kind onto an
-// outcome so report aggregation is consistent regardless of how the rule
-// function built the value.
-func fillOutcome(name, field string, kind Kind, o Outcome) Outcome {
+// fillOutcome stamps the constructor-declared name, field, kind and options
+// onto an outcome so report aggregation is consistent regardless of how the
+// rule function built the value.
+func fillOutcome(name, field string, kind Kind, config metricConfig, o Outcome) Outcome {
 	o.Name = name
 	o.Field = field
 	o.Kind = kind
+	if o.Aggregation == AggNone {
+		o.Aggregation = config.aggregation
+	}
+	if len(config.labels) > 0 {
+		labels := maps.Clone(config.labels)
+		maps.Copy(labels, o.Labels)
+		o.Labels = labels
+	}
 	return o
 }
 
@@ -209,10 +497,11 @@ func fillOutcome(name, field string, kind Kind, o Outcome) Outcome {
 // single-use trees.
 type MetricRulePure struct {
 	RuleBase
-	name  string
-	kind  Kind
-	field string
-	fn    func() (Outcome, error)
+	name   string
+	kind   Kind
+	field  string
+	fn     func() (Outcome, error)
+	config metricConfig
 }
 
 var _ Rule = (*MetricRulePure)(nil)
@@ -220,6 +509,9 @@ var _ Rule = (*MetricRulePure)(nil)
 // Name returns the rule name.
 func (r *MetricRulePure) Name() string { return r.name }
 
+// Params returns the kind, field and options of the carried outcome.
+func (r *MetricRulePure) Params() []any { return r.config.params(r.kind, r.field) }
+
 // Prepare is a no-op for pure rules.
 func (r *MetricRulePure) Prepare(context.Context) (any, error) { return nil, nil }
 
@@ -234,7 +526,7 @@ func (r *MetricRulePure) Validate(ctx context.Context) error {
 		}
 	}
 	outcome, err := r.fn()
-	Emit(ctx, fillOutcome(r.name, r.field, r.kind, outcome))
+	Emit(ctx, fillOutcome(r.name, r.field, r.kind, r.config, outcome))
 	return err
 }
 
@@ -247,18 +539,19 @@ func (r *MetricRulePure) Validate(ctx context.Context) error {
 //	rule := rules.NewMetricRulePure("mrr", rules.KindCounter, "mrr", func() (rules.Outcome, error) {
 //	    return rules.CounterValue(1250.5), nil
 //	})
-func NewMetricRulePure(name string, kind Kind, field string, fn func() (Outcome, error)) Rule {
-	return &MetricRulePure{name: name, kind: kind, field: field, fn: fn}
+func NewMetricRulePure(name string, kind Kind, field string, fn func() (Outcome, error), opts ...MetricOption) Rule {
+	return &MetricRulePure{name: name, kind: kind, field: field, fn: fn, config: newMetricConfig(opts)}
 }
 
 // TypedMetricRule is a pure metric-carrying rule that reads data of type T
 // from the data registry at validation time. Used by NewTypedMetricRule.
 type TypedMetricRule[T any] struct {
 	RuleBase
-	name  string
-	kind  Kind
-	field string
-	fn    func(ctx context.Context, data T) (Outcome, error)
+	name   string
+	kind   Kind
+	field  string
+	fn     func(ctx context.Context, data T) (Outcome, error)
+	config metricConfig
 }
 
 var _ Rule = (*TypedMetricRule[any])(nil)
@@ -266,6 +559,9 @@ var _ Rule = (*TypedMetricRule[any])(nil)
 // Name returns the rule name.
 func (r *TypedMetricRule[T]) Name() string { return r.name }
 
+// Params returns the kind, field and options of the carried outcome.
+func (r *TypedMetricRule[T]) Params() []any { return r.config.params(r.kind, r.field) }
+
 // Prepare is a no-op for pure rules.
 func (r *TypedMetricRule[T]) Prepare(context.Context) (any, error) { return nil, nil }
 
@@ -290,7 +586,7 @@ func (r *TypedMetricRule[T]) Validate(ctx context.Context) error {
 		}
 	}
 	outcome, err := r.fn(ctx, data)
-	Emit(ctx, fillOutcome(r.name, r.field, r.kind, outcome))
+	Emit(ctx, fillOutcome(r.name, r.field, r.kind, r.config, outcome))
 	return err
 }
 
@@ -304,8 +600,8 @@ func (r *TypedMetricRule[T]) Validate(ctx context.Context) error {
 //	    func(ctx context.Context, u User) (rules.Outcome, error) {
 //	        return rules.ScoreValue(u.Engagement, 1), nil
 //	    })
-func NewTypedMetricRule[T any](name string, kind Kind, field string, fn func(ctx context.Context, data T) (Outcome, error)) Rule {
-	return &TypedMetricRule[T]{name: name, kind: kind, field: field, fn: fn}
+func NewTypedMetricRule[T any](name string, kind Kind, field string, fn func(ctx context.Context, data T) (Outcome, error), opts ...MetricOption) Rule {
+	return &TypedMetricRule[T]{name: name, kind: kind, field: field, fn: fn, config: newMetricConfig(opts)}
 }
 
 // TypedMetricRuleDataFunc is a rule with Prepare support, type-safe data
@@ -322,13 +618,20 @@ type TypedMetricRuleDataFunc[In any, T any] struct {
 	field   string
 	prepare func(ctx context.Context, input In) (T, error)
 	fn      func(ctx context.Context, input In, data T) (Outcome, error)
+	config  metricConfig
 }
 
 var _ Rule = (*TypedMetricRuleDataFunc[any, any])(nil)
 
+// preparedType returns T, the type of the data recorded by Prepare.
+func (r *TypedMetricRuleDataFunc[In, T]) preparedType() reflect.Type { return reflect.TypeFor[T]() }
+
 // Name returns the rule name.
 func (r *TypedMetricRuleDataFunc[In, T]) Name() string { return r.name }
 
+// Params returns the kind, field and options of the carried outcome.
+func (r *TypedMetricRuleDataFunc[In, T]) Params() []any { return r.config.params(r.kind, r.field) }
+
 // Prepare reads the typed input from the data registry, runs the prepare
 // function, and records the retrieved data in the per-evaluation preparedStore
 // keyed by this rule. The rule keeps no state.
@@ -381,7 +684,7 @@ func (r *TypedMetricRuleDataFunc[In, T]) Validate(ctx context.Context) error {
 	}
 
 	outcome, err := r.fn(ctx, input, loaded)
-	Emit(ctx, fillOutcome(r.name, r.field, r.kind, outcome))
+	Emit(ctx, fillOutcome(r.name, r.field, r.kind, r.config, outcome))
 	return err
 }
 
@@ -409,6 +712,7 @@ func (r *TypedMetricRuleDataFunc[In, T]) Validate(ctx context.Context) error {
 func NewTypedMetricRuleWithPrepare[In any, T any](name string, kind Kind, field string,
 	prepare func(ctx context.Context, input In) (T, error),
 	fn func(ctx context.Context, input In, data T) (Outcome, error),
+	opts ...MetricOption,
 ) Rule {
 	return &TypedMetricRuleDataFunc[In, T]{
 		name:    name,
@@ -416,6 +720,7 @@ func NewTypedMetricRuleWithPrepare[In any, T any](name string, kind Kind, field
 		field:   field,
 		prepare: prepare,
 		fn:      fn,
+		config:  newMetricConfig(opts),
 	}
 }
 
@@ -431,9 +736,39 @@ type Report struct {
 	Valid bool
 	// Errors contains rule validation errors and outcome errors.
 	Errors []error
-	// Metrics holds the aggregated outcome of every emitted metric, keyed by
-	// metric name.
+	// Metrics holds the aggregated outcome of every emitted series, keyed by
+	// SeriesKey: the metric name for unlabeled outcomes, and the name plus
+	// the sorted label set otherwise. Use Series to read every label set of a
+	// metric.
 	Metrics map[string]Outcome
+	// TreeVersion identifies the tree version that was evaluated when the
+	// tree came from a TreeStore; it is the zero value otherwise.
+	TreeVersion TreeVersion
+	// Fingerprint is the Fingerprint of the evaluated tree.
+	Fingerprint string
+}
+
+// Series returns every series of the metric name, one per label set, sorted
+// by series key. The unlabeled series, if any, comes first.
+//
+// Example:
+//
+//	for _, s := range report.Series("latency") {
+//	    fmt.Println(s.Labels["region"], s.Histogram.Total)
+//	}
+func (r Report) Series(name string) []Outcome {
+	var keys []string
+	for key, o := range r.Metrics {
+		if metric := o.MetricName(); metric == name || metric == "" && key == name {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	series := make([]Outcome, len(keys))
+	for i, key := range keys {
+		series[i] = r.Metrics[key]
+	}
+	return series
 }
 
 // defaultAggregation returns the kind-specific default aggregation.
@@ -441,32 +776,32 @@ func defaultAggregation(k Kind) Aggregation {
 	switch k {
 	case KindCounter:
 		return AggSum
-	case KindHistogram:
+	case KindHistogram, KindDistinct, KindSummary:
 		return AggMerge
 	case KindScore:
 		return AggWeightedAvg
+	case KindValid:
+		return AggAllTrue
 	default:
 		return AggLast
 	}
 }
 
-// aggregateOutcomes groups emitted outcomes by name and combines each group
-// using the aggregation carried by its first outcome. Outcomes that carry
-// neither a Name nor a Field have no report slot and are dropped; their
-// errors, if any, are still surfaced by the driver.
+// aggregateOutcomes groups emitted outcomes by series, i.e. by name and label
+// set, and combines each group using the aggregation carried by its first
+// outcome. Outcomes that carry neither a Name nor a Field have no report slot
+// and are dropped; their errors, if any, are still surfaced by the driver.
 func aggregateOutcomes(outcomes []Outcome) Report {
 	report := Report{Metrics: make(map[string]Outcome, len(outcomes))}
 
 	groups := make(map[string][]Outcome, len(outcomes))
 	order := make([]string, 0, len(outcomes))
 	for _, o := range outcomes {
-		key := o.Name
-		if key == "" {
-			key = o.Field
-		}
-		if key == "" {
+		name := o.MetricName()
+		if name == "" {
 			continue
 		}
+		key := SeriesKey(name, o.Labels)
 		if _, seen := groups[key]; !seen {
 			order = append(order, key)
 		}
@@ -484,14 +819,20 @@ func aggregateOutcomes(outcomes []Outcome) Report {
 // aggregated outcome. The group is guaranteed to be non-empty.
 func finalizeGroup(group []Outcome) Outcome {
 	res := group[0]
-	if len(group) == 1 {
-		return res
-	}
-
 	agg := res.Aggregation
 	if agg == AggNone {
 		agg = defaultAggregation(res.Kind)
 	}
+	if fn, ok := registeredAggregationFunc(agg); ok {
+		res = fn(group)
+		res.Kind, res.Name, res.Field, res.Labels = group[0].Kind, group[0].Name, group[0].Field, group[0].Labels
+		res.Aggregation = group[0].Aggregation
+		res.Err = joinOutcomeErrors(group)
+		return res
+	}
+	if len(group) == 1 && agg != AggCount {
+		return res
+	}
 
 	switch res.Kind {
 	case KindCounter:
@@ -508,30 +849,54 @@ func finalizeGroup(group []Outcome) Outcome {
 				sum += o.Score * w
 				weights += w
 			}
-			res.Score = sum / weights
-		case AggSum:
-			var total float64
-			for _, o := range group {
-				total += o.Score
-			}
-			res.Score = total
-		case AggMax:
+			// The merged score carries the weight of the whole group, so
+			// merging it again (across reports or batches) stays exact.
+			res.Score, res.Weight = sum/weights, weights
+		default:
 			res = aggregateNumeric(group, res, agg, func(o Outcome) float64 { return o.Score })
-		case AggMin:
-			res = aggregateNumeric(group, res, agg, func(o Outcome) float64 { return o.Score })
-		case AggLast:
-			res = group[len(group)-1]
 		}
 	case KindHistogram:
 		res.Histogram = mergeHistograms(group)
+		res.Exponential = mergeExponentialHistograms(group)
+	case KindGauge:
+		if agg == AggLast {
+			for _, o := range group[1:] {
+				if !o.Time.Before(res.Time) {
+					res = o
+				}
+			}
+			break
+		}
+		res = aggregateNumeric(group, res, agg, func(o Outcome) float64 { return o.Value })
+	case KindDistinct:
+		res.Distinct = HyperLogLog{}
+		for _, o := range group {
+			res.Distinct.Merge(o.Distinct)
+		}
+	case KindSummary:
+		res.Summary = group[0].Summary.clone()
+		for _, o := range group[1:] {
+			res.Summary.Merge(o.Summary)
+		}
+		res.Summary.compress()
 	case KindValid:
-		res.Valid = true
+		valid := 0
 		for _, o := range group {
-			if !o.Valid {
-				res.Valid = false
-				break
+			if o.Valid {
+				valid++
 			}
 		}
+		switch agg {
+		case AggMax, AggAnyTrue:
+			res.Valid = valid > 0
+		case AggLast:
+			res = group[len(group)-1]
+		case AggCount:
+			res.Valid = valid == len(group)
+			res.Count = float64(len(group))
+		default:
+			res.Valid = valid == len(group)
+		}
 	default:
 		res = group[len(group)-1]
 	}
@@ -540,7 +905,7 @@ func finalizeGroup(group []Outcome) Outcome {
 	return res
 }
 
-// aggregateNumeric combines a numeric outcome kind (counter or score) using
+// aggregateNumeric combines a numeric outcome kind (counter, score or gauge) using
 // the given aggregation and value accessor.
 func aggregateNumeric(group []Outcome, res Outcome, agg Aggregation, value func(Outcome) float64) Outcome {
 	switch agg {
@@ -549,24 +914,27 @@ func aggregateNumeric(group []Outcome, res Outcome, agg Aggregation, value func(
 		for _, o := range group {
 			total += value(o)
 		}
-		switch res.Kind {
-		case KindCounter:
-			res.Count = total
-		case KindScore:
-			res.Score = total
-		}
+		res = withNumericValue(res, total)
 	case AggAvg:
 		var total float64
 		for _, o := range group {
 			total += value(o)
 		}
-		avg := total / float64(len(group))
-		switch res.Kind {
-		case KindCounter:
-			res.Count = avg
-		case KindScore:
-			res.Score = avg
+		res = withNumericValue(res, total/float64(len(group)))
+	case AggMedian:
+		values := make([]float64, len(group))
+		for i, o := range group {
+			values[i] = value(o)
 		}
+		slices.Sort(values)
+		mid := len(values) / 2
+		median := values[mid]
+		if len(values)%2 == 0 {
+			median = (values[mid-1] + median) / 2
+		}
+		res = withNumericValue(res, median)
+	case AggCount:
+		res = withNumericValue(res, float64(len(group)))
 	case AggMax:
 		for _, o := range group {
 			if value(o) > value(res) {
@@ -585,13 +953,42 @@ func aggregateNumeric(group []Outcome, res Outcome, agg Aggregation, value func(
 	return res
 }
 
+// withNumericValue returns o with the value of its numeric kind set to v.
+func withNumericValue(o Outcome, v float64) Outcome {
+	switch o.Kind {
+	case KindCounter:
+		o.Count = v
+	case KindScore:
+		o.Score = v
+	case KindGauge:
+		o.Value = v
+	}
+	return o
+}
+
+// numericValue returns the value of a numeric outcome kind, the one
+// withNumericValue sets.
+func numericValue(o Outcome) float64 {
+	switch o.Kind {
+	case KindCounter:
+		return o.Count
+	case KindScore:
+		return o.Score
+	case KindGauge:
+		return o.Value
+	}
+	return 0
+}
+
 // mergeHistograms combines histograms. Total and Sum always accumulate. Bucket
 // counts are summed only for boundaries that exactly match the winning boundary
 // set (the first non-empty histogram's), so a histogram with a different
 // boundary layout contributes its Total and Sum but no bucket counts instead of
-// silently merging counts into the wrong bucket.
+// silently merging counts into the wrong bucket. The outcomes are not modified.
 func mergeHistograms(group []Outcome) Histogram {
 	res := group[0].Histogram
+	res.Buckets = slices.Clone(res.Buckets)
+	res.Counts = slices.Clone(res.Counts)
 	for _, o := range group[1:] {
 		h := o.Histogram
 		res.Total += h.Total
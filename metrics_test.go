@@ -7,6 +7,7 @@ import (
 	"slices"
 	"sync"
 	"testing"
+	"time"
 )
 
 type metricUser struct {
@@ -59,6 +60,63 @@ func TestHistogram_Observe(t *testing.T) {
 	}
 }
 
+func TestHistogram_Quantile(t *testing.T) {
+	t.Parallel()
+
+	hist := NewHistogram(LinearBuckets(10, 10, 10)) // 10 .. 100
+	for v := 1.0; v <= 100; v++ {
+		hist.Observe(v)
+	}
+	withInf := NewHistogram([]float64{10, math.Inf(1)})
+	withInf.Observe(5)
+	withInf.Observe(500)
+	negative := NewHistogram([]float64{-5, 0, 5})
+	negative.Observe(-7)
+	negative.Observe(3)
+
+	testCases := []struct {
+		testName string
+		hist     Histogram
+		q        float64
+		want     float64
+	}{
+		{testName: "p50", hist: hist, q: 0.5, want: 50},
+		{testName: "p95", hist: hist, q: 0.95, want: 95},
+		{testName: "p99", hist: hist, q: 0.99, want: 99},
+		{testName: "p0", hist: hist, q: 0, want: 0},
+		{testName: "q clamped", hist: hist, q: 2, want: 100},
+		{testName: "beyond the last finite boundary", hist: withInf, q: 0.99, want: 10},
+		{testName: "first bucket at or below zero", hist: negative, q: 0.25, want: -5},
+		{testName: "empty", hist: NewHistogram([]float64{1}), q: 0.5, want: math.NaN()},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			t.Parallel()
+
+			got := tc.hist.Quantile(tc.q)
+			if math.IsNaN(tc.want) != math.IsNaN(got) || !math.IsNaN(got) && math.Abs(got-tc.want) > 1e-9 {
+				t.Errorf("Quantile(%v) = %v, want %v", tc.q, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBucketLayouts(t *testing.T) {
+	t.Parallel()
+
+	if got := LinearBuckets(10, 5, 4); !slices.Equal(got, []float64{10, 15, 20, 25}) {
+		t.Errorf("LinearBuckets = %v", got)
+	}
+	if got := ExponentialBuckets(1, 2, 5); !slices.Equal(got, []float64{1, 2, 4, 8, 16}) {
+		t.Errorf("ExponentialBuckets = %v", got)
+	}
+	for _, got := range [][]float64{LinearBuckets(1, 1, 0), ExponentialBuckets(0, 2, 3), ExponentialBuckets(1, 1, 3)} {
+		if got != nil {
+			t.Errorf("invalid layout = %v, want nil", got)
+		}
+	}
+}
+
 func TestNewHistogram_SortsAndCopies(t *testing.T) {
 	t.Parallel()
 
@@ -533,6 +591,211 @@ func TestValidate_IgnoresMetrics(t *testing.T) {
 	}
 }
 
+func TestEvaluateMetrics_LabeledSeries(t *testing.T) {
+	t.Parallel()
+
+	emit := func(region string, v float64) Rule {
+		return NewTypedRule[string]("requests_"+region, func(ctx context.Context, _ string) error {
+			o := CounterValue(v)
+			o.Name = "requests"
+			if region != "" {
+				o.Labels = map[string]string{"region": region, "tier": "gold"}
+			}
+			Emit(ctx, o)
+			return nil
+		})
+	}
+	tree := Rules(emit("eu", 1), emit("us", 2), emit("eu", 4), emit("", 8))
+
+	report, err := EvaluateMetricsWithData(context.Background(), tree, ProcessingHooks{}, "check", "data")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := report.Metrics["requests"].Count; got != 8 {
+		t.Errorf("unlabeled requests = %v, want 8", got)
+	}
+	if got := report.Metrics[`requests{region="eu",tier="gold"}`].Count; got != 5 {
+		t.Errorf("eu requests = %v, want 5", got)
+	}
+
+	series := report.Series("requests")
+	var counts []float64
+	var regions []string
+	for _, s := range series {
+		counts = append(counts, s.Count)
+		regions = append(regions, s.Labels["region"])
+	}
+	if !slices.Equal(counts, []float64{8, 5, 2}) || !slices.Equal(regions, []string{"", "eu", "us"}) {
+		t.Errorf("series counts = %v, regions = %v", counts, regions)
+	}
+	if got := report.Series("missing"); len(got) != 0 {
+		t.Errorf("Series(missing) = %v", got)
+	}
+}
+
+func TestEvaluateMetrics_MetricOptions(t *testing.T) {
+	t.Parallel()
+
+	labels := map[string]string{"region": "eu", "tier": "gold"}
+	gauge := func(v float64, own map[string]string) Rule {
+		return NewMetricRulePure("depth", KindGauge, "depth", func() (Outcome, error) {
+			o := GaugeValue(v, time.Time{})
+			o.Labels = own
+			return o, nil
+		}, WithMetricAggregation(AggMax), WithMetricLabels(labels))
+	}
+	tree := Rules(gauge(3, nil), gauge(7, nil), gauge(5, nil), gauge(1, map[string]string{"tier": "silver"}))
+	labels["region"] = "us" // the option keeps its own copy
+
+	report, err := EvaluateMetricsWithData(context.Background(), tree, ProcessingHooks{}, "check", "data")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := report.Metrics[`depth{region="eu",tier="gold"}`]; got.Value != 7 || got.Aggregation != AggMax {
+		t.Errorf("gold depth = %v with %s, want 7 with max", got.Value, got.Aggregation)
+	}
+	// Labels of the outcome take precedence over those of the option.
+	if got := report.Metrics[`depth{region="eu",tier="silver"}`]; got.Value != 1 {
+		t.Errorf("silver depth = %v, want 1", got.Value)
+	}
+}
+
+func TestSeriesKey(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		labels map[string]string
+		want   string
+	}{
+		{labels: nil, want: "latency"},
+		{labels: map[string]string{}, want: "latency"},
+		{labels: map[string]string{"tier": "gold", "region": "eu"}, want: `latency{region="eu",tier="gold"}`},
+		{labels: map[string]string{"path": `a"b`}, want: `latency{path="a\"b"}`},
+	}
+	for _, tc := range testCases {
+		if got := SeriesKey("latency", tc.labels); got != tc.want {
+			t.Errorf("SeriesKey(%v) = %s, want %s", tc.labels, got, tc.want)
+		}
+	}
+}
+
+func TestEvaluateMetrics_GaugeDistinctSummary(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	gauge := func(name string, v float64, at time.Time, agg Aggregation) Rule {
+		return NewTypedRule[string](name, func(ctx context.Context, _ string) error {
+			o := GaugeValue(v, at)
+			o.Name = name
+			o.Aggregation = agg
+			Emit(ctx, o)
+			return nil
+		})
+	}
+	sketch := func(items ...string) Rule {
+		return NewTypedRule[string]("merchants", func(ctx context.Context, _ string) error {
+			merchants := NewHyperLogLog(0)
+			amounts := NewSummary(0)
+			for i, item := range items {
+				merchants.Add(item)
+				amounts.Observe(float64(i + 1))
+			}
+			d := DistinctValue(merchants)
+			d.Name = "merchants"
+			Emit(ctx, d)
+			a := SummaryValue(amounts)
+			a.Name = "amounts"
+			Emit(ctx, a)
+			return nil
+		})
+	}
+	tree := Rules(
+		// Emitted out of order: the latest reading wins, not the last emitted.
+		gauge("depth", 4, t0.Add(time.Minute), AggNone),
+		gauge("depth", 9, t0, AggNone),
+		gauge("peak", 4, t0, AggMax),
+		gauge("peak", 9, t0.Add(time.Minute), AggMax),
+		gauge("peak", 2, t0.Add(2*time.Minute), AggMax),
+		sketch("a", "b", "c"),
+		sketch("b", "c", "d", "e"),
+	)
+
+	report, err := EvaluateMetricsWithData(context.Background(), tree, ProcessingHooks{}, "check", "data")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if depth := report.Metrics["depth"]; depth.Value != 4 || !depth.Time.Equal(t0.Add(time.Minute)) {
+		t.Errorf("depth = %v at %v, want the latest reading", depth.Value, depth.Time)
+	}
+	if peak := report.Metrics["peak"]; peak.Value != 9 || !peak.Time.Equal(t0.Add(time.Minute)) {
+		t.Errorf("peak = %v at %v, want 9 with its time", peak.Value, peak.Time)
+	}
+	if got := report.Metrics["merchants"].Distinct.Estimate(); got != 5 {
+		t.Errorf("merchants = %d, want 5 distinct", got)
+	}
+	if amounts := report.Metrics["amounts"].Summary; amounts.Count != 7 || amounts.Max != 4 {
+		t.Errorf("amounts Count = %v, Max = %v", amounts.Count, amounts.Max)
+	}
+
+	// The sketches merge across targets too.
+	batch := MergeReports(report, report)
+	if got := batch.Metrics["merchants"].Distinct.Estimate(); got != 5 {
+		t.Errorf("batch merchants = %d, want 5 distinct", got)
+	}
+	if got := batch.Metrics["amounts"].Summary.Count; got != 14 {
+		t.Errorf("batch amounts Count = %v, want 14", got)
+	}
+}
+
+// sinkRecorder is an OutcomeSink keeping what it receives.
+type sinkRecorder struct {
+	mu       sync.Mutex
+	trees    []string
+	outcomes []Outcome
+}
+
+func (s *sinkRecorder) RecordOutcome(_ context.Context, tree string, o Outcome) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trees = append(s.trees, tree)
+	s.outcomes = append(s.outcomes, o)
+}
+
+func TestOutcomeSink(t *testing.T) {
+	t.Parallel()
+
+	tree := Rules(
+		NewMetricRulePure("mrr", KindCounter, "mrr", func() (Outcome, error) {
+			return CounterValue(10), nil
+		}),
+		NewMetricRulePure("mrr", KindCounter, "mrr", func() (Outcome, error) {
+			return CounterValue(5), nil
+		}),
+	)
+
+	for _, evaluate := range []func(ctx context.Context) error{
+		func(ctx context.Context) error {
+			_, err := EvaluateMetricsWithData(ctx, tree, ProcessingHooks{}, "billing", "data")
+			return err
+		},
+		func(ctx context.Context) error {
+			return ValidateWithData(ctx, tree, ProcessingHooks{}, "billing", "data")
+		},
+	} {
+		sink := &sinkRecorder{}
+		if err := evaluate(WithOutcomeSink(context.Background(), sink)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// The sink sees every outcome, before aggregation.
+		if len(sink.outcomes) != 2 || sink.outcomes[0].Count+sink.outcomes[1].Count != 15 {
+			t.Errorf("outcomes = %+v", sink.outcomes)
+		}
+		if !slices.Equal(sink.trees, []string{"billing", "billing"}) {
+			t.Errorf("trees = %v", sink.trees)
+		}
+	}
+}
+
 func TestEvaluateMetrics_Concurrent(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,245 @@
+package rules
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"maps"
+	"reflect"
+	"slices"
+)
+
+// ErrorCodePayloadMutated is returned under WithMutationDetection when a rule
+// or condition changed the registry payload or one of its slots.
+const ErrorCodePayloadMutated = "PAYLOAD_MUTATED"
+
+// mutationDetectionKey is the context key for the mutation detection option.
+type mutationDetectionKey struct{}
+
+// WithMutationDetection returns a context that makes the engine check that
+// rules and conditions do not mutate the registry data. The payload and every
+// slot are deep-hashed before validation and again after each rule's Prepare
+// and Validate; a change is reported as a PAYLOAD_MUTATED error whose Field is
+// the rule name and whose message carries the rule's execution path and the
+// slot that changed. Conditions are checked per phase, as a whole.
+//
+// Hashing walks the whole payload after every rule, so this is a debugging
+// aid for tests and staging rather than a production setting. Values nested
+// deeper than 32 levels are not compared, and lazy values are compared from
+// the moment they are computed. For a cheap guard in production, expose maps
+// and slices through ReadOnlyMap and ReadOnlySlice instead.
+//
+// Example:
+//
+//	ctx := rules.WithMutationDetection(context.Background())
+//	err := rules.ValidateWithData(ctx, tree, hooks, "test", order)
+//	// code: PAYLOAD_MUTATED, field: normalizeItems, error: rule mutated
+//	// the registry payload (path: test -> root -> leafNode -> normalizeItems)
+func WithMutationDetection(ctx context.Context) context.Context {
+	return context.WithValue(ctx, mutationDetectionKey{}, true)
+}
+
+// mutationDetectionFromContext reports whether mutation detection is enabled.
+func mutationDetectionFromContext(ctx context.Context) bool {
+	enabled, _ := ctx.Value(mutationDetectionKey{}).(bool)
+	return enabled
+}
+
+// mutationDetector holds the last snapshot of one target's registry. A nil
+// detector checks nothing.
+type mutationDetector struct {
+	reg      *DataRegistry
+	snapshot map[string]string
+}
+
+// newMutationDetector snapshots reg. It returns nil when there is no
+// registry to watch.
+func newMutationDetector(reg *DataRegistry) *mutationDetector {
+	if reg == nil {
+		return nil
+	}
+	return &mutationDetector{reg: reg, snapshot: snapshotRegistry(reg)}
+}
+
+// check compares the registry with the last snapshot and returns a
+// PAYLOAD_MUTATED error attributed to rule, or to the conditions when rule is
+// nil, for the first slot that changed. The new snapshot becomes the
+// baseline, so a mutation is reported once.
+func (d *mutationDetector) check(ctx context.Context, rule Rule) error {
+	if d == nil {
+		return nil
+	}
+	current := snapshotRegistry(d.reg)
+	previous := d.snapshot
+	d.snapshot = current
+
+	for _, slot := range slices.Sorted(maps.Keys(previous)) {
+		hash, ok := current[slot]
+		if !ok || hash == previous[slot] {
+			continue
+		}
+		what := "the registry payload"
+		if slot != "" {
+			what = fmt.Sprintf("registry slot %q", slot)
+		}
+		if rule == nil {
+			return Error{
+				Field: "conditions",
+				Err:   "a condition mutated " + what,
+				Code:  ErrorCodePayloadMutated,
+			}
+		}
+		msg := "rule mutated " + what
+		if trace := traceFromContext(ctx); trace != nil {
+			if path := trace.Path(rule); path != "" {
+				msg += " (path: " + path + ")"
+			}
+		}
+		return Error{Field: rule.Name(), Err: msg, Code: ErrorCodePayloadMutated}
+	}
+	return nil
+}
+
+// snapshotRegistry returns the content hash of the payload (under the empty
+// name) and of every slot. Lazy values that have not been computed yet are
+// left out.
+func snapshotRegistry(reg *DataRegistry) map[string]string {
+	snapshot := make(map[string]string, len(reg.slots)+1)
+	add := func(name string, data any) {
+		if lazy, ok := data.(*lazyValue); ok {
+			if !lazy.done.Load() {
+				return
+			}
+			data = lazy.value
+		}
+		snapshot[name] = payloadHash(data)
+	}
+	add("", reg.data)
+	for name, data := range reg.slots {
+		add(name, data)
+	}
+	return snapshot
+}
+
+// payloadHash returns a hash of the content of data, following pointers.
+func payloadHash(data any) string {
+	f := &fingerprinter{h: sha256.New(), content: true}
+	f.value(reflect.ValueOf(data), 0)
+	return hex.EncodeToString(f.h.Sum(nil))
+}
+
+// ReadOnlyMap is a read-only view of a map, for payloads shared by rules that
+// must not modify them. It does not copy the map: the owner of the underlying
+// map can still change it, and values that are pointers, maps or slices can
+// still be modified through the values returned.
+//
+// Example:
+//
+//	type Order struct {
+//	    Attributes rules.ReadOnlyMap[string, string]
+//	}
+//
+//	order := Order{Attributes: rules.NewReadOnlyMap(attrs)}
+type ReadOnlyMap[K comparable, V any] struct {
+	m map[K]V
+}
+
+// NewReadOnlyMap returns a read-only view of m.
+func NewReadOnlyMap[K comparable, V any](m map[K]V) ReadOnlyMap[K, V] {
+	return ReadOnlyMap[K, V]{m: m}
+}
+
+// Get returns the value stored under key and whether it is present.
+func (m ReadOnlyMap[K, V]) Get(key K) (V, bool) {
+	v, ok := m.m[key]
+	return v, ok
+}
+
+// Len returns the number of entries.
+func (m ReadOnlyMap[K, V]) Len() int {
+	return len(m.m)
+}
+
+// All iterates over the entries, in unspecified order.
+func (m ReadOnlyMap[K, V]) All() iter.Seq2[K, V] {
+	return maps.All(m.m)
+}
+
+// Clone returns a mutable copy of the map.
+func (m ReadOnlyMap[K, V]) Clone() map[K]V {
+	return maps.Clone(m.m)
+}
+
+// MarshalJSON encodes the view as the underlying map.
+func (m ReadOnlyMap[K, V]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.m)
+}
+
+// UnmarshalJSON decodes a map into a fresh view, so payloads with read-only
+// fields can be decoded, e.g. when replaying a Cassette.
+func (m *ReadOnlyMap[K, V]) UnmarshalJSON(data []byte) error {
+	var decoded map[K]V
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	m.m = decoded
+	return nil
+}
+
+// ReadOnlySlice is a read-only view of a slice. Like ReadOnlyMap it does not
+// copy the slice, and elements that are pointers, maps or slices can still be
+// modified through the values returned.
+//
+// Example:
+//
+//	type Order struct {
+//	    Items rules.ReadOnlySlice[Item]
+//	}
+//
+//	order := Order{Items: rules.NewReadOnlySlice(items)}
+type ReadOnlySlice[T any] struct {
+	s []T
+}
+
+// NewReadOnlySlice returns a read-only view of s.
+func NewReadOnlySlice[T any](s []T) ReadOnlySlice[T] {
+	return ReadOnlySlice[T]{s: s}
+}
+
+// At returns the element at index i. It panics when i is out of range.
+func (s ReadOnlySlice[T]) At(i int) T {
+	return s.s[i]
+}
+
+// Len returns the number of elements.
+func (s ReadOnlySlice[T]) Len() int {
+	return len(s.s)
+}
+
+// All iterates over the indices and elements, in order.
+func (s ReadOnlySlice[T]) All() iter.Seq2[int, T] {
+	return slices.All(s.s)
+}
+
+// Clone returns a mutable copy of the slice.
+func (s ReadOnlySlice[T]) Clone() []T {
+	return slices.Clone(s.s)
+}
+
+// MarshalJSON encodes the view as the underlying slice.
+func (s ReadOnlySlice[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.s)
+}
+
+// UnmarshalJSON decodes a slice into a fresh view.
+func (s *ReadOnlySlice[T]) UnmarshalJSON(data []byte) error {
+	var decoded []T
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	s.s = decoded
+	return nil
+}
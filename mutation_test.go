@@ -0,0 +1,212 @@
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mutationOrder struct {
+	Items []string
+	Meta  map[string]string
+}
+
+// mutationErrors returns the PAYLOAD_MUTATED errors in err.
+func mutationErrors(err error) []Error {
+	if err == nil {
+		return nil
+	}
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+	var found []Error
+	for _, e := range errs {
+		var re Error
+		if errors.As(e, &re) && re.Code == ErrorCodePayloadMutated {
+			found = append(found, re)
+		}
+	}
+	return found
+}
+
+func TestMutationDetection(t *testing.T) {
+	t.Parallel()
+
+	sortItems := NewTypedRule("sortItems", func(ctx context.Context, o *mutationOrder) error {
+		slices.Sort(o.Items) // mutates the shared payload
+		return nil
+	})
+	readOnly := NewTypedRule("countItems", func(ctx context.Context, o *mutationOrder) error {
+		if len(o.Items) == 0 {
+			return Error{Field: "items", Err: "empty order", Code: "EMPTY"}
+		}
+		return nil
+	})
+	tagCondition := NewTypedCondition("tagged", func(ctx context.Context, o *mutationOrder) bool {
+		o.Meta["seen"] = "yes"
+		return true
+	})
+
+	testCases := []struct {
+		testName  string
+		tree      Evaluable
+		detect    bool
+		wantField string
+		wantMsg   string
+	}{
+		{testName: "rule", tree: Rules(readOnly, sortItems, readOnly), detect: true, wantField: "sortItems", wantMsg: "(path: check -> leafNode -> sortItems)"},
+		{testName: "condition", tree: Node(tagCondition, Rules(readOnly)), detect: true, wantField: "conditions", wantMsg: "a condition mutated the registry payload"},
+		{testName: "disabled", tree: Rules(sortItems), detect: false},
+		{testName: "no mutation", tree: Rules(readOnly), detect: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			if tc.detect {
+				ctx = WithMutationDetection(ctx)
+			}
+			order := &mutationOrder{Items: []string{"b", "a"}, Meta: map[string]string{}}
+			found := mutationErrors(ValidateWithData(ctx, tc.tree, ProcessingHooks{}, "check", order))
+
+			if tc.wantField == "" {
+				if len(found) != 0 {
+					t.Errorf("unexpected mutation errors: %v", found)
+				}
+				return
+			}
+			if len(found) != 1 {
+				t.Fatalf("got %d mutation errors, want 1: %v", len(found), found)
+			}
+			if found[0].Field != tc.wantField || !strings.Contains(found[0].Err, tc.wantMsg) {
+				t.Errorf("error = %v, want field %q and message containing %q", found[0], tc.wantField, tc.wantMsg)
+			}
+		})
+	}
+}
+
+func TestMutationDetection_Slots(t *testing.T) {
+	t.Parallel()
+
+	limits := NewKey[map[string]int]("limits")
+	reg := NewDataRegistry(testUser{Age: 30})
+	Set(reg, limits, map[string]int{"daily": 100})
+	raise := NewTypedRuleWithKey("raiseLimit", limits, func(ctx context.Context, l map[string]int) error {
+		l["daily"] *= 2
+		return nil
+	})
+
+	ctx := WithMutationDetection(WithRegistry(context.Background(), reg))
+	reports, _ := EvaluateMetrics(ctx, Rules(raise), ProcessingHooks{}, "slots")
+	found := mutationErrors(errors.Join(reports.Errors...))
+	if len(found) != 1 || !strings.Contains(found[0].Err, `registry slot "limits"`) {
+		t.Errorf("errors = %v, want one mutation of the limits slot", reports.Errors)
+	}
+}
+
+func TestReadOnlyViews(t *testing.T) {
+	t.Parallel()
+
+	attrs := map[string]string{"color": "red"}
+	m := NewReadOnlyMap(attrs)
+	if v, ok := m.Get("color"); !ok || v != "red" || m.Len() != 1 {
+		t.Errorf("Get = %q, %v, Len = %d", v, ok, m.Len())
+	}
+	clone := m.Clone()
+	clone["color"] = "blue"
+	if v, _ := m.Get("color"); v != "red" {
+		t.Error("Clone must not alias the view")
+	}
+	if got := maps.Collect(m.All()); !maps.Equal(got, attrs) {
+		t.Errorf("All = %v", got)
+	}
+
+	items := []int{1, 2, 3}
+	s := NewReadOnlySlice(items)
+	if s.Len() != 3 || s.At(1) != 2 {
+		t.Errorf("Len = %d, At(1) = %d", s.Len(), s.At(1))
+	}
+	var got []int
+	for _, v := range s.All() {
+		got = append(got, v)
+	}
+	if !slices.Equal(got, items) {
+		t.Errorf("All = %v", got)
+	}
+
+	type payload struct {
+		Attrs ReadOnlyMap[string, string] `json:"attrs"`
+		Items ReadOnlySlice[int]          `json:"items"`
+	}
+	encoded, err := json.Marshal(payload{Attrs: m, Items: s})
+	if err != nil || string(encoded) != `{"attrs":{"color":"red"},"items":[1,2,3]}` {
+		t.Fatalf("Marshal = %s, %v", encoded, err)
+	}
+	var decoded payload
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Items.Len() != 3 || decoded.Attrs.Len() != 1 {
+		t.Errorf("decoded = %+v", decoded)
+	}
+}
+
+func TestPayloadHash_CyclicAndShared(t *testing.T) {
+	t.Parallel()
+
+	type category struct {
+		Name     string
+		Parent   *category
+		Children []*category
+		Siblings map[string]*category
+	}
+	// A tree of 11 categories in which every child points back to its parent
+	// and to all its siblings.
+	build := func() (*category, []*category) {
+		root := &category{Name: "root"}
+		var all []*category
+		for i := range 10 {
+			child := &category{Name: "c" + strconv.Itoa(i), Parent: root}
+			root.Children = append(root.Children, child)
+			all = append(all, child)
+		}
+		for _, c := range all {
+			c.Siblings = make(map[string]*category)
+			for _, s := range all {
+				c.Siblings[s.Name] = s
+			}
+		}
+		return root, all
+	}
+
+	root, children := build()
+	done := make(chan string)
+	go func() { done <- payloadHash(root) }()
+	var before string
+	select {
+	case before = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("hashing a cyclic payload did not terminate")
+	}
+
+	// The hash does not depend on addresses or on map iteration order.
+	other, _ := build()
+	for range 20 {
+		if got := payloadHash(other); got != before {
+			t.Fatalf("hash of an identical payload = %s, want %s", got, before)
+		}
+	}
+	children[7].Name = "renamed"
+	if payloadHash(root) == before {
+		t.Error("renaming a category did not change the hash")
+	}
+}
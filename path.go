@@ -0,0 +1,323 @@
+package rules
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// pathPlans caches compiled access plans keyed by (type, path), so repeated
+// evaluation resolves field names and json tags once per type.
+var pathPlans sync.Map // pathKey -> *pathPlan
+
+// pathKey identifies a compiled plan.
+type pathKey struct {
+	typ  reflect.Type
+	path string
+}
+
+// pathSegment is one element of a parsed path: a field or map key, or a
+// slice index.
+type pathSegment struct {
+	name    string
+	index   int
+	isIndex bool
+}
+
+// stepKind is the operation of one plan step.
+type stepKind int
+
+const (
+	stepField   stepKind = iota // struct field by index
+	stepMapKey                  // map entry by precomputed key
+	stepIndex                   // slice or array element
+	stepDeref                   // pointer dereference
+	stepDynamic                 // interface: continue with the plan of the dynamic type
+)
+
+// pathStep is one compiled access.
+type pathStep struct {
+	kind  stepKind
+	field []int         // stepField: index sequence, through embedded structs
+	key   reflect.Value // stepMapKey
+	index int           // stepIndex
+	rest  string        // stepDynamic: the remaining path
+}
+
+// pathPlan is the compiled access plan of a path for one static type. A nil
+// steps slice with invalid set means the path can never resolve on the type.
+type pathPlan struct {
+	steps   []pathStep
+	invalid bool
+}
+
+// GetPath retrieves the value at path inside the registry data and returns it
+// as T. path is a dotted list of struct fields or map keys with optional
+// slice indices, e.g. "user.address.zip" or "items[2].sku"; the empty path is
+// the whole payload.
+//
+// Struct fields match their json tag name first and their Go name second, and
+// promoted fields of embedded structs are visible, as with encoding/json.
+// Pointers and interfaces are followed, so the same path works on a struct, a
+// pointer to it, or the map[string]any produced by decoding JSON.
+//
+// The boolean is false when a segment is missing, a pointer on the way is
+// nil, an index is out of range, or the value is not of type T. JSON numbers
+// decode as float64, so read them as GetPath[float64].
+//
+// Access plans are compiled once per (type, path) and cached: repeated
+// evaluation does no lookup by name.
+//
+// Example:
+//
+//	zip, ok := rules.GetPath[string](ctx, "user.address.zip")
+func GetPath[T any](ctx context.Context, path string) (T, bool) {
+	var zero T
+	data, ok := Get(ctx)
+	if !ok {
+		return zero, false
+	}
+	v, ok := resolvePath(data, path)
+	if !ok || !v.IsValid() || !v.CanInterface() {
+		return zero, false
+	}
+	typed, ok := v.Interface().(T)
+	return typed, ok
+}
+
+// HasPath creates a condition that checks whether path resolves inside the
+// registry data. It is the path-aware version of HasField: see GetPath for
+// the path syntax.
+//
+// Example:
+//
+//	hasZip := rules.HasPath("hasZip", "user.address.zip")
+func HasPath(name string, path string) Condition {
+	return &ConditionFunc{
+		name: name,
+		predicate: func(ctx context.Context) bool {
+			data, ok := Get(ctx)
+			if !ok {
+				return false
+			}
+			_, ok = resolvePath(data, path)
+			return ok
+		},
+		pure:   true,
+		params: []any{path},
+	}
+}
+
+// PathEquals creates a condition that checks whether the value at path equals
+// expected, using reflect.DeepEqual. It is the path-aware version of
+// FieldEquals: see GetPath for the path syntax.
+//
+// Example:
+//
+//	isAdmin := rules.PathEquals("isAdmin", "user.roles[0]", "admin")
+func PathEquals(name string, path string, expected any) Condition {
+	return &ConditionFunc{
+		name: name,
+		predicate: func(ctx context.Context) bool {
+			data, ok := Get(ctx)
+			if !ok {
+				return false
+			}
+			v, ok := resolvePath(data, path)
+			switch {
+			case !ok:
+				return false
+			case !v.IsValid():
+				return expected == nil // a nil interface, e.g. a JSON null
+			case !v.CanInterface():
+				return false
+			}
+			return reflect.DeepEqual(v.Interface(), expected)
+		},
+		pure:   true,
+		params: []any{path, expected},
+	}
+}
+
+// resolvePath returns the value at path inside data. The returned value is
+// invalid when the path resolves to a nil interface (e.g. a JSON null).
+func resolvePath(data any, path string) (reflect.Value, bool) {
+	if data == nil {
+		return reflect.Value{}, false
+	}
+	v := reflect.ValueOf(data)
+	return planFor(v.Type(), path).get(v)
+}
+
+// planFor returns the cached plan of path on typ, compiling it on first use.
+func planFor(typ reflect.Type, path string) *pathPlan {
+	key := pathKey{typ: typ, path: path}
+	if plan, ok := pathPlans.Load(key); ok {
+		return plan.(*pathPlan)
+	}
+
+	plan := &pathPlan{invalid: true}
+	if segments, ok := parsePath(path); ok {
+		plan = compilePath(typ, segments)
+	}
+	actual, _ := pathPlans.LoadOrStore(key, plan)
+	return actual.(*pathPlan)
+}
+
+// get runs the plan on v.
+func (p *pathPlan) get(v reflect.Value) (reflect.Value, bool) {
+	if p.invalid {
+		return reflect.Value{}, false
+	}
+
+	for _, step := range p.steps {
+		switch step.kind {
+		case stepDeref:
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		case stepField:
+			field, err := v.FieldByIndexErr(step.field)
+			if err != nil {
+				return reflect.Value{}, false // nil embedded pointer
+			}
+			v = field
+		case stepMapKey:
+			v = v.MapIndex(step.key)
+			if !v.IsValid() {
+				return reflect.Value{}, false
+			}
+		case stepIndex:
+			if step.index >= v.Len() {
+				return reflect.Value{}, false
+			}
+			v = v.Index(step.index)
+		case stepDynamic:
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+			return planFor(v.Type(), step.rest).get(v)
+		}
+	}
+
+	if v.Kind() == reflect.Interface {
+		// The path ends on an interface value: return what it holds.
+		if v.IsNil() {
+			return reflect.Value{}, true
+		}
+		v = v.Elem()
+	}
+	return v, true
+}
+
+// compilePath builds the plan of segments on typ. It stops at the first
+// interface, whose dynamic type is only known at run time.
+func compilePath(typ reflect.Type, segments []pathSegment) *pathPlan {
+	plan := &pathPlan{}
+	for i := 0; ; i++ {
+		for typ.Kind() == reflect.Pointer {
+			plan.steps = append(plan.steps, pathStep{kind: stepDeref})
+			typ = typ.Elem()
+		}
+		if i == len(segments) {
+			return plan
+		}
+		if typ.Kind() == reflect.Interface {
+			plan.steps = append(plan.steps, pathStep{kind: stepDynamic, rest: formatPath(segments[i:])})
+			return plan
+		}
+
+		seg := segments[i]
+		switch {
+		case seg.isIndex && (typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array):
+			plan.steps = append(plan.steps, pathStep{kind: stepIndex, index: seg.index})
+			typ = typ.Elem()
+		case !seg.isIndex && typ.Kind() == reflect.Struct:
+			field, ok := structField(typ, seg.name)
+			if !ok {
+				return &pathPlan{invalid: true}
+			}
+			plan.steps = append(plan.steps, pathStep{kind: stepField, field: field.Index})
+			typ = field.Type
+		case !seg.isIndex && typ.Kind() == reflect.Map && typ.Key().Kind() == reflect.String:
+			key := reflect.ValueOf(seg.name).Convert(typ.Key())
+			plan.steps = append(plan.steps, pathStep{kind: stepMapKey, key: key})
+			typ = typ.Elem()
+		default:
+			return &pathPlan{invalid: true}
+		}
+	}
+}
+
+// structField finds the exported field of typ named name, matching json tag
+// names before Go names and including promoted fields.
+func structField(typ reflect.Type, name string) (reflect.StructField, bool) {
+	var byGoName reflect.StructField
+	found := false
+	for _, field := range reflect.VisibleFields(typ) {
+		if !field.IsExported() || field.Anonymous && field.Type.Kind() == reflect.Struct {
+			continue
+		}
+		tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if tag == name {
+			return field, true
+		}
+		if !found && tag != "-" && field.Name == name {
+			byGoName, found = field, true
+		}
+	}
+	return byGoName, found
+}
+
+// parsePath splits a path such as "a.b[2].c" into segments.
+func parsePath(path string) ([]pathSegment, bool) {
+	var segments []pathSegment
+	if path == "" {
+		return segments, true
+	}
+
+	for part := range strings.SplitSeq(path, ".") {
+		name, rest, _ := strings.Cut(part, "[")
+		if name == "" && rest == "" {
+			return nil, false // empty segment, e.g. "a..b"
+		}
+		if name != "" {
+			segments = append(segments, pathSegment{name: name})
+		}
+		if rest == "" {
+			continue
+		}
+		for _, index := range strings.Split("["+rest, "[")[1:] {
+			digits, ok := strings.CutSuffix(index, "]")
+			if !ok {
+				return nil, false
+			}
+			n, err := strconv.Atoi(digits)
+			if err != nil || n < 0 {
+				return nil, false
+			}
+			segments = append(segments, pathSegment{index: n, isIndex: true})
+		}
+	}
+	return segments, true
+}
+
+// formatPath is the inverse of parsePath.
+func formatPath(segments []pathSegment) string {
+	var b strings.Builder
+	for i, seg := range segments {
+		if seg.isIndex {
+			b.WriteString("[" + strconv.Itoa(seg.index) + "]")
+			continue
+		}
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(seg.name)
+	}
+	return b.String()
+}
@@ -0,0 +1,150 @@
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type pathAddress struct {
+	Zip  string `json:"zip"`
+	City string
+}
+
+type pathMeta struct {
+	Source string `json:"source"`
+}
+
+type pathItem struct {
+	SKU string `json:"sku"`
+	Qty int    `json:"qty"`
+}
+
+type pathOrder struct {
+	pathMeta
+	ID       int            `json:"id"`
+	Address  *pathAddress   `json:"address"`
+	Items    []pathItem     `json:"items"`
+	Labels   map[string]any `json:"labels"`
+	Hidden   string         `json:"-"`
+	internal string
+}
+
+func TestGetPath(t *testing.T) {
+	t.Parallel()
+
+	order := &pathOrder{
+		pathMeta: pathMeta{Source: "web"},
+		ID:       7,
+		Address:  &pathAddress{Zip: "12345", City: "Springfield"},
+		Items:    []pathItem{{SKU: "a", Qty: 1}, {SKU: "b", Qty: 2}},
+		Labels:   map[string]any{"tier": "gold", "nested": map[string]any{"ids": []any{1, 2}}},
+		Hidden:   "secret",
+		internal: "x",
+	}
+	ctx := WithRegistry(context.Background(), NewDataRegistry(order))
+
+	testCases := []struct {
+		path   string
+		want   any
+		wantOK bool
+	}{
+		{path: "id", want: 7, wantOK: true},
+		{path: "ID", want: 7, wantOK: true},
+		{path: "address.zip", want: "12345", wantOK: true},
+		{path: "address.City", want: "Springfield", wantOK: true},
+		{path: "items[1].sku", want: "b", wantOK: true},
+		{path: "items[1].qty", want: 2, wantOK: true},
+		{path: "items[2].sku", wantOK: false},
+		{path: "source", want: "web", wantOK: true},
+		{path: "labels.tier", want: "gold", wantOK: true},
+		{path: "labels.nested.ids[1]", want: 2, wantOK: true},
+		{path: "labels.missing", wantOK: false},
+		{path: "Hidden", wantOK: false},
+		{path: "internal", wantOK: false},
+		{path: "address.zip.extra", wantOK: false},
+		{path: "items[x]", wantOK: false},
+		{path: "a..b", wantOK: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			got, ok := GetPath[any](ctx, tc.path)
+			if ok != tc.wantOK || (ok && got != tc.want) {
+				t.Errorf("GetPath(%q) = %v, %v, want %v, %v", tc.path, got, ok, tc.want, tc.wantOK)
+			}
+		})
+	}
+
+	if zip, ok := GetPath[string](ctx, "address.zip"); !ok || zip != "12345" {
+		t.Errorf("typed GetPath = %q, %v", zip, ok)
+	}
+	if _, ok := GetPath[int](ctx, "address.zip"); ok {
+		t.Error("expected type mismatch")
+	}
+
+	order.Address = nil
+	if _, ok := GetPath[string](ctx, "address.zip"); ok {
+		t.Error("expected nil pointer to stop the path")
+	}
+}
+
+func TestGetPath_JSON(t *testing.T) {
+	t.Parallel()
+
+	var payload any
+	if err := json.Unmarshal([]byte(`{"user": {"address": {"zip": "02134"}, "roles": ["admin"], "age": 30, "note": null}}`), &payload); err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithRegistry(context.Background(), NewDataRegistry(payload))
+
+	if zip, ok := GetPath[string](ctx, "user.address.zip"); !ok || zip != "02134" {
+		t.Errorf("zip = %q, %v", zip, ok)
+	}
+	if age, ok := GetPath[float64](ctx, "user.age"); !ok || age != 30 {
+		t.Errorf("age = %v, %v", age, ok)
+	}
+
+	testCases := []struct {
+		testName  string
+		condition Condition
+		want      bool
+	}{
+		{testName: "has nested", condition: HasPath("hasZip", "user.address.zip"), want: true},
+		{testName: "has null", condition: HasPath("hasNote", "user.note"), want: true},
+		{testName: "missing", condition: HasPath("hasPhone", "user.phone"), want: false},
+		{testName: "equals index", condition: PathEquals("isAdmin", "user.roles[0]", "admin"), want: true},
+		{testName: "not equal", condition: PathEquals("isAdmin", "user.roles[0]", "member"), want: false},
+		{testName: "json number", condition: PathEquals("is30", "user.age", float64(30)), want: true},
+		{testName: "null", condition: PathEquals("noNote", "user.note", nil), want: true},
+	}
+	for _, tc := range testCases {
+		if got := tc.condition.IsValid(ctx); got != tc.want {
+			t.Errorf("%s: IsValid = %v, want %v", tc.testName, got, tc.want)
+		}
+	}
+}
+
+// TestPathPlans_Cached is not parallel: AllocsPerRun requires it.
+func TestPathPlans_Cached(t *testing.T) {
+	ctx := WithRegistry(context.Background(), NewDataRegistry(pathOrder{Items: []pathItem{{SKU: "a"}}}))
+	if _, ok := GetPath[string](ctx, "items[0].sku"); !ok {
+		t.Fatal("expected path to resolve")
+	}
+
+	plan, ok := pathPlans.Load(pathKey{typ: reflect.TypeFor[pathOrder](), path: "items[0].sku"})
+	if !ok {
+		t.Fatal("expected the plan to be cached")
+	}
+	if steps := plan.(*pathPlan).steps; len(steps) != 3 {
+		t.Errorf("plan has %d steps, want 3 (field, index, field)", len(steps))
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		GetPath[string](ctx, "items[0].sku")
+	})
+	if allocs > 1 {
+		t.Errorf("cached GetPath allocates %v times per call", allocs)
+	}
+}
@@ -0,0 +1,331 @@
+package rules
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ErrPreparePanic is returned to the evaluations waiting for a cached
+// Prepare call that panicked. The panic itself propagates in the evaluation
+// that made the call.
+var ErrPreparePanic = errors.New("rules: cached prepare panicked")
+
+// PrepareCacheOptions configures a PrepareCache.
+type PrepareCacheOptions struct {
+	// TTL is how long a prepared value is reused. Zero means values never
+	// expire and are only dropped by eviction or Purge.
+	TTL time.Duration
+	// MaxEntries bounds the number of cached results; the least recently used
+	// entry is evicted first. Zero means unbounded.
+	MaxEntries int
+	// NegativeTTL is how long a Prepare error is cached and returned without
+	// calling Prepare again. Zero disables negative caching: errors are never
+	// cached and the next evaluation retries. Cancellation and deadline
+	// errors of the evaluation's context are never cached.
+	NegativeTTL time.Duration
+	// Now returns the current time. It defaults to time.Now and is mainly
+	// useful in tests.
+	Now func() time.Time
+}
+
+// PrepareCacheStats counts cache activity since the cache was created.
+type PrepareCacheStats struct {
+	Hits      uint64 // Prepare results served from the cache, including cached errors
+	Misses    uint64 // Prepare calls made because no fresh entry existed
+	Coalesced uint64 // lookups that waited for an identical in-flight Prepare
+	Evictions uint64 // entries dropped to respect MaxEntries
+	Entries   int    // entries currently held
+}
+
+// PrepareCache reuses the results of Prepare across evaluations. Wrap the
+// rules and conditions whose prepare step is expensive with CachedRule or
+// CachedCondition; results are keyed by the wrapped rule or condition plus a
+// key derived from the input, so one cache can serve any number of them.
+//
+// Concurrent evaluations that miss on the same key share a single Prepare
+// call. A PrepareCache is safe for concurrent use.
+//
+// Only cache values that stay valid outside the evaluation that produced
+// them: a Loader thunk, for example, is bound to its evaluation and must not
+// be cached.
+type PrepareCache struct {
+	ttl         time.Duration
+	maxEntries  int
+	negativeTTL time.Duration
+	now         func() time.Time
+
+	mu       sync.Mutex
+	entries  map[prepareCacheKey]*list.Element
+	lru      *list.List // front is most recently used; values are *prepareCacheEntry
+	inflight map[prepareCacheKey]*prepareCall
+	stats    PrepareCacheStats
+}
+
+// prepareCacheKey identifies a result: the wrapped rule or condition and the
+// user key of the input.
+type prepareCacheKey struct {
+	owner any
+	key   string
+}
+
+// prepareCacheEntry is a cached Prepare result.
+type prepareCacheEntry struct {
+	key     prepareCacheKey
+	data    any
+	err     error
+	expires time.Time // zero for no expiry
+}
+
+// prepareCall is an in-flight Prepare shared by concurrent lookups.
+type prepareCall struct {
+	done chan struct{}
+	data any
+	err  error
+}
+
+// NewPrepareCache creates an empty cache.
+func NewPrepareCache(opts PrepareCacheOptions) *PrepareCache {
+	now := opts.Now
+	if now == nil {
+		now = time.Now
+	}
+	return &PrepareCache{
+		ttl:         opts.TTL,
+		maxEntries:  opts.MaxEntries,
+		negativeTTL: opts.NegativeTTL,
+		now:         now,
+		entries:     make(map[prepareCacheKey]*list.Element),
+		lru:         list.New(),
+		inflight:    make(map[prepareCacheKey]*prepareCall),
+	}
+}
+
+// params returns the configuration of the cache, which the fingerprints of
+// the rules and conditions it wraps include.
+func (c *PrepareCache) params() []any {
+	return []any{c.ttl, c.negativeTTL, c.maxEntries}
+}
+
+// Stats returns a snapshot of the cache counters.
+func (c *PrepareCache) Stats() PrepareCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	return stats
+}
+
+// Purge drops every cached entry. In-flight Prepare calls are unaffected.
+func (c *PrepareCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+	c.lru.Init()
+}
+
+// prepare returns the cached result of key or runs prepare to compute it.
+// ran reports whether prepare ran in this call, in which case the wrapped
+// rule or condition has already recorded its data in its own context.
+//
+// A lookup waiting for an in-flight call gives up when ctx is done. When the
+// in-flight call failed because its own context was done, the waiter runs
+// the lookup again rather than inheriting another evaluation's cancellation.
+func (c *PrepareCache) prepare(ctx context.Context, key prepareCacheKey, prepare func() (any, error)) (data any, ran bool, err error) {
+	for {
+		c.mu.Lock()
+		if elem, ok := c.entries[key]; ok {
+			entry := elem.Value.(*prepareCacheEntry)
+			if entry.expires.IsZero() || c.now().Before(entry.expires) {
+				c.lru.MoveToFront(elem)
+				c.stats.Hits++
+				c.mu.Unlock()
+				return entry.data, false, entry.err
+			}
+			c.remove(elem)
+		}
+
+		call, ok := c.inflight[key]
+		if !ok {
+			break
+		}
+		c.stats.Coalesced++
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+		if !isContextError(call.err) {
+			return call.data, false, call.err
+		}
+	}
+
+	call := &prepareCall{done: make(chan struct{}), err: ErrPreparePanic}
+	c.inflight[key] = call
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	// Release the waiters even if prepare panics; they then fail with
+	// ErrPreparePanic and nothing is cached.
+	defer func() {
+		c.mu.Lock()
+		delete(c.inflight, key)
+		c.mu.Unlock()
+		close(call.done)
+	}()
+
+	data, err = prepare()
+	call.data, call.err = data, err
+
+	c.mu.Lock()
+	c.store(key, data, err)
+	c.mu.Unlock()
+
+	return data, true, err
+}
+
+// isContextError reports whether err is a cancellation or deadline error,
+// which belongs to the evaluation that saw it rather than to the key.
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// store caches a result according to the TTLs. Context errors are never
+// cached. The caller must hold c.mu.
+func (c *PrepareCache) store(key prepareCacheKey, data any, err error) {
+	ttl := c.ttl
+	if err != nil {
+		if c.negativeTTL <= 0 || isContextError(err) {
+			return
+		}
+		ttl = c.negativeTTL
+	}
+
+	entry := &prepareCacheEntry{key: key, data: data, err: err}
+	if ttl > 0 {
+		entry.expires = c.now().Add(ttl)
+	}
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// remove drops elem. The caller must hold c.mu.
+func (c *PrepareCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*prepareCacheEntry).key)
+}
+
+// cachedPrepare runs owner's Prepare through the cache when the registry
+// holds an In. On a cache hit the stored data is recorded in the
+// preparedStore under owner, exactly as owner's own Prepare would have done,
+// so its Validate or IsValid reads it back unchanged.
+func cachedPrepare[In any](ctx context.Context, cache *PrepareCache, owner any, key func(In) string,
+	prepare func(context.Context) (any, error),
+) (any, error) {
+	input, ok := GetAs[In](ctx)
+	if !ok {
+		return prepare(ctx)
+	}
+
+	data, ran, err := cache.prepare(ctx, prepareCacheKey{owner: owner, key: key(input)}, func() (any, error) {
+		return prepare(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !ran {
+		recordPrepared(ctx, owner, data)
+	}
+	return data, nil
+}
+
+// cachedRule wraps a rule so its Prepare goes through a PrepareCache.
+type cachedRule[In any] struct {
+	Rule
+	cache *PrepareCache
+	key   func(In) string
+}
+
+var _ Rule = (*cachedRule[any])(nil)
+
+// unwrap returns the wrapped rule.
+func (r *cachedRule[In]) unwrap() any { return r.Rule }
+
+// Params returns the type and parameters of the wrapped rule, and the
+// configuration of the cache.
+func (r *cachedRule[In]) Params() []any {
+	return []any{reflect.TypeOf(r.Rule), paramsOf(r.Rule), r.cache.params()}
+}
+
+// Prepare serves the wrapped rule's Prepare from the cache.
+func (r *cachedRule[In]) Prepare(ctx context.Context) (any, error) {
+	return cachedPrepare(ctx, r.cache, r.Rule, r.key, r.Rule.Prepare)
+}
+
+// CachedRule wraps rule so the result of its Prepare is reused across
+// evaluations. key derives the cache key from the registry data; when the
+// data is not an In the cache is bypassed and rule prepares as usual.
+//
+// rule must record its prepared data under itself, as every built-in rule
+// with a prepare step does (see PutPrepared): on a hit the wrapper records
+// the cached data in its place.
+//
+// Example:
+//
+//	cache := rules.NewPrepareCache(rules.PrepareCacheOptions{TTL: time.Minute, MaxEntries: 10_000})
+//
+//	rule := rules.CachedRule(cache, rules.NewTypedRuleWithPrepare("creditOK",
+//	    func(ctx context.Context, u User) (Credit, error) { return bureau.Credit(ctx, u.ID) },
+//	    func(ctx context.Context, u User, c Credit) error { return checkCredit(c) },
+//	), func(u User) string { return u.ID })
+func CachedRule[In any](cache *PrepareCache, rule Rule, key func(In) string) Rule {
+	return &cachedRule[In]{Rule: rule, cache: cache, key: key}
+}
+
+// cachedCondition wraps a condition so its Prepare goes through a
+// PrepareCache.
+type cachedCondition[In any] struct {
+	Condition
+	cache *PrepareCache
+	key   func(In) string
+}
+
+var _ Condition = (*cachedCondition[any])(nil)
+
+// unwrap returns the wrapped condition.
+func (c *cachedCondition[In]) unwrap() any { return c.Condition }
+
+// Params returns the type and parameters of the wrapped condition, and the
+// configuration of the cache.
+func (c *cachedCondition[In]) Params() []any {
+	return []any{reflect.TypeOf(c.Condition), paramsOf(c.Condition), c.cache.params()}
+}
+
+// Prepare serves the wrapped condition's Prepare from the cache.
+func (c *cachedCondition[In]) Prepare(ctx context.Context) (any, error) {
+	return cachedPrepare(ctx, c.cache, c.Condition, c.key, c.Condition.Prepare)
+}
+
+// CachedCondition wraps condition so the result of its Prepare is reused
+// across evaluations. It behaves like CachedRule.
+//
+// Example:
+//
+//	isVIP := rules.CachedCondition(cache, rules.NewTypedConditionWithPrepare("isVIP",
+//	    func(ctx context.Context, u User) (Tier, error) { return crm.Tier(ctx, u.ID) },
+//	    func(ctx context.Context, u User, tier Tier) bool { return tier == TierVIP },
+//	), func(u User) string { return u.ID })
+func CachedCondition[In any](cache *PrepareCache, condition Condition, key func(In) string) Condition {
+	return &cachedCondition[In]{Condition: condition, cache: cache, key: key}
+}
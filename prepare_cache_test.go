@@ -0,0 +1,304 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock for cache tests.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// creditRule returns a cached rule whose prepare step counts its calls and
+// fails for users named "error".
+func creditRule(cache *PrepareCache, calls *atomic.Int64) Rule {
+	return CachedRule(cache, NewTypedRuleWithPrepare("creditOK",
+		func(ctx context.Context, u testUser) (int, error) {
+			calls.Add(1)
+			if u.Name == "error" {
+				return 0, errors.New("bureau unavailable")
+			}
+			return u.Age * 10, nil
+		},
+		func(ctx context.Context, u testUser, credit int) error {
+			if credit < 200 {
+				return Error{Field: "credit", Err: "credit too low", Code: "LOW_CREDIT"}
+			}
+			return nil
+		},
+	), func(u testUser) string { return u.Name })
+}
+
+func TestPrepareCache_HitsAndTTL(t *testing.T) {
+	t.Parallel()
+
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	cache := NewPrepareCache(PrepareCacheOptions{TTL: time.Minute, Now: clock.Now})
+	var calls atomic.Int64
+	tree := Rules(creditRule(cache, &calls))
+	ctx := context.Background()
+
+	for range 3 {
+		if err := ValidateWithData(ctx, tree, ProcessingHooks{}, "credit", testUser{Name: "alice", Age: 30}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// Same key, the cached credit is used even though the input changed.
+	err := ValidateWithData(ctx, tree, ProcessingHooks{}, "credit", testUser{Name: "alice", Age: 1})
+	if err != nil {
+		t.Fatalf("expected cached credit to be used, got %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("prepare calls = %d, want 1", calls.Load())
+	}
+
+	clock.Advance(time.Minute)
+	err = ValidateWithData(ctx, tree, ProcessingHooks{}, "credit", testUser{Name: "alice", Age: 1})
+	var re Error
+	if !errors.As(err, &re) || re.Code != "LOW_CREDIT" {
+		t.Errorf("expected expired entry to be refreshed, got %v", err)
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 3 || stats.Misses != 2 || stats.Entries != 1 {
+		t.Errorf("stats = %+v, want 3 hits, 2 misses, 1 entry", stats)
+	}
+}
+
+func TestPrepareCache_NegativeCaching(t *testing.T) {
+	t.Parallel()
+
+	user := testUser{Name: "error"}
+	ctx := context.Background()
+
+	testCases := []struct {
+		testName    string
+		negativeTTL time.Duration
+		wantCalls   int64
+	}{
+		{testName: "errors not cached", wantCalls: 3},
+		{testName: "errors cached", negativeTTL: time.Minute, wantCalls: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			t.Parallel()
+
+			cache := NewPrepareCache(PrepareCacheOptions{TTL: time.Hour, NegativeTTL: tc.negativeTTL})
+			var calls atomic.Int64
+			tree := Rules(creditRule(cache, &calls))
+
+			for range 3 {
+				if err := ValidateWithData(ctx, tree, ProcessingHooks{}, "credit", user); err == nil {
+					t.Fatal("expected prepare error")
+				}
+			}
+			if calls.Load() != tc.wantCalls {
+				t.Errorf("prepare calls = %d, want %d", calls.Load(), tc.wantCalls)
+			}
+		})
+	}
+}
+
+func TestPrepareCache_MaxEntries(t *testing.T) {
+	t.Parallel()
+
+	cache := NewPrepareCache(PrepareCacheOptions{MaxEntries: 2})
+	var calls atomic.Int64
+	tree := Rules(creditRule(cache, &calls))
+	ctx := context.Background()
+
+	for _, name := range []string{"a", "b", "a", "c", "a", "b"} {
+		if err := ValidateWithData(ctx, tree, ProcessingHooks{}, "credit", testUser{Name: name, Age: 30}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// a, b miss; a hits; c evicts b; a hits; b misses and evicts c.
+	stats := cache.Stats()
+	if stats.Misses != 4 || stats.Hits != 2 || stats.Evictions != 2 || stats.Entries != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	cache.Purge()
+	if cache.Stats().Entries != 0 {
+		t.Error("expected Purge to drop every entry")
+	}
+}
+
+func TestPrepareCache_Singleflight(t *testing.T) {
+	t.Parallel()
+
+	const evaluations = 8
+	cache := NewPrepareCache(PrepareCacheOptions{TTL: time.Minute})
+	release := make(chan struct{})
+	var calls atomic.Int64
+
+	condition := CachedCondition(cache, NewTypedConditionWithPrepare("isVIP",
+		func(ctx context.Context, u testUser) (string, error) {
+			calls.Add(1)
+			<-release
+			return "vip", nil
+		},
+		func(ctx context.Context, u testUser, tier string) bool { return tier == "vip" },
+	), func(u testUser) string { return u.Name })
+
+	tree := Node(condition, Rules(NewRulePure("vipOnly", func() error {
+		return Error{Field: "tier", Err: "vip reached", Code: "VIP"}
+	})))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, evaluations)
+	for range evaluations {
+		wg.Go(func() {
+			errs <- ValidateWithData(context.Background(), tree, ProcessingHooks{}, "vip", testUser{Name: "alice"})
+		})
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for cache.Stats().Coalesced < evaluations-1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("evaluations did not coalesce: %+v", cache.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		var re Error
+		if !errors.As(err, &re) || re.Code != "VIP" {
+			t.Errorf("expected every evaluation to see the shared result, got %v", err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("prepare calls = %d, want 1", calls.Load())
+	}
+}
+
+func TestPrepareCache_BypassedForOtherInput(t *testing.T) {
+	t.Parallel()
+
+	cache := NewPrepareCache(PrepareCacheOptions{})
+	var calls atomic.Int64
+	tree := Rules(creditRule(cache, &calls))
+
+	err := ValidateWithData(context.Background(), tree, ProcessingHooks{}, "credit", testProduct{})
+	var re Error
+	if !errors.As(err, &re) || re.Code != ErrorCodeTypeMismatch {
+		t.Errorf("expected the wrapped rule's TYPE_MISMATCH, got %v", err)
+	}
+	if stats := cache.Stats(); stats.Misses != 0 || stats.Hits != 0 {
+		t.Errorf("cache should be bypassed, stats = %+v", stats)
+	}
+}
+
+func TestPrepareCache_WaitersAndFailures(t *testing.T) {
+	t.Parallel()
+
+	key := prepareCacheKey{owner: "rule", key: "alice"}
+
+	t.Run("waiter gives up when its context is done", func(t *testing.T) {
+		t.Parallel()
+
+		cache := NewPrepareCache(PrepareCacheOptions{})
+		started, release := make(chan struct{}), make(chan struct{})
+		go cache.prepare(context.Background(), key, func() (any, error) {
+			close(started)
+			<-release
+			return 1, nil
+		})
+		defer close(release)
+		<-started
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, _, err := cache.prepare(ctx, key, func() (any, error) { return 2, nil }); !errors.Is(err, context.Canceled) {
+			t.Errorf("error = %v, want context.Canceled", err)
+		}
+	})
+
+	t.Run("context errors are not cached", func(t *testing.T) {
+		t.Parallel()
+
+		cache := NewPrepareCache(PrepareCacheOptions{NegativeTTL: time.Hour})
+		var calls atomic.Int64
+		for range 2 {
+			_, ran, err := cache.prepare(context.Background(), key, func() (any, error) {
+				calls.Add(1)
+				return nil, context.DeadlineExceeded
+			})
+			if !ran || !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("ran = %v, error = %v", ran, err)
+			}
+		}
+		if calls.Load() != 2 || cache.Stats().Entries != 0 {
+			t.Errorf("calls = %d, stats = %+v", calls.Load(), cache.Stats())
+		}
+	})
+
+	t.Run("panic releases waiters with an error", func(t *testing.T) {
+		t.Parallel()
+
+		cache := NewPrepareCache(PrepareCacheOptions{NegativeTTL: time.Hour})
+		release := make(chan struct{})
+		panicked := make(chan any, 1)
+		go func() {
+			defer func() { panicked <- recover() }()
+			cache.prepare(context.Background(), key, func() (any, error) {
+				<-release
+				panic("bureau exploded")
+			})
+		}()
+
+		deadline := time.Now().Add(5 * time.Second)
+		waited := make(chan error, 1)
+		go func() {
+			for cache.Stats().Misses == 0 {
+				if time.Now().After(deadline) {
+					waited <- errors.New("leader never started")
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+			_, _, err := cache.prepare(context.Background(), key, func() (any, error) { return 1, nil })
+			waited <- err
+		}()
+		for cache.Stats().Coalesced == 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("waiter never coalesced: %+v", cache.Stats())
+			}
+			time.Sleep(time.Millisecond)
+		}
+		close(release)
+
+		if err := <-waited; !errors.Is(err, ErrPreparePanic) {
+			t.Errorf("waiter error = %v, want ErrPreparePanic", err)
+		}
+		if p := <-panicked; p != "bureau exploded" {
+			t.Errorf("leader recovered %v, want the original panic", p)
+		}
+		if data, ran, err := cache.prepare(context.Background(), key, func() (any, error) { return 1, nil }); !ran || err != nil || data != 1 {
+			t.Errorf("after panic: data = %v, ran = %v, error = %v", data, ran, err)
+		}
+	})
+}
@@ -0,0 +1,509 @@
+// Package prometheus renders rule reports in the Prometheus text exposition
+// format (version 0.0.4), so the metrics of rules.Report can be scraped
+// without converting them by hand.
+//
+// Every metric becomes a metric family, named after the metric with
+// characters outside [a-zA-Z0-9_:] replaced by underscores and prefixed with
+// the namespace. Outcome.Field and Outcome.Labels become labels, with the
+// same replacement; the label names the exporter sets itself (see
+// ReservedLabels) cannot be used by Outcome.Labels or the labels given to
+// Add. Two metric names, or two label names, that end up the same once
+// sanitized are rejected rather than merged. Kinds map as follows:
+//
+//   - KindCounter: a counter, "<name>_total", summed across reports.
+//   - KindScore: a gauge holding the last score.
+//   - KindGauge: a gauge holding the last value.
+//   - KindDistinct: a gauge holding the estimated distinct count; sketches
+//     are merged across reports, so the count does not double count items.
+//   - KindValid: a counter, "<name>_total", counting reports by a valid="true"
+//     or valid="false" label.
+//   - KindHistogram: a histogram with _bucket, _sum and _count series. Buckets
+//     are cumulative, as in rules.Histogram, and a le="+Inf" bucket is added
+//     when the histogram has none. Exponential histograms only expose their
+//     count and sum. A series keeps the layout of its first non-empty
+//     histogram: a series started by an exponential histogram exposes the
+//     count and sum of later bucketed ones, while an exponential histogram
+//     cannot be added to a bucketed series, having no boundaries to convert
+//     to.
+//   - KindSummary: a summary with quantile="0.5", "0.9", "0.95" and "0.99"
+//     series, plus _sum and _count; summaries are merged across reports.
+//
+// Write renders a single report. A Registry accumulates reports and serves
+// the accumulated state over HTTP.
+package prometheus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mishudark/rules"
+)
+
+// ContentType is the content type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Write renders the metrics of report to w, with metric names prefixed by
+// namespace (which may be empty).
+//
+// Example:
+//
+//	report, _ := rules.EvaluateMetricsWithData(ctx, tree, hooks, "checkout", order)
+//	_ = prometheus.Write(os.Stdout, "checkout", report)
+func Write(w io.Writer, namespace string, report rules.Report) error {
+	reg := NewRegistry(namespace)
+	if err := reg.Add(report, nil); err != nil {
+		return err
+	}
+	_, err := reg.WriteTo(w)
+	return err
+}
+
+// Registry accumulates the metrics of many reports. It is safe for
+// concurrent use, and implements http.Handler to serve the accumulated state.
+//
+// Example:
+//
+//	metrics := prometheus.NewRegistry("rules")
+//	http.Handle("/metrics", metrics)
+//
+//	report, err := rules.EvaluateMetricsWithData(ctx, tree, hooks, "checkout", order)
+//	_ = metrics.Add(report, map[string]string{"tree": "checkout"})
+type Registry struct {
+	namespace string
+
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+var _ http.Handler = (*Registry)(nil)
+
+// family is a metric family: one name, one type, many series.
+type family struct {
+	name   string
+	metric string // the metric name, before sanitizing
+	typ    string // "counter", "gauge" or "histogram"
+	help   string
+	series map[string]*series // keyed by the rendered label set
+}
+
+// series is one labelled time series of a family.
+type series struct {
+	labels    string // rendered label pairs, without braces
+	value     float64
+	histogram rules.Histogram
+	distinct  rules.HyperLogLog
+	summary   rules.Summary
+	layout    histogramLayout
+}
+
+// histogramLayout is the layout of a histogram outcome.
+type histogramLayout int
+
+const (
+	layoutEmpty       histogramLayout = iota // no observations: fits any layout
+	layoutBuckets                            // rules.Histogram buckets
+	layoutExponential                        // count and sum of rules.ExponentialHistogram
+)
+
+// layoutOf returns the layout of a histogram outcome.
+func layoutOf(outcome rules.Outcome) histogramLayout {
+	switch {
+	case len(outcome.Histogram.Buckets) > 0:
+		return layoutBuckets
+	case outcome.Exponential.Total > 0:
+		return layoutExponential
+	}
+	return layoutEmpty
+}
+
+// ReservedLabels are the label names set by the exporter: "field" holds
+// Outcome.Field, "valid" the outcome of a KindValid metric, and "le" and
+// "quantile" the buckets and quantiles of histograms and summaries.
+var ReservedLabels = []string{"field", "le", "quantile", "valid"}
+
+// summaryQuantiles are the quantiles exposed for summaries.
+var summaryQuantiles = []float64{0.5, 0.9, 0.95, 0.99}
+
+// NewRegistry creates an empty Registry whose metric names are prefixed by
+// namespace (which may be empty).
+func NewRegistry(namespace string) *Registry {
+	return &Registry{namespace: namespace, families: make(map[string]*family)}
+}
+
+// Add accumulates the metrics of report. labels are added to every series
+// of the report, e.g. to tell trees apart. Add fails, without changing the
+// registry, when a metric name is reused with another kind or a histogram
+// with other bucket boundaries, when an exponential histogram is added to a
+// bucketed series, when labels or the labels of an outcome use one of the
+// ReservedLabels, or when two metric names or two label names are the same
+// once sanitized.
+func (r *Registry) Add(report rules.Report, labels map[string]string) error {
+	if err := checkLabels(labels); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	type update struct {
+		family  *family
+		key     string
+		outcome rules.Outcome
+	}
+	updates := make([]update, 0, len(report.Metrics))
+	created := make(map[string]*family)
+	for _, key := range slices.Sorted(maps.Keys(report.Metrics)) {
+		outcome := report.Metrics[key]
+		if err := checkLabels(outcome.Labels); err != nil {
+			return fmt.Errorf("%w in metric %s", err, key)
+		}
+		metric := outcome.MetricName()
+		if metric == "" { // a hand-built report
+			metric = key
+		}
+		name, typ := r.familyName(metric, outcome.Kind)
+		f := r.families[name]
+		if f == nil {
+			f = created[name]
+		}
+		if f == nil {
+			f = &family{
+				name:   name,
+				metric: metric,
+				typ:    typ,
+				help:   fmt.Sprintf("Rule metric %q (%s).", metric, outcome.Kind),
+				series: make(map[string]*series),
+			}
+			created[name] = f
+		}
+		if f.metric != metric {
+			return fmt.Errorf("prometheus: metrics %q and %q are both exposed as %s", f.metric, metric, name)
+		}
+		if f.typ != typ {
+			return fmt.Errorf("prometheus: metric %s is a %s, got a %s", name, f.typ, typ)
+		}
+
+		seriesKey, err := renderLabels(outcome, labels)
+		if err != nil {
+			return fmt.Errorf("%w in metric %s", err, key)
+		}
+		if s := f.series[seriesKey]; s != nil && typ == "histogram" {
+			if err := s.accepts(outcome); err != nil {
+				return fmt.Errorf("prometheus: histogram %s{%s} %w", name, seriesKey, err)
+			}
+		}
+		updates = append(updates, update{family: f, key: seriesKey, outcome: outcome})
+	}
+
+	maps.Copy(r.families, created)
+	for _, u := range updates {
+		u.family.observe(u.key, u.outcome)
+	}
+	return nil
+}
+
+// familyName returns the exposed name and type of the metric named metric.
+func (r *Registry) familyName(metric string, kind rules.Kind) (name, typ string) {
+	name = sanitizeName(metric)
+	if r.namespace != "" {
+		name = sanitizeName(r.namespace) + "_" + name
+	}
+	switch kind {
+	case rules.KindCounter, rules.KindValid:
+		if !strings.HasSuffix(name, "_total") {
+			name += "_total"
+		}
+		return name, "counter"
+	case rules.KindHistogram:
+		return name, "histogram"
+	case rules.KindSummary:
+		return name, "summary"
+	default:
+		return name, "gauge"
+	}
+}
+
+// observe accumulates outcome into the series identified by key.
+func (f *family) observe(key string, outcome rules.Outcome) {
+	if outcome.Kind == rules.KindValid {
+		key = joinLabels(key, `valid="`+strconv.FormatBool(outcome.Valid)+`"`)
+	}
+	s := f.series[key]
+	if s == nil {
+		s = &series{labels: key}
+		f.series[key] = s
+	}
+
+	switch outcome.Kind {
+	case rules.KindCounter:
+		s.value += outcome.Count
+	case rules.KindValid:
+		s.value++
+	case rules.KindHistogram:
+		layout := layoutOf(outcome)
+		if s.layout == layoutEmpty {
+			s.layout = layout
+		}
+		h := outcome.Histogram
+		switch {
+		case layout == layoutExponential:
+			// The text format has no native histograms: expose the count
+			// and sum of the exponential histogram.
+			h = rules.Histogram{Total: outcome.Exponential.Total, Sum: outcome.Exponential.Sum}
+		case s.layout == layoutExponential:
+			h = rules.Histogram{Total: h.Total, Sum: h.Sum}
+		}
+		s.histogram = mergeHistogram(s.histogram, h)
+	case rules.KindGauge:
+		s.value = outcome.Value
+	case rules.KindDistinct:
+		s.distinct.Merge(outcome.Distinct)
+		s.value = float64(s.distinct.Estimate())
+	case rules.KindSummary:
+		s.summary.Merge(outcome.Summary)
+	default:
+		s.value = outcome.Score
+	}
+}
+
+// accepts fails when the histogram of outcome cannot be added to the
+// series.
+func (s *series) accepts(outcome rules.Outcome) error {
+	if s.layout != layoutBuckets {
+		return nil
+	}
+	switch layoutOf(outcome) {
+	case layoutExponential:
+		return fmt.Errorf("has buckets %v, got an exponential histogram", s.histogram.Buckets)
+	case layoutBuckets:
+		if !slices.Equal(s.histogram.Buckets, outcome.Histogram.Buckets) {
+			return fmt.Errorf("has buckets %v, got %v", s.histogram.Buckets, outcome.Histogram.Buckets)
+		}
+	}
+	return nil
+}
+
+// mergeHistogram returns the bucket-wise sum of h and other, which share
+// their boundaries unless h is still empty.
+func mergeHistogram(h, other rules.Histogram) rules.Histogram {
+	if h.Buckets == nil && h.Total == 0 {
+		return rules.Histogram{
+			Buckets: slices.Clone(other.Buckets),
+			Counts:  slices.Clone(other.Counts),
+			Total:   other.Total,
+			Sum:     other.Sum,
+		}
+	}
+	for i := range h.Counts {
+		if i < len(other.Counts) {
+			h.Counts[i] += other.Counts[i]
+		}
+	}
+	h.Total += other.Total
+	h.Sum += other.Sum
+	return h
+}
+
+// WriteTo renders the accumulated metrics to w, families sorted by name and
+// series by labels. It implements io.WriterTo.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw}
+	for _, name := range slices.Sorted(maps.Keys(r.families)) {
+		r.families[name].write(cw)
+	}
+	if cw.err == nil {
+		cw.err = bw.Flush()
+	}
+	return cw.n, cw.err
+}
+
+// ServeHTTP serves the accumulated metrics in the text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	_, _ = r.WriteTo(w)
+}
+
+// write renders the family.
+func (f *family) write(w *countingWriter) {
+	w.printf("# HELP %s %s\n", f.name, escapeHelp(f.help))
+	w.printf("# TYPE %s %s\n", f.name, f.typ)
+	for _, key := range slices.Sorted(maps.Keys(f.series)) {
+		s := f.series[key]
+		switch f.typ {
+		case "histogram":
+			f.writeHistogram(w, s)
+		case "summary":
+			for _, q := range summaryQuantiles {
+				w.sample(f.name, joinLabels(s.labels, `quantile="`+formatFloat(q)+`"`), s.summary.Quantile(q))
+			}
+			w.sample(f.name+"_sum", s.labels, s.summary.Sum)
+			w.sample(f.name+"_count", s.labels, s.summary.Count)
+		default:
+			w.sample(f.name, s.labels, s.value)
+		}
+	}
+}
+
+// writeHistogram renders the bucket, sum and count series of a histogram.
+func (f *family) writeHistogram(w *countingWriter, s *series) {
+	h := s.histogram
+	hasInf := false
+	for i, boundary := range h.Buckets {
+		hasInf = hasInf || math.IsInf(boundary, 1)
+		w.sample(f.name+"_bucket", joinLabels(s.labels, `le="`+formatFloat(boundary)+`"`), float64(h.Counts[i]))
+	}
+	if !hasInf {
+		w.sample(f.name+"_bucket", joinLabels(s.labels, `le="+Inf"`), float64(h.Total))
+	}
+	w.sample(f.name+"_sum", s.labels, h.Sum)
+	w.sample(f.name+"_count", s.labels, float64(h.Total))
+}
+
+// countingWriter writes formatted output, keeping the first error and the
+// number of bytes written.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (w *countingWriter) printf(format string, args ...any) {
+	if w.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(w.w, format, args...)
+	w.n += int64(n)
+	w.err = err
+}
+
+// sample writes one sample line.
+func (w *countingWriter) sample(name, labels string, value float64) {
+	if labels != "" {
+		w.printf("%s{%s} %s\n", name, labels, formatFloat(value))
+		return
+	}
+	w.printf("%s %s\n", name, formatFloat(value))
+}
+
+// renderLabels renders the labels of outcome and the extra labels, sorted by
+// sanitized name. A label of outcome overrides an extra label of the same
+// name. Field is exposed as the "field" label.
+func renderLabels(outcome rules.Outcome, extra map[string]string) (string, error) {
+	labels := make(map[string]string, len(outcome.Labels)+len(extra)+1)
+	names := make(map[string]string, len(labels)) // sanitized name -> name
+	for _, set := range []map[string]string{extra, outcome.Labels} {
+		for _, name := range slices.Sorted(maps.Keys(set)) {
+			clean := sanitizeLabel(name)
+			if prev, ok := names[clean]; ok && prev != name {
+				return "", labelCollision(prev, name, clean)
+			}
+			names[clean], labels[clean] = name, set[name]
+		}
+	}
+	if outcome.Field != "" {
+		labels["field"] = outcome.Field
+	}
+
+	pairs := make([]string, 0, len(labels))
+	for _, name := range slices.Sorted(maps.Keys(labels)) {
+		pairs = append(pairs, name+`="`+escapeValue(labels[name])+`"`)
+	}
+	return strings.Join(pairs, ","), nil
+}
+
+// checkLabels fails when a label name, once sanitized, is one of the
+// ReservedLabels, which would overwrite or duplicate a label of the exporter,
+// or the name of another label.
+func checkLabels(labels map[string]string) error {
+	names := make(map[string]string, len(labels))
+	for _, name := range slices.Sorted(maps.Keys(labels)) {
+		clean := sanitizeLabel(name)
+		if slices.Contains(ReservedLabels, clean) {
+			return fmt.Errorf("prometheus: label %q is reserved", name)
+		}
+		if prev, ok := names[clean]; ok {
+			return labelCollision(prev, name, clean)
+		}
+		names[clean] = name
+	}
+	return nil
+}
+
+// labelCollision is the error of two label names sanitized to the same one.
+func labelCollision(a, b, sanitized string) error {
+	return fmt.Errorf("prometheus: labels %q and %q are both exposed as %s", a, b, sanitized)
+}
+
+// joinLabels appends a rendered pair to a rendered label set.
+func joinLabels(labels, pair string) string {
+	if labels == "" {
+		return pair
+	}
+	return labels + "," + pair
+}
+
+// sanitizeName maps s onto the metric name alphabet [a-zA-Z_:][a-zA-Z0-9_:]*.
+func sanitizeName(s string) string {
+	return sanitize(s, true)
+}
+
+// sanitizeLabel maps s onto the label name alphabet [a-zA-Z_][a-zA-Z0-9_]*.
+func sanitizeLabel(s string) string {
+	return sanitize(s, false)
+}
+
+func sanitize(s string, colons bool) string {
+	var b strings.Builder
+	for i, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', colons && c == ':':
+			b.WriteRune(c)
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(c)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// escapeValue escapes a label value.
+func escapeValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// escapeHelp escapes a HELP docstring.
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+// formatFloat formats a sample value or bucket boundary.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,333 @@
+package prometheus
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mishudark/rules"
+)
+
+type order struct {
+	Total   float64
+	Country string
+}
+
+func orderTree() rules.Evaluable {
+	latency := rules.NewHistogram([]float64{10, 100})
+	latency.Observe(5)
+	latency.Observe(50)
+	latency.Observe(500)
+
+	return rules.Rules(
+		rules.NewTypedMetricRule("revenue", rules.KindCounter, "revenue",
+			func(ctx context.Context, o order) (rules.Outcome, error) {
+				out := rules.CounterValue(o.Total)
+				out.Labels = map[string]string{"country": o.Country}
+				return out, nil
+			}),
+		rules.NewTypedMetricRule("risk score", rules.KindScore, "",
+			func(ctx context.Context, o order) (rules.Outcome, error) {
+				return rules.ScoreValue(o.Total/100, 1), nil
+			}),
+		rules.NewTypedMetricRule("small", rules.KindValid, "",
+			func(ctx context.Context, o order) (rules.Outcome, error) {
+				return rules.ValidValue(o.Total < 100, nil), nil
+			}),
+		rules.NewTypedMetricRule("latency_ms", rules.KindHistogram, "",
+			func(ctx context.Context, o order) (rules.Outcome, error) {
+				return rules.HistogramValue(latency), nil
+			}),
+	)
+}
+
+func evaluate(t *testing.T, o order) rules.Report {
+	t.Helper()
+	report, err := rules.EvaluateMetricsWithData(context.Background(), orderTree(), rules.ProcessingHooks{}, "orders", o)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return report
+}
+
+func TestWrite(t *testing.T) {
+	t.Parallel()
+
+	var b strings.Builder
+	if err := Write(&b, "shop", evaluate(t, order{Total: 40, Country: `U"S`})); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP shop_latency_ms Rule metric "latency_ms" (histogram).
+# TYPE shop_latency_ms histogram
+shop_latency_ms_bucket{le="10"} 1
+shop_latency_ms_bucket{le="100"} 2
+shop_latency_ms_bucket{le="+Inf"} 3
+shop_latency_ms_sum 555
+shop_latency_ms_count 3
+# HELP shop_revenue_total Rule metric "revenue" (counter).
+# TYPE shop_revenue_total counter
+shop_revenue_total{country="U\"S",field="revenue"} 40
+# HELP shop_risk_score Rule metric "risk score" (score).
+# TYPE shop_risk_score gauge
+shop_risk_score 0.4
+# HELP shop_small_total Rule metric "small" (valid).
+# TYPE shop_small_total counter
+shop_small_total{valid="true"} 1
+`
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestRegistry_Accumulates(t *testing.T) {
+	t.Parallel()
+
+	reg := NewRegistry("")
+	for _, o := range []order{{Total: 40, Country: "US"}, {Total: 200, Country: "US"}, {Total: 10, Country: "DE"}} {
+		if err := reg.Add(evaluate(t, o), map[string]string{"tree": "orders"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var b strings.Builder
+	if _, err := reg.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, line := range []string{
+		`revenue_total{country="DE",field="revenue",tree="orders"} 10`,
+		`revenue_total{country="US",field="revenue",tree="orders"} 240`,
+		`risk_score{tree="orders"} 0.1`,
+		`small_total{tree="orders",valid="false"} 1`,
+		`small_total{tree="orders",valid="true"} 2`,
+		`latency_ms_bucket{tree="orders",le="100"} 6`,
+		`latency_ms_count{tree="orders"} 9`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+}
+
+func TestWrite_LabeledSeries(t *testing.T) {
+	t.Parallel()
+
+	report := rules.Report{Metrics: map[string]rules.Outcome{}}
+	for _, region := range []string{"us", "eu"} {
+		o := rules.CounterValue(1)
+		o.Name = "requests"
+		o.Labels = map[string]string{"region": region}
+		report.Metrics[rules.SeriesKey(o.Name, o.Labels)] = o
+	}
+
+	var b strings.Builder
+	if err := Write(&b, "", report); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP requests_total Rule metric "requests" (counter).
+# TYPE requests_total counter
+requests_total{region="eu"} 1
+requests_total{region="us"} 1
+`
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestRegistry_GaugeDistinctSummary(t *testing.T) {
+	t.Parallel()
+
+	report := func(value float64, merchants ...string) rules.Report {
+		sketch := rules.NewHyperLogLog(0)
+		amounts := rules.NewSummary(0)
+		for _, m := range merchants {
+			sketch.Add(m)
+			amounts.Observe(value)
+		}
+		return rules.Report{Metrics: map[string]rules.Outcome{
+			"depth":     rules.GaugeValue(value, time.Now()),
+			"merchants": rules.DistinctValue(sketch),
+			"amounts":   rules.SummaryValue(amounts),
+		}}
+	}
+
+	reg := NewRegistry("")
+	for _, r := range []rules.Report{report(3, "a", "b"), report(5, "b", "c")} {
+		if err := reg.Add(r, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var b strings.Builder
+	if _, err := reg.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# TYPE depth gauge", "depth 5",
+		"# TYPE merchants gauge", "merchants 3",
+		"# TYPE amounts summary", `amounts{quantile="0.99"} 5`, "amounts_sum 16", "amounts_count 4",
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, b.String())
+		}
+	}
+}
+
+func TestRegistry_Conflicts(t *testing.T) {
+	t.Parallel()
+
+	reg := NewRegistry("")
+	if err := reg.Add(rules.Report{Metrics: map[string]rules.Outcome{
+		"latency": {Kind: rules.KindHistogram, Histogram: rules.NewHistogram([]float64{1, math.Inf(1)})},
+	}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	conflicts := []rules.Report{
+		{Metrics: map[string]rules.Outcome{"latency": {Kind: rules.KindHistogram, Histogram: rules.NewHistogram([]float64{2})}}},
+		{Metrics: map[string]rules.Outcome{"latency": {Kind: rules.KindScore, Score: 1}}},
+		{Metrics: map[string]rules.Outcome{"latency": exponential(1.5)}},
+		{Metrics: map[string]rules.Outcome{
+			"queue-depth": {Kind: rules.KindScore, Score: 1},
+			"queue_depth": {Kind: rules.KindScore, Score: 2},
+		}},
+		{Metrics: map[string]rules.Outcome{"depth": {Kind: rules.KindScore, Labels: map[string]string{"a-b": "1", "a_b": "2"}}}},
+	}
+	for _, report := range conflicts {
+		if err := reg.Add(report, nil); err == nil {
+			t.Errorf("expected a conflict adding %v", report.Metrics)
+		}
+	}
+	depth := rules.Report{Metrics: map[string]rules.Outcome{"depth": {Kind: rules.KindScore, Labels: map[string]string{"a_b": "1"}}}}
+	if err := reg.Add(depth, map[string]string{"a.b": "2"}); err == nil || !strings.Contains(err.Error(), "both exposed as a_b") {
+		t.Errorf("expected a label collision, got %v", err)
+	}
+
+	var b strings.Builder
+	_, _ = reg.WriteTo(&b)
+	if strings.Count(b.String(), `le="+Inf"`) != 1 {
+		t.Errorf("the +Inf bucket must not be duplicated:\n%s", b.String())
+	}
+}
+
+// exponential returns a histogram outcome holding an exponential histogram of
+// the observations.
+func exponential(observations ...float64) rules.Outcome {
+	h := rules.NewExponentialHistogram(0)
+	for _, v := range observations {
+		h.Observe(v)
+	}
+	return rules.ExponentialHistogramValue(h)
+}
+
+func TestRegistry_HistogramLayouts(t *testing.T) {
+	t.Parallel()
+
+	bucketed := rules.NewHistogram([]float64{1})
+	bucketed.Observe(0.5)
+	bucketed.Observe(4)
+	reports := []rules.Outcome{
+		{Kind: rules.KindHistogram}, // empty: fits any layout
+		exponential(1, 2),
+		rules.HistogramValue(bucketed), // exposed through its count and sum
+		{Kind: rules.KindHistogram},
+	}
+	reg := NewRegistry("")
+	for _, o := range reports {
+		if err := reg.Add(rules.Report{Metrics: map[string]rules.Outcome{"latency": o}}, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	var b strings.Builder
+	_, _ = reg.WriteTo(&b)
+	for _, line := range []string{"latency_bucket{le=\"+Inf\"} 4\n", "latency_sum 7.5\n", "latency_count 4\n"} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("missing %q in:\n%s", line, b.String())
+		}
+	}
+	if strings.Contains(b.String(), `le="1"`) {
+		t.Errorf("an exponential series must not expose buckets:\n%s", b.String())
+	}
+}
+
+func TestRegistry_ReservedLabels(t *testing.T) {
+	t.Parallel()
+
+	labeled := func(kind rules.Kind, labels map[string]string) rules.Report {
+		o := rules.Outcome{Kind: kind, Name: "m", Field: "Total", Labels: labels, Histogram: rules.NewHistogram([]float64{1})}
+		return rules.Report{Metrics: map[string]rules.Outcome{"m": o}}
+	}
+
+	tests := []struct {
+		name   string
+		report rules.Report
+		extra  map[string]string
+	}{
+		{"outcome field", labeled(rules.KindScore, map[string]string{"field": "x"}), nil},
+		{"outcome le", labeled(rules.KindHistogram, map[string]string{"le": "1"}), nil},
+		{"outcome quantile", labeled(rules.KindSummary, map[string]string{"quantile": "0.5"}), nil},
+		{"outcome valid", labeled(rules.KindValid, map[string]string{"valid": "maybe"}), nil},
+		{"extra field", labeled(rules.KindScore, nil), map[string]string{"field": "x"}},
+		{"extra le", labeled(rules.KindHistogram, nil), map[string]string{"le": "1"}},
+		{"extra quantile", labeled(rules.KindSummary, nil), map[string]string{"quantile": "0.5"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			reg := NewRegistry("")
+			if err := reg.Add(tt.report, tt.extra); err == nil || !strings.Contains(err.Error(), "reserved") {
+				t.Fatalf("expected a reserved label error, got %v", err)
+			}
+			var b strings.Builder
+			if _, err := reg.WriteTo(&b); err != nil || b.Len() != 0 {
+				t.Errorf("registry changed by a rejected report:\n%s", b.String())
+			}
+		})
+	}
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	t.Parallel()
+
+	reg := NewRegistry("rules")
+	if err := reg.Add(evaluate(t, order{Total: 1}), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	reg.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if got := rec.Header().Get("Content-Type"); got != ContentType {
+		t.Errorf("Content-Type = %q", got)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	if !strings.Contains(string(body), "# TYPE rules_revenue_total counter\n") {
+		t.Errorf("unexpected body:\n%s", body)
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		in, name, label string
+	}{
+		{in: "latency_ms", name: "latency_ms", label: "latency_ms"},
+		{in: "http:requests", name: "http:requests", label: "http_requests"},
+		{in: "2xx rate", name: "_2xx_rate", label: "_2xx_rate"},
+		{in: "", name: "_", label: "_"},
+	}
+	for _, tc := range testCases {
+		if got := sanitizeName(tc.in); got != tc.name {
+			t.Errorf("sanitizeName(%q) = %q, want %q", tc.in, got, tc.name)
+		}
+		if got := sanitizeLabel(tc.in); got != tc.label {
+			t.Errorf("sanitizeLabel(%q) = %q, want %q", tc.in, got, tc.label)
+		}
+	}
+}
@@ -0,0 +1,501 @@
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// CassetteVersion is the version of the Cassette format written by a
+// Recorder. Replay rejects cassettes of another version.
+const CassetteVersion = 1
+
+// ErrCassetteMismatch is returned when a cassette cannot be replayed against
+// a tree: it was recorded from a different tree (its fingerprint differs), in
+// another format version, or no cassette was given for a target.
+var ErrCassetteMismatch = errors.New("rules: cassette does not match the tree")
+
+// Cassette is the recording of one target's evaluation: its registry payload
+// and named slots, and the data every rule and condition prepared, as JSON. Entries are keyed
+// by stable step IDs derived from the position of each rule and condition in
+// the tree, e.g. "$.1/if:isVIP" for the condition of the second child of the
+// root and "$.1.0/rules[2]:creditOK" for the third rule below it.
+type Cassette struct {
+	Version     int    `json:"version"`
+	Name        string `json:"name"`
+	Index       int    `json:"index"` // position of the target in its batch
+	Fingerprint string `json:"fingerprint"`
+	// Payload is the default data slot of the registry.
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// Slots holds the named slots of the registry, by slot name. Computed
+	// slots are recorded when they were read during the evaluation.
+	Slots map[string]json.RawMessage `json:"slots,omitempty"`
+	// Prepared holds the prepared data of each step, by step ID.
+	Prepared map[string]json.RawMessage `json:"prepared,omitempty"`
+	// Errors holds the Prepare errors of each step, by step ID.
+	Errors map[string]RecordedError `json:"errors,omitempty"`
+	// Unrecorded lists the steps whose prepared data could not be encoded as
+	// JSON (e.g. a Thunk). They are prepared live on replay.
+	Unrecorded []string `json:"unrecorded,omitempty"`
+}
+
+// RecordedError is a Prepare error as stored in a Cassette. Errors of type
+// Error keep their field and code; other errors keep their message.
+type RecordedError struct {
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+	Code    string `json:"code,omitempty"`
+}
+
+// recordError converts err for storage.
+func recordError(err error) RecordedError {
+	var re Error
+	if errors.As(err, &re) {
+		return RecordedError{Message: re.Err, Field: re.Field, Code: re.Code}
+	}
+	return RecordedError{Message: err.Error()}
+}
+
+// err rebuilds the recorded error.
+func (e RecordedError) err() error {
+	if e.Field == "" && e.Code == "" {
+		return errors.New(e.Message)
+	}
+	return Error{Field: e.Field, Err: e.Message, Code: e.Code}
+}
+
+// DecodePayload decodes the recorded payload into v.
+func (c Cassette) DecodePayload(v any) error {
+	if c.Payload == nil {
+		return fmt.Errorf("%w: no payload recorded", ErrCassetteMismatch)
+	}
+	return json.Unmarshal(c.Payload, v)
+}
+
+// RestoreSlots stores the recorded slots in reg, replacing slots of the same
+// name. A restored slot is decoded into the type of the key it is read with,
+// as with json.Unmarshal.
+//
+// Example:
+//
+//	reg := rules.NewDataRegistry(order)
+//	cassette.RestoreSlots(reg)
+//	err := rules.Validate(rules.WithRegistry(rules.WithReplay(ctx, cassette), reg), tree, hooks, "checkout")
+func (c Cassette) RestoreSlots(reg *DataRegistry) {
+	if len(c.Slots) == 0 {
+		return
+	}
+	if reg.slots == nil {
+		reg.slots = make(map[string]any, len(c.Slots))
+	}
+	for name, raw := range c.Slots {
+		reg.slots[name] = recordedSlot(raw)
+	}
+}
+
+// Recorder collects the cassettes of the evaluations run with a context from
+// WithRecording. It is safe for concurrent use.
+type Recorder struct {
+	mu        sync.Mutex
+	cassettes []Cassette
+}
+
+// NewRecorder creates an empty Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Cassettes returns the recorded cassettes, one per evaluated target, in the
+// order the evaluations finished.
+func (r *Recorder) Cassettes() []Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Cassette(nil), r.cassettes...)
+}
+
+func (r *Recorder) add(c Cassette) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassettes = append(r.cassettes, c)
+}
+
+// recorderKey is the context key for the Recorder.
+type recorderKey struct{}
+
+// WithRecording returns a context that makes the engine record every
+// evaluation into rec: the registry payload and the prepared data and
+// Prepare errors of every rule and condition, encoded as JSON. A cassette is
+// recorded even when the evaluation fails, including when a condition fails
+// to prepare.
+//
+// Example:
+//
+//	rec := rules.NewRecorder()
+//	if err := rules.ValidateWithData(rules.WithRecording(ctx, rec), tree, hooks, "checkout", order); err != nil {
+//	    cassette, _ := json.Marshal(rec.Cassettes()[0])
+//	    log.Printf("validation failed, cassette: %s", cassette)
+//	}
+func WithRecording(ctx context.Context, rec *Recorder) context.Context {
+	return context.WithValue(ctx, recorderKey{}, rec)
+}
+
+// recorderFromContext returns the Recorder attached to ctx, or nil.
+func recorderFromContext(ctx context.Context) *Recorder {
+	rec, _ := ctx.Value(recorderKey{}).(*Recorder)
+	return rec
+}
+
+// replayKey is the context key for the cassettes to replay.
+type replayKey struct{}
+
+// WithReplay returns a context that makes the engine replay cassettes
+// instead of calling Prepare: target i of an evaluation replays the cassette
+// whose Index is i. The registry is not replayed: decode the payload and
+// restore the slots with DecodePayload and RestoreSlots, or use Replay. Steps with recorded data get that data, steps with a
+// recorded error fail with it, and the remaining steps (no prepare data, or
+// Unrecorded) are prepared live.
+//
+// The tree must be the one that was recorded: a cassette whose fingerprint
+// differs from the tree's fails with ErrCassetteMismatch. Prepared data is
+// decoded into the data type of built-in typed rules and conditions; for
+// custom rules using PutPrepared it decodes as with json.Unmarshal into an
+// any. Values read through Dependency.Get are not replayed.
+//
+// Example:
+//
+//	var order Order
+//	if err := cassette.DecodePayload(&order); err != nil {
+//	    return err
+//	}
+//	err := rules.ValidateWithData(rules.WithReplay(ctx, cassette), tree, hooks, "checkout", order)
+func WithReplay(ctx context.Context, cassettes ...Cassette) context.Context {
+	return context.WithValue(ctx, replayKey{}, cassettes)
+}
+
+// replayFromContext returns the cassettes attached to ctx.
+func replayFromContext(ctx context.Context) ([]Cassette, bool) {
+	cassettes, ok := ctx.Value(replayKey{}).([]Cassette)
+	return cassettes, ok
+}
+
+// Replay decodes the payload of cassette into a T, restores its slots, and
+// re-runs the validation of tree on them with WithReplay. The cassette may be
+// that of any target of a multi-target run; it is replayed as a single
+// target.
+//
+// Example:
+//
+//	var cassette rules.Cassette
+//	_ = json.Unmarshal(recorded, &cassette)
+//	err := rules.Replay[Order](ctx, tree, rules.ProcessingHooks{}, "checkout", cassette)
+func Replay[T any](ctx context.Context, tree Evaluable, hooks ProcessingHooks, name string, cassette Cassette) error {
+	var data T
+	if err := cassette.DecodePayload(&data); err != nil {
+		return err
+	}
+	reg := NewDataRegistry(data)
+	cassette.RestoreSlots(reg)
+	cassette.Index = 0
+	return Validate(WithRegistry(WithReplay(ctx, cassette), reg), tree, hooks, name)
+}
+
+// preparedTyper is implemented by the built-in rules and conditions that
+// record typed data, so replay can decode it into the right type.
+type preparedTyper interface {
+	preparedType() reflect.Type
+}
+
+// wrapper is implemented by the rules and conditions that wrap another one
+// and let it record its prepared data under itself.
+type wrapper interface {
+	unwrap() any
+}
+
+// unwrapStep returns the rule or condition that records the prepared data of
+// step.
+func unwrapStep(step any) any {
+	for {
+		w, ok := step.(wrapper)
+		if !ok {
+			return step
+		}
+		step = w.unwrap()
+	}
+}
+
+// preparer is a rule or condition.
+type preparer interface {
+	Prepare(ctx context.Context) (any, error)
+}
+
+// stepSessionKey is the context key for the step session of a target.
+type stepSessionKey struct{}
+
+// stepSession records the Prepare errors of one target for a cassette, and
+// holds the data and errors replayed for it. Both are keyed by the step that
+// records its prepared data (see unwrapStep).
+type stepSession struct {
+	recorded map[any]error
+	replay   bool
+	data     map[any]any
+	errs     map[any]error
+}
+
+// stepSessionFromContext returns the step session attached to ctx, or nil.
+func stepSessionFromContext(ctx context.Context) *stepSession {
+	session, _ := ctx.Value(stepSessionKey{}).(*stepSession)
+	return session
+}
+
+// prepareStep runs step.Prepare, recording its error or replaying its
+// recorded result when the target has a step session. The engine and the
+// built-in composites prepare their steps through it.
+func prepareStep(ctx context.Context, step preparer) (any, error) {
+	session := stepSessionFromContext(ctx)
+	if session == nil {
+		return step.Prepare(ctx)
+	}
+	owner := unwrapStep(step)
+	if !reflect.TypeOf(owner).Comparable() {
+		return step.Prepare(ctx)
+	}
+
+	if session.replay {
+		if data, ok := session.data[owner]; ok {
+			recordPrepared(ctx, owner, data)
+			return data, nil
+		}
+		if err, ok := session.errs[owner]; ok {
+			return nil, err
+		}
+	}
+
+	data, err := step.Prepare(ctx)
+	if err != nil && session.recorded != nil {
+		session.recorded[owner] = err
+	}
+	return data, err
+}
+
+// newStepSession returns the step session of a target, replaying cassette
+// when it is not nil.
+func newStepSession(tree Evaluable, record bool, cassette *Cassette) (*stepSession, error) {
+	session := &stepSession{}
+	if record {
+		session.recorded = make(map[any]error)
+	}
+	if cassette == nil {
+		return session, nil
+	}
+
+	session.replay = true
+	session.data = make(map[any]any)
+	session.errs = make(map[any]error)
+	for _, step := range cassetteSteps(tree) {
+		if raw, ok := cassette.Prepared[step.id]; ok {
+			data, err := decodePrepared(step.owner, raw)
+			if err != nil {
+				return nil, fmt.Errorf("%w: step %s: %v", ErrCassetteMismatch, step.id, err)
+			}
+			session.data[step.owner] = data
+		}
+		if re, ok := cassette.Errors[step.id]; ok {
+			session.errs[step.owner] = re.err()
+		}
+	}
+	return session, nil
+}
+
+// cassetteFor returns the cassette of target index, checking that it was
+// recorded from the tree with the given fingerprint.
+func cassetteFor(cassettes []Cassette, index int, fingerprint string) (*Cassette, error) {
+	for i := range cassettes {
+		c := &cassettes[i]
+		if c.Index != index {
+			continue
+		}
+		switch {
+		case c.Version != CassetteVersion:
+			return nil, fmt.Errorf("%w: target %d: version %d, want %d", ErrCassetteMismatch, index, c.Version, CassetteVersion)
+		case c.Fingerprint != fingerprint:
+			return nil, fmt.Errorf("%w: target %d: recorded from tree %.12s, evaluating %.12s", ErrCassetteMismatch, index, c.Fingerprint, fingerprint)
+		}
+		return c, nil
+	}
+	return nil, fmt.Errorf("%w: no cassette for target %d", ErrCassetteMismatch, index)
+}
+
+// decodePrepared decodes the recorded data of owner into the type it
+// prepares.
+func decodePrepared(owner any, raw json.RawMessage) (any, error) {
+	typer, ok := owner.(preparedTyper)
+	if !ok {
+		var data any
+		err := json.Unmarshal(raw, &data)
+		return data, err
+	}
+	ptr := reflect.New(typer.preparedType())
+	if err := json.Unmarshal(raw, ptr.Interface()); err != nil {
+		return nil, err
+	}
+	return ptr.Elem().Interface(), nil
+}
+
+// cassette builds the cassette of a target evaluated with ctx.
+func (s *stepSession) cassette(ctx context.Context, tree Evaluable, name string, index int, fingerprint string) Cassette {
+	c := Cassette{
+		Version:     CassetteVersion,
+		Name:        name,
+		Index:       index,
+		Fingerprint: fingerprint,
+		Prepared:    make(map[string]json.RawMessage),
+		Errors:      make(map[string]RecordedError),
+	}
+	if reg := registryFromContext(ctx); reg != nil {
+		// A lazy payload is recorded only if a rule read it: recording must
+		// not compute it.
+		if data := reg.data; recordableSlot(&data) && data != nil {
+			if payload, err := json.Marshal(data); err == nil {
+				c.Payload = payload
+			} else {
+				c.Unrecorded = append(c.Unrecorded, "$payload")
+			}
+		}
+		for name, data := range reg.slots {
+			if !recordableSlot(&data) {
+				continue
+			}
+			raw, err := json.Marshal(data)
+			if err != nil {
+				c.Unrecorded = append(c.Unrecorded, "$slots."+name)
+				continue
+			}
+			if c.Slots == nil {
+				c.Slots = make(map[string]json.RawMessage)
+			}
+			c.Slots[name] = raw
+		}
+		slices.Sort(c.Unrecorded)
+	}
+
+	store := preparedStoreFromContext(ctx)
+	for _, step := range cassetteSteps(tree) {
+		if err, ok := s.recorded[step.owner]; ok {
+			c.Errors[step.id] = recordError(err)
+			continue
+		}
+		data, ok := store.get(step.owner)
+		if !ok {
+			continue
+		}
+		raw, err := json.Marshal(data)
+		if err != nil {
+			c.Unrecorded = append(c.Unrecorded, step.id)
+			continue
+		}
+		c.Prepared[step.id] = raw
+	}
+	return c
+}
+
+// recordableSlot reports whether the payload or a slot value can be recorded,
+// replacing a computed value by its result and a restored slot by its JSON.
+// Computed values that were not read, or failed, are left out.
+func recordableSlot(data *any) bool {
+	switch v := (*data).(type) {
+	case recordedSlot:
+		*data = json.RawMessage(v)
+	case *lazyValue:
+		if !v.done.Load() || v.err != nil {
+			return false
+		}
+		*data = v.value
+	}
+	return true
+}
+
+// cassetteStep is a rule or condition of a tree with its stable ID.
+type cassetteStep struct {
+	id    string
+	owner any // the step that records the prepared data
+}
+
+// cassetteSteps lists the rules and conditions of tree, in tree order, with
+// their stable IDs. Custom Evaluable implementations are opaque: their steps
+// are always prepared live.
+func cassetteSteps(tree Evaluable) []cassetteStep {
+	var w stepWalker
+	w.evaluable("$", tree)
+	return w.steps
+}
+
+// stepWalker assigns step IDs by walking a tree.
+type stepWalker struct {
+	steps []cassetteStep
+}
+
+func (w *stepWalker) add(id string, step any) any {
+	owner := unwrapStep(step)
+	if reflect.TypeOf(owner).Comparable() {
+		w.steps = append(w.steps, cassetteStep{id: id, owner: owner})
+	}
+	return owner
+}
+
+func (w *stepWalker) evaluable(path string, e Evaluable) {
+	switch n := e.(type) {
+	case *VersionedTree:
+		w.evaluable(path, n.Tree)
+	case *LeafNode:
+		w.rules(path, n.Rules)
+	case *ConditionNode:
+		w.condition(path+"/if", n.Condition)
+		w.evaluables(path+".", n.Evaluables)
+	case *AllOfNode:
+		w.evaluables(path+".", n.Children)
+	case *AnyOfNode:
+		w.evaluables(path+".", n.Children)
+	case *ConditionEither:
+		w.condition(path+"/if", n.Condition)
+		w.evaluables(path+".l", n.Left)
+		w.evaluables(path+".r", n.Right)
+	case *ScorecardNode:
+		w.evaluables(path+".", n.Children)
+	}
+}
+
+func (w *stepWalker) evaluables(prefix string, list []Evaluable) {
+	for i, e := range list {
+		w.evaluable(prefix+strconv.Itoa(i), e)
+	}
+}
+
+func (w *stepWalker) condition(path string, c Condition) {
+	if c == nil {
+		return
+	}
+	id := path + ":" + c.Name()
+	if not, ok := w.add(id, c).(*NotCondition); ok {
+		w.condition(id+"/if", not.condition)
+	}
+}
+
+func (w *stepWalker) rules(path string, list []Rule) {
+	for i, r := range list {
+		if r == nil {
+			continue
+		}
+		id := fmt.Sprintf("%s/rules[%d]:%s", path, i, r.Name())
+		switch composite := w.add(id, r).(type) {
+		case *ChainRules:
+			w.rules(id, composite.Rules)
+		case *OrRules:
+			w.rules(id, composite.Rules)
+		}
+	}
+}
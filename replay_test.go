@@ -0,0 +1,283 @@
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"testing"
+)
+
+type replayAccount struct {
+	Tier    string `json:"tier"`
+	Balance int    `json:"balance"`
+}
+
+// replayTree builds a tree whose prepare steps call live, counting the calls.
+// The bureau fails for users named "error".
+func replayTree(live *atomic.Int64) Evaluable {
+	return Node(
+		NewTypedConditionWithPrepare("isMember",
+			func(ctx context.Context, u testUser) (replayAccount, error) {
+				live.Add(1)
+				return replayAccount{Tier: "gold", Balance: u.Age * 10}, nil
+			},
+			func(ctx context.Context, u testUser, a replayAccount) bool { return a.Tier != "" }),
+		Rules(
+			NewTypedRuleWithPrepare("funded",
+				func(ctx context.Context, u testUser) (replayAccount, error) {
+					live.Add(1)
+					return replayAccount{Balance: u.Age * 10}, nil
+				},
+				func(ctx context.Context, u testUser, a replayAccount) error {
+					if a.Balance < 200 {
+						return Error{Field: "balance", Err: "insufficient balance", Code: "NO_FUNDS"}
+					}
+					return nil
+				}),
+			NewChainRules(
+				NewTypedRuleWithPrepare("score",
+					func(ctx context.Context, u testUser) (int, error) {
+						live.Add(1)
+						if u.Name == "error" {
+							return 0, Error{Field: "score", Err: "bureau unavailable", Code: "BUREAU_DOWN"}
+						}
+						return 700, nil
+					},
+					func(ctx context.Context, u testUser, score int) error { return nil }),
+			),
+		),
+	)
+}
+
+func errorCodes(err error) []string {
+	var codes []string
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var re Error
+		if errors.As(e, &re) {
+			codes = append(codes, re.Code)
+		}
+	}
+	slices.Sort(codes)
+	return codes
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	t.Parallel()
+
+	var recordCalls atomic.Int64
+	rec := NewRecorder()
+	ctx := WithRecording(context.Background(), rec)
+	err := ValidateWithData(ctx, replayTree(&recordCalls), ProcessingHooks{}, "checkout", testUser{Name: "error", Age: 12})
+	want := errorCodes(err)
+	if !slices.Equal(want, []string{"BUREAU_DOWN", "NO_FUNDS"}) {
+		t.Fatalf("recorded run codes = %v", want)
+	}
+
+	cassettes := rec.Cassettes()
+	if len(cassettes) != 1 {
+		t.Fatalf("got %d cassettes, want 1", len(cassettes))
+	}
+	encoded, err := json.Marshal(cassettes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cassette Cassette
+	if err := json.Unmarshal(encoded, &cassette); err != nil {
+		t.Fatal(err)
+	}
+
+	wantSteps := []string{"$/if:isMember", "$.0/rules[0]:funded"}
+	for _, id := range wantSteps {
+		if _, ok := cassette.Prepared[id]; !ok {
+			t.Errorf("missing prepared step %q in %v", id, cassette.Prepared)
+		}
+	}
+	if e, ok := cassette.Errors["$.0/rules[1]:chainRules/rules[0]:score"]; !ok || e.Code != "BUREAU_DOWN" {
+		t.Errorf("errors = %v", cassette.Errors)
+	}
+
+	// A fresh tree instance: the steps are matched by ID, not by identity.
+	var replayCalls atomic.Int64
+	err = Replay[testUser](context.Background(), replayTree(&replayCalls), ProcessingHooks{}, "checkout", cassette)
+	if got := errorCodes(err); !slices.Equal(got, want) {
+		t.Errorf("replayed codes = %v, want %v", got, want)
+	}
+	if replayCalls.Load() != 0 {
+		t.Errorf("replay made %d live prepare calls", replayCalls.Load())
+	}
+}
+
+func TestReplay_Multi(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int64
+	tree := replayTree(&calls)
+	rec := NewRecorder()
+	users := []testUser{{Name: "a", Age: 30}, {Name: "b", Age: 12}}
+	targets := []TreeAndData{{Tree: tree, Data: users[0]}, {Tree: tree, Data: users[1]}}
+	if err := ValidateMultiWithData(WithRecording(context.Background(), rec), targets, ProcessingHooks{}, "batch"); err == nil {
+		t.Fatal("expected the second target to fail")
+	}
+
+	// Replay with live data that would make both targets pass.
+	cassettes := rec.Cassettes()
+	swapped := []TreeAndData{{Tree: tree, Data: testUser{Age: 99}}, {Tree: tree, Data: testUser{Age: 99}}}
+	calls.Store(0)
+	reports, err := EvaluateMetricsMultiWithData(WithReplay(context.Background(), cassettes...), swapped, ProcessingHooks{}, "batch")
+	if err == nil || !reports[0].Valid || reports[1].Valid {
+		t.Errorf("replay should reproduce the recorded prepared data, got %v", err)
+	}
+	if calls.Load() != 0 {
+		t.Errorf("replay made %d live prepare calls", calls.Load())
+	}
+}
+
+var (
+	replayPrincipal = NewKey[replayAccount]("principal")
+	replayLimit     = NewKey[int]("limit")
+)
+
+func TestReplay_TargetOfBatchWithSlots(t *testing.T) {
+	t.Parallel()
+
+	tree := Rules(NewTypedRule("withinLimit", func(ctx context.Context, u testUser) error {
+		principal, ok := GetKey(ctx, replayPrincipal)
+		if !ok || principal.Tier != "admin" {
+			return Error{Field: "principal", Err: "not an admin", Code: "FORBIDDEN"}
+		}
+		if limit, ok := GetKey(ctx, replayLimit); !ok || u.Age > limit {
+			return Error{Field: "age", Err: "over the limit", Code: "OVER_LIMIT"}
+		}
+		return nil
+	}))
+	target := func(u testUser, tier string, limit int) Target {
+		reg := NewDataRegistry(u)
+		Set(reg, replayPrincipal, replayAccount{Tier: tier})
+		SetComputed(reg, replayLimit, func(context.Context) (int, error) { return limit, nil })
+		return *NewTarget(WithRegistry(context.Background(), reg), tree)
+	}
+
+	rec := NewRecorder()
+	targets := []Target{target(testUser{Age: 30}, "admin", 50), target(testUser{Age: 40}, "admin", 18)}
+	if err := ValidateMulti(WithRecording(context.Background(), rec), targets, ProcessingHooks{}, "batch"); err == nil {
+		t.Fatal("expected the second target to fail")
+	}
+
+	var second Cassette
+	for _, c := range rec.Cassettes() {
+		if c.Index == 1 {
+			second = c
+		}
+	}
+	if string(second.Slots["principal"]) != `{"tier":"admin","balance":0}` || string(second.Slots["limit"]) != "18" {
+		t.Fatalf("recorded slots = %s", second.Slots)
+	}
+
+	encoded, _ := json.Marshal(second)
+	var cassette Cassette
+	if err := json.Unmarshal(encoded, &cassette); err != nil {
+		t.Fatal(err)
+	}
+	err := Replay[testUser](context.Background(), tree, ProcessingHooks{}, "batch", cassette)
+	if re := (Error{}); !errors.As(err, &re) || re.Code != "OVER_LIMIT" {
+		t.Errorf("replayed error = %v, want OVER_LIMIT", err)
+	}
+}
+
+func TestRecord_LazyPayload(t *testing.T) {
+	t.Parallel()
+
+	unread := Rules(NewMetricRulePure("checked", KindCounter, "checked", func() (Outcome, error) {
+		return CounterValue(1), nil
+	}))
+	read := Rules(NewTypedRule("adult", func(ctx context.Context, u testUser) error { return nil }))
+
+	tests := []struct {
+		name        string
+		tree        Evaluable
+		wantCalls   int64
+		wantPayload bool
+	}{
+		{name: "unread", tree: unread, wantCalls: 0, wantPayload: false},
+		{name: "read", tree: read, wantCalls: 1, wantPayload: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var calls atomic.Int64
+			reg := NewLazyDataRegistry(func(ctx context.Context) (any, error) {
+				calls.Add(1)
+				return testUser{Name: "ada", Age: 30}, nil
+			})
+			rec := NewRecorder()
+			ctx := WithRegistry(WithRecording(context.Background(), rec), reg)
+			if err := Validate(ctx, tt.tree, ProcessingHooks{}, "lazy"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if calls.Load() != tt.wantCalls {
+				t.Errorf("provider called %d times, want %d", calls.Load(), tt.wantCalls)
+			}
+			if got := rec.Cassettes()[0].Payload != nil; got != tt.wantPayload {
+				t.Errorf("payload recorded = %v, want %v", got, tt.wantPayload)
+			}
+		})
+	}
+}
+
+func TestReplay_Mismatch(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int64
+	rec := NewRecorder()
+	_ = ValidateWithData(WithRecording(context.Background(), rec), replayTree(&calls), ProcessingHooks{}, "checkout", testUser{Age: 30})
+	cassette := rec.Cassettes()[0]
+
+	other := Rules(NewRulePure("noop", func() error { return nil }))
+	if err := Replay[testUser](context.Background(), other, ProcessingHooks{}, "checkout", cassette); !errors.Is(err, ErrCassetteMismatch) {
+		t.Errorf("different tree: error = %v, want ErrCassetteMismatch", err)
+	}
+
+	cassette.Version++
+	if err := Replay[testUser](context.Background(), replayTree(&calls), ProcessingHooks{}, "checkout", cassette); !errors.Is(err, ErrCassetteMismatch) {
+		t.Errorf("other version: error = %v, want ErrCassetteMismatch", err)
+	}
+
+	ctx := WithReplay(context.Background())
+	if err := ValidateWithData(ctx, replayTree(&calls), ProcessingHooks{}, "checkout", testUser{}); !errors.Is(err, ErrCassetteMismatch) {
+		t.Errorf("no cassette: error = %v, want ErrCassetteMismatch", err)
+	}
+}
+
+func TestReplay_UnrecordedStepsRunLive(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int64
+	names := NewLoader(func(ctx context.Context, keys []string) (map[string]int, error) {
+		calls.Add(1)
+		return map[string]int{"a": 1}, nil
+	})
+	tree := Rules(NewTypedRuleWithPrepare("known",
+		func(ctx context.Context, u testUser) (Thunk[int], error) { return names.Load(ctx, u.Name), nil },
+		func(ctx context.Context, u testUser, v Thunk[int]) error { _, err := v(); return err },
+	))
+
+	rec := NewRecorder()
+	if err := ValidateWithData(WithRecording(context.Background(), rec), tree, ProcessingHooks{}, "names", testUser{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	cassette := rec.Cassettes()[0]
+	if !slices.Equal(cassette.Unrecorded, []string{"$/rules[0]:known"}) {
+		t.Fatalf("unrecorded = %v", cassette.Unrecorded)
+	}
+
+	if err := Replay[testUser](context.Background(), tree, ProcessingHooks{}, "names", cassette); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("loader calls = %d, want the unrecorded step prepared live", calls.Load())
+	}
+}
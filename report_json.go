@@ -0,0 +1,455 @@
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// ReportFormatVersion is the version of the JSON encoding of a Report.
+// Decoding rejects reports of another version with ErrReportFormat.
+const ReportFormatVersion = 1
+
+// ErrReportFormat is returned when a JSON report cannot be decoded: its
+// version is not ReportFormatVersion, or it names an unknown metric kind or
+// aggregation.
+var ErrReportFormat = errors.New("rules: unsupported report format")
+
+// MarshalJSON encodes the report in the versioned JSON format, so reports can
+// be shipped between services and stored for audit:
+//
+//	{
+//	  "version": 1,
+//	  "valid": false,
+//	  "errors": [{"message": "too young", "field": "age", "code": "MIN", "rule": true}],
+//	  "metrics": {
+//	    "latency{region=\"eu\"}": {
+//	      "kind": "histogram", "name": "latency", "labels": {"region": "eu"},
+//	      "histogram": {"buckets": [10, 100, "+Inf"], "counts": [1, 3, 3], "total": 3, "sum": 152}
+//	    }
+//	  },
+//	  "tree_version": {"name": "checkout", "version": 3},
+//	  "fingerprint": "9f2c..."
+//	}
+//
+// Errors of type Error keep their field and code ("rule" marks them), joined
+// errors keep each joined error ("joined") and wrapping errors keep the
+// error they wrap ("wrapped"); any other error keeps its message. Kinds and
+// aggregations are encoded by name, and the infinite and NaN values JSON
+// numbers cannot hold as the strings "+Inf", "-Inf" and "NaN". Outcomes use
+// the same encoding on their own, e.g. in a BatchReport.
+func (r Report) MarshalJSON() ([]byte, error) {
+	wire := reportJSON{
+		Version:     ReportFormatVersion,
+		Valid:       r.Valid,
+		Metrics:     r.Metrics,
+		Fingerprint: r.Fingerprint,
+	}
+	for _, err := range r.Errors {
+		wire.Errors = append(wire.Errors, encodeError(err))
+	}
+	if r.TreeVersion != (TreeVersion{}) {
+		wire.TreeVersion = &treeVersionJSON{Name: r.TreeVersion.Name, Version: r.TreeVersion.Version}
+	}
+	return json.Marshal(wire)
+}
+
+// UnmarshalJSON decodes a report encoded by MarshalJSON. Decoded errors
+// match the originals under errors.Is and errors.As for the Error type and
+// have the same messages; other error types and sentinel identities are not
+// restored.
+//
+// Example:
+//
+//	var report rules.Report
+//	if err := json.Unmarshal(body, &report); err != nil {
+//	    return err
+//	}
+//	for _, err := range report.Errors {
+//	    var re rules.Error
+//	    if errors.As(err, &re) {
+//	        fmt.Println(re.Field, re.Code)
+//	    }
+//	}
+func (r *Report) UnmarshalJSON(data []byte) error {
+	var wire reportJSON
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+	if wire.Version != ReportFormatVersion {
+		return fmt.Errorf("%w: version %d, want %d", ErrReportFormat, wire.Version, ReportFormatVersion)
+	}
+
+	*r = Report{Valid: wire.Valid, Metrics: wire.Metrics, Fingerprint: wire.Fingerprint}
+	for _, e := range wire.Errors {
+		r.Errors = append(r.Errors, e.err())
+	}
+	if wire.TreeVersion != nil {
+		r.TreeVersion = TreeVersion{Name: wire.TreeVersion.Name, Version: wire.TreeVersion.Version}
+	}
+	return nil
+}
+
+// MarshalJSON encodes the outcome as in the metrics of an encoded Report.
+// Value fields that do not apply to the outcome's kind are omitted when
+// zero.
+func (o Outcome) MarshalJSON() ([]byte, error) {
+	wire := outcomeJSON{
+		Kind:   o.Kind.String(),
+		Name:   o.Name,
+		Field:  o.Field,
+		Valid:  o.Valid,
+		Count:  jsonFloat(o.Count),
+		Score:  jsonFloat(o.Score),
+		Weight: jsonFloat(o.Weight),
+		Value:  jsonFloat(o.Value),
+		Labels: o.Labels,
+	}
+	if o.Aggregation != AggNone {
+		wire.Aggregation = o.Aggregation.String()
+	}
+	if o.Err != nil {
+		e := encodeError(o.Err)
+		wire.Err = &e
+	}
+	if h := o.Histogram; len(h.Buckets) > 0 || len(h.Counts) > 0 || h.Total > 0 || h.Sum != 0 {
+		wire.Histogram = &histogramJSON{
+			Buckets: jsonFloats(h.Buckets),
+			Counts:  h.Counts,
+			Total:   h.Total,
+			Sum:     jsonFloat(h.Sum),
+		}
+	}
+	if e := o.Exponential; e.Scale != 0 || e.MaxBuckets != 0 || e.Total > 0 || e.ZeroCount > 0 {
+		wire.Exponential = &exponentialJSON{
+			Scale:      e.Scale,
+			MaxBuckets: e.MaxBuckets,
+			ZeroCount:  e.ZeroCount,
+			Positive:   exponentialBucketsJSON(e.Positive),
+			Negative:   exponentialBucketsJSON(e.Negative),
+			Total:      e.Total,
+			Sum:        jsonFloat(e.Sum),
+			Min:        jsonFloat(e.Min),
+			Max:        jsonFloat(e.Max),
+		}
+	}
+	if !o.Time.IsZero() {
+		wire.Time = &o.Time
+	}
+	if d := o.Distinct; d.Precision != 0 || len(d.Registers) > 0 {
+		wire.Distinct = &distinctJSON{Precision: d.Precision, Registers: d.Registers}
+	}
+	if s := o.Summary; s.Compression != 0 || len(s.Centroids) > 0 || s.Count != 0 {
+		wire.Summary = &summaryJSON{
+			Compression: jsonFloat(s.Compression),
+			Count:       jsonFloat(s.Count),
+			Sum:         jsonFloat(s.Sum),
+			Min:         jsonFloat(s.Min),
+			Max:         jsonFloat(s.Max),
+		}
+		for _, c := range s.Centroids {
+			wire.Summary.Centroids = append(wire.Summary.Centroids, centroidJSON{Mean: jsonFloat(c.Mean), Weight: jsonFloat(c.Weight)})
+		}
+	}
+	return json.Marshal(wire)
+}
+
+// UnmarshalJSON decodes an outcome encoded by MarshalJSON.
+func (o *Outcome) UnmarshalJSON(data []byte) error {
+	var wire outcomeJSON
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+	kind, ok := parseKind(wire.Kind)
+	if !ok {
+		return fmt.Errorf("%w: unknown kind %q", ErrReportFormat, wire.Kind)
+	}
+	agg, ok := parseAggregation(wire.Aggregation)
+	if !ok {
+		return fmt.Errorf("%w: unknown aggregation %q", ErrReportFormat, wire.Aggregation)
+	}
+
+	*o = Outcome{
+		Kind:        kind,
+		Name:        wire.Name,
+		Field:       wire.Field,
+		Valid:       wire.Valid,
+		Count:       float64(wire.Count),
+		Score:       float64(wire.Score),
+		Weight:      float64(wire.Weight),
+		Value:       float64(wire.Value),
+		Aggregation: agg,
+		Labels:      wire.Labels,
+	}
+	if wire.Err != nil {
+		o.Err = wire.Err.err()
+	}
+	if h := wire.Histogram; h != nil {
+		o.Histogram = Histogram{Buckets: float64s(h.Buckets), Counts: h.Counts, Total: h.Total, Sum: float64(h.Sum)}
+	}
+	if e := wire.Exponential; e != nil {
+		o.Exponential = ExponentialHistogram{
+			Scale:      e.Scale,
+			MaxBuckets: e.MaxBuckets,
+			ZeroCount:  e.ZeroCount,
+			Positive:   ExponentialBucketCounts(e.Positive),
+			Negative:   ExponentialBucketCounts(e.Negative),
+			Total:      e.Total,
+			Sum:        float64(e.Sum),
+			Min:        float64(e.Min),
+			Max:        float64(e.Max),
+		}
+	}
+	if wire.Time != nil {
+		o.Time = *wire.Time
+	}
+	if d := wire.Distinct; d != nil {
+		o.Distinct = HyperLogLog{Precision: d.Precision, Registers: d.Registers}
+	}
+	if s := wire.Summary; s != nil {
+		o.Summary = Summary{
+			Compression: float64(s.Compression),
+			Count:       float64(s.Count),
+			Sum:         float64(s.Sum),
+			Min:         float64(s.Min),
+			Max:         float64(s.Max),
+		}
+		for _, c := range s.Centroids {
+			o.Summary.Centroids = append(o.Summary.Centroids, Centroid{Mean: float64(c.Mean), Weight: float64(c.Weight)})
+		}
+	}
+	return nil
+}
+
+// reportJSON is the wire format of a Report.
+type reportJSON struct {
+	Version     int                `json:"version"`
+	Valid       bool               `json:"valid"`
+	Errors      []errorJSON        `json:"errors,omitempty"`
+	Metrics     map[string]Outcome `json:"metrics,omitempty"`
+	TreeVersion *treeVersionJSON   `json:"tree_version,omitempty"`
+	Fingerprint string             `json:"fingerprint,omitempty"`
+}
+
+type treeVersionJSON struct {
+	Name    string `json:"name"`
+	Version uint64 `json:"version"`
+}
+
+// outcomeJSON is the wire format of an Outcome.
+type outcomeJSON struct {
+	Kind        string            `json:"kind"`
+	Name        string            `json:"name,omitempty"`
+	Field       string            `json:"field,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Aggregation string            `json:"aggregation,omitempty"`
+	Valid       bool              `json:"valid,omitempty"`
+	Err         *errorJSON        `json:"error,omitempty"`
+	Count       jsonFloat         `json:"count,omitempty"`
+	Score       jsonFloat         `json:"score,omitempty"`
+	Weight      jsonFloat         `json:"weight,omitempty"`
+	Value       jsonFloat         `json:"value,omitempty"`
+	Time        *time.Time        `json:"time,omitempty"`
+	Histogram   *histogramJSON    `json:"histogram,omitempty"`
+	Exponential *exponentialJSON  `json:"exponential,omitempty"`
+	Distinct    *distinctJSON     `json:"distinct,omitempty"`
+	Summary     *summaryJSON      `json:"summary,omitempty"`
+}
+
+type histogramJSON struct {
+	Buckets []jsonFloat `json:"buckets"`
+	Counts  []uint64    `json:"counts"`
+	Total   uint64      `json:"total"`
+	Sum     jsonFloat   `json:"sum"`
+}
+
+type exponentialJSON struct {
+	Scale      int                    `json:"scale"`
+	MaxBuckets int                    `json:"max_buckets"`
+	ZeroCount  uint64                 `json:"zero_count,omitempty"`
+	Positive   exponentialBucketsJSON `json:"positive"`
+	Negative   exponentialBucketsJSON `json:"negative"`
+	Total      uint64                 `json:"total"`
+	Sum        jsonFloat              `json:"sum"`
+	Min        jsonFloat              `json:"min"`
+	Max        jsonFloat              `json:"max"`
+}
+
+type exponentialBucketsJSON struct {
+	Offset int      `json:"offset"`
+	Counts []uint64 `json:"counts"`
+}
+
+// distinctJSON holds a HyperLogLog; Registers encode as base64.
+type distinctJSON struct {
+	Precision uint8  `json:"precision"`
+	Registers []byte `json:"registers"`
+}
+
+type summaryJSON struct {
+	Compression jsonFloat      `json:"compression"`
+	Centroids   []centroidJSON `json:"centroids,omitempty"`
+	Count       jsonFloat      `json:"count"`
+	Sum         jsonFloat      `json:"sum"`
+	Min         jsonFloat      `json:"min"`
+	Max         jsonFloat      `json:"max"`
+}
+
+type centroidJSON struct {
+	Mean   jsonFloat `json:"mean"`
+	Weight jsonFloat `json:"weight"`
+}
+
+// errorJSON is the wire format of an error. Rule marks an Error, whose
+// message is its Err; Joined holds the errors of an error wrapping several
+// (errors.Join) and Wrapped the error wrapped by any other wrapping error.
+type errorJSON struct {
+	Message string      `json:"message"`
+	Field   string      `json:"field,omitempty"`
+	Code    string      `json:"code,omitempty"`
+	Rule    bool        `json:"rule,omitempty"`
+	Joined  []errorJSON `json:"joined,omitempty"`
+	Wrapped *errorJSON  `json:"wrapped,omitempty"`
+}
+
+// encodeError converts err to its wire format.
+func encodeError(err error) errorJSON {
+	switch e := err.(type) {
+	case nil:
+		return errorJSON{}
+	case Error:
+		return errorJSON{Message: e.Err, Field: e.Field, Code: e.Code, Rule: true}
+	case *Error:
+		return errorJSON{Message: e.Err, Field: e.Field, Code: e.Code, Rule: true}
+	case interface{ Unwrap() []error }:
+		res := errorJSON{Message: err.Error()}
+		for _, joined := range e.Unwrap() {
+			res.Joined = append(res.Joined, encodeError(joined))
+		}
+		return res
+	}
+	res := errorJSON{Message: err.Error()}
+	if wrapped := errors.Unwrap(err); wrapped != nil {
+		w := encodeError(wrapped)
+		res.Wrapped = &w
+	}
+	return res
+}
+
+// err rebuilds the encoded error.
+func (e errorJSON) err() error {
+	switch {
+	case e.Rule:
+		return Error{Field: e.Field, Err: e.Message, Code: e.Code}
+	case len(e.Joined) > 0:
+		errs := make([]error, len(e.Joined))
+		for i, joined := range e.Joined {
+			errs[i] = joined.err()
+		}
+		return &decodedJoinError{message: e.Message, errs: errs}
+	case e.Wrapped != nil:
+		return &decodedWrapError{message: e.Message, err: e.Wrapped.err()}
+	}
+	return errors.New(e.Message)
+}
+
+// decodedWrapError is a decoded wrapping error: it has the original message
+// and wraps the decoded error the original wrapped.
+type decodedWrapError struct {
+	message string
+	err     error
+}
+
+func (e *decodedWrapError) Error() string { return e.message }
+
+func (e *decodedWrapError) Unwrap() error { return e.err }
+
+// decodedJoinError is a decoded error wrapping several, like the errors.Join
+// error it was encoded from.
+type decodedJoinError struct {
+	message string
+	errs    []error
+}
+
+func (e *decodedJoinError) Error() string { return e.message }
+
+func (e *decodedJoinError) Unwrap() []error { return e.errs }
+
+// jsonFloat is a float64 that encodes the values JSON numbers cannot hold as
+// the strings "+Inf", "-Inf" and "NaN".
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	switch {
+	case math.IsNaN(v):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(v, 1):
+		return []byte(`"+Inf"`), nil
+	case math.IsInf(v, -1):
+		return []byte(`"-Inf"`), nil
+	}
+	return strconv.AppendFloat(nil, v, 'g', -1, 64), nil
+}
+
+func (f *jsonFloat) UnmarshalJSON(data []byte) error {
+	if !bytes.HasPrefix(data, []byte(`"`)) {
+		var v float64
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		*f = jsonFloat(v)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	switch s {
+	case "NaN":
+		*f = jsonFloat(math.NaN())
+	case "+Inf":
+		*f = jsonFloat(math.Inf(1))
+	case "-Inf":
+		*f = jsonFloat(math.Inf(-1))
+	default:
+		return fmt.Errorf("%w: invalid number %q", ErrReportFormat, s)
+	}
+	return nil
+}
+
+func jsonFloats(vs []float64) []jsonFloat {
+	if vs == nil {
+		return nil
+	}
+	res := make([]jsonFloat, len(vs))
+	for i, v := range vs {
+		res[i] = jsonFloat(v)
+	}
+	return res
+}
+
+func float64s(vs []jsonFloat) []float64 {
+	if vs == nil {
+		return nil
+	}
+	res := make([]float64, len(vs))
+	for i, v := range vs {
+		res[i] = float64(v)
+	}
+	return res
+}
+
+// parseKind returns the kind named s.
+func parseKind(s string) (Kind, bool) {
+	for k := KindValid; k <= KindSummary; k++ {
+		if k.String() == s {
+			return k, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,157 @@
+package rules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReport_JSONRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	latency := NewHistogram([]float64{10, 100, math.Inf(1)})
+	for _, v := range []float64{4, 40, 400} {
+		latency.Observe(v)
+	}
+	exponential := NewExponentialHistogram(0)
+	for _, v := range []float64{-2, 0, 0.5, 12} {
+		exponential.Observe(v)
+	}
+	merchants := NewHyperLogLog(4)
+	merchants.Add("m-1")
+	merchants.Add("m-2")
+	amounts := NewSummary(0)
+	for i := range 50 {
+		amounts.Observe(float64(i))
+	}
+
+	tooYoung := Error{Field: "age", Err: "too young", Code: "MIN"}
+	joined := errors.Join(tooYoung, errors.New("timeout"))
+	wrapped := fmt.Errorf("loading user: %w", Error{Field: "user", Err: "not found", Code: "NOT_FOUND"})
+
+	named := func(name string, o Outcome) Outcome {
+		o.Name = name
+		return o
+	}
+	labeled := named("latency", HistogramValue(latency))
+	labeled.Labels = map[string]string{"region": "eu"}
+	risk := named("risk", ScoreValue(0.25, 3))
+	risk.Aggregation = AggMax
+	metrics := []Outcome{
+		labeled,
+		risk,
+		named("adult", ValidValue(false, joined)),
+		{Kind: KindCounter, Field: "revenue", Count: 1250.5},
+		named("nan", ScoreValue(math.NaN(), 0)),
+		named("sizes", ExponentialHistogramValue(exponential)),
+		named("balance", GaugeValue(-3.5, time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC))),
+		named("merchants", DistinctValue(merchants)),
+		named("amounts", SummaryValue(amounts)),
+	}
+	report := Report{
+		Valid:       false,
+		Errors:      []error{tooYoung, joined, wrapped},
+		Metrics:     make(map[string]Outcome),
+		TreeVersion: TreeVersion{Name: "checkout", Version: 3},
+		Fingerprint: "9f2c",
+	}
+	for _, o := range metrics {
+		report.Metrics[SeriesKey(o.MetricName(), o.Labels)] = o
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	// Errors are compared by message and structure, everything else deeply.
+	if len(decoded.Errors) != len(report.Errors) {
+		t.Fatalf("decoded %d errors, want %d", len(decoded.Errors), len(report.Errors))
+	}
+	for i, err := range decoded.Errors {
+		if err.Error() != report.Errors[i].Error() {
+			t.Errorf("error %d = %q, want %q", i, err, report.Errors[i])
+		}
+	}
+	if got, ok := decoded.Errors[0].(Error); !ok || got != tooYoung {
+		t.Errorf("decoded Error = %#v, want %#v", decoded.Errors[0], tooYoung)
+	}
+	var re Error
+	if !errors.As(decoded.Errors[1], &re) || re != tooYoung {
+		t.Errorf("joined error does not contain %#v", tooYoung)
+	}
+	if !errors.As(decoded.Errors[2], &re) || re.Code != "NOT_FOUND" {
+		t.Errorf("wrapped error does not contain the NOT_FOUND Error, got %#v", re)
+	}
+	adult := decoded.Metrics["adult"]
+	if adult.Err == nil || adult.Err.Error() != joined.Error() || !errors.As(adult.Err, &re) {
+		t.Errorf("outcome error = %v, want %v", adult.Err, joined)
+	}
+	if !math.IsNaN(decoded.Metrics["nan"].Score) {
+		t.Errorf("NaN score decoded as %v", decoded.Metrics["nan"].Score)
+	}
+
+	decoded.Errors, report.Errors = nil, nil
+	for _, m := range []map[string]Outcome{decoded.Metrics, report.Metrics} {
+		m["adult"] = Outcome{Kind: KindValid, Name: "adult"}
+		m["nan"] = Outcome{Kind: KindScore, Name: "nan"}
+	}
+	if !reflect.DeepEqual(decoded, report) {
+		t.Errorf("decoded report differs:\n got %+v\nwant %+v", decoded, report)
+	}
+
+	// The encoding is stable: a decoded report encodes to the same JSON.
+	var again Report
+	_ = json.Unmarshal(data, &again)
+	if reencoded, _ := json.Marshal(again); string(reencoded) != string(data) {
+		t.Errorf("re-encoded report differs:\n got %s\nwant %s", reencoded, data)
+	}
+}
+
+func TestReport_UnmarshalJSONErrors(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		testName string
+		data     string
+		want     string
+	}{
+		{testName: "missing version", data: `{"valid": true}`, want: "version 0"},
+		{testName: "future version", data: `{"version": 2, "valid": true}`, want: "version 2"},
+		{
+			testName: "unknown kind",
+			data:     `{"version": 1, "metrics": {"x": {"kind": "ratio"}}}`,
+			want:     `unknown kind "ratio"`,
+		},
+		{
+			testName: "unknown aggregation",
+			data:     `{"version": 1, "metrics": {"x": {"kind": "counter", "aggregation": "geometric_mean"}}}`,
+			want:     `unknown aggregation "geometric_mean"`,
+		},
+		{
+			testName: "invalid number",
+			data:     `{"version": 1, "metrics": {"x": {"kind": "counter", "count": "many"}}}`,
+			want:     `invalid number "many"`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			t.Parallel()
+
+			var report Report
+			err := json.Unmarshal([]byte(tc.data), &report)
+			if !errors.Is(err, ErrReportFormat) || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("error = %v, want ErrReportFormat mentioning %q", err, tc.want)
+			}
+		})
+	}
+}
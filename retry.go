@@ -0,0 +1,299 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"reflect"
+	"time"
+)
+
+// RetriesMetric is the name of the counter the engine emits, once a target
+// is validated, for prepare steps that needed more than one attempt. Each
+// retried step adds one series labelled with the step name, e.g.
+// prepare_retries{step="creditOK"}, whose Count is its number of retries.
+// The counters are emitted like rule outcomes: they reach the OutcomeSink,
+// if any, and the report of EvaluateMetrics.
+const RetriesMetric = "prepare_retries"
+
+// Retryable is implemented by errors that know whether the failed operation
+// may succeed when attempted again.
+type Retryable interface {
+	Retryable() bool
+}
+
+// retryableError marks a wrapped error as retryable.
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string   { return e.err.Error() }
+func (e retryableError) Unwrap() error   { return e.err }
+func (e retryableError) Retryable() bool { return true }
+
+// RetryableError marks err as retryable for the default classification of
+// RetryPolicy. It returns nil when err is nil.
+//
+// Example:
+//
+//	if resp.StatusCode == http.StatusServiceUnavailable {
+//	    return Credit{}, rules.RetryableError(fmt.Errorf("bureau: %s", resp.Status))
+//	}
+func RetryableError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return retryableError{err: err}
+}
+
+// IsRetryable reports whether err, or any error it wraps, implements
+// Retryable and returns true. Context cancellation and deadline errors are
+// never retryable.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var r Retryable
+	return errors.As(err, &r) && r.Retryable()
+}
+
+// RetryPolicy configures how a failing prepare step is retried. The zero
+// value makes a single attempt.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	// Values below 2 disable retrying.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between attempts. Zero means no cap.
+	MaxBackoff time.Duration
+	// Multiplier grows the delay after each retry. Zero means 2.
+	Multiplier float64
+	// Jitter randomly shortens each delay by up to this fraction, in [0, 1],
+	// so that callers failing together do not retry together.
+	Jitter float64
+	// Retryable classifies errors. Nil means IsRetryable.
+	Retryable func(error) bool
+}
+
+// RetryEvent describes a failed attempt that is about to be retried.
+type RetryEvent struct {
+	// Name is the name of the retried rule, condition or operation.
+	Name string
+	// Attempt is the 1-based number of the attempt that failed.
+	Attempt int
+	// Err is the error of the failed attempt.
+	Err error
+	// Delay is the time waited before the next attempt.
+	Delay time.Duration
+}
+
+// retryable classifies err under the policy.
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+// delay returns the wait before retrying after the given failed attempt.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+	d := float64(p.InitialBackoff)
+	for range attempt - 1 {
+		d *= multiplier
+		if p.MaxBackoff > 0 && d >= float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d -= d * min(p.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// Retry calls fn until it succeeds, returns an error the policy does not
+// classify as retryable, or MaxAttempts is reached, and returns the last
+// result. It waits between attempts as configured by the policy and stops
+// early when ctx is done or its deadline would pass before the next attempt.
+//
+// Inside the engine every retry is reported to the ProcessingHooks.OnRetry
+// hook and counted in the RetriesMetric of the report. Retry is what
+// RetryRule and RetryCondition use; call it directly to retry the resolve
+// function of a Dependency, whose errors are memoised per target.
+//
+// Example:
+//
+//	account := rules.NewDependency("account", func(ctx context.Context, u User) (Account, error) {
+//	    return rules.Retry(ctx, policy, "account", func(ctx context.Context) (Account, error) {
+//	        return db.Account(ctx, u.AccountID)
+//	    })
+//	})
+func Retry[T any](ctx context.Context, policy RetryPolicy, name string, fn func(ctx context.Context) (T, error)) (T, error) {
+	for attempt := 1; ; attempt++ {
+		value, err := fn(ctx)
+		if err == nil || attempt >= policy.MaxAttempts || !policy.retryable(err) {
+			return value, err
+		}
+
+		delay := policy.delay(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return value, err
+		}
+		retryLogFromContext(ctx).record(ctx, RetryEvent{Name: name, Attempt: attempt, Err: err, Delay: delay})
+		if !sleep(ctx, delay) {
+			return value, err
+		}
+	}
+}
+
+// sleep waits for d or until ctx is done, and reports whether the full
+// delay elapsed.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// retryLog counts the retries of one target's evaluation and forwards them
+// to the OnRetry hook. The engine prepares a target sequentially, so it needs
+// no locking.
+type retryLog struct {
+	onRetry func(ctx context.Context, event RetryEvent)
+	counts  map[string]int
+	order   []string
+}
+
+// retryLogFromContext returns the retry log of the target evaluated with
+// ctx, creating it on the first retry, or nil outside the engine.
+func retryLogFromContext(ctx context.Context) *retryLog {
+	state := targetStateFromContext(ctx)
+	if state == nil || state.run == nil {
+		return nil
+	}
+	if state.retries == nil {
+		state.retries = &retryLog{onRetry: state.run.onRetry}
+	}
+	return state.retries
+}
+
+// record counts a retry and reports it to the hook. It is a no-op on a nil
+// log.
+func (l *retryLog) record(ctx context.Context, event RetryEvent) {
+	if l == nil {
+		return
+	}
+	if l.counts == nil {
+		l.counts = make(map[string]int)
+	}
+	if _, seen := l.counts[event.Name]; !seen {
+		l.order = append(l.order, event.Name)
+	}
+	l.counts[event.Name]++
+	if l.onRetry != nil {
+		l.onRetry(ctx, event)
+	}
+}
+
+// outcomes returns one RetriesMetric counter per retried step, labelled with
+// the step name, in the order the steps were first retried.
+func (l *retryLog) outcomes() []Outcome {
+	if l == nil {
+		return nil
+	}
+	outcomes := make([]Outcome, 0, len(l.order))
+	for _, name := range l.order {
+		outcomes = append(outcomes, Outcome{
+			Kind:   KindCounter,
+			Name:   RetriesMetric,
+			Labels: map[string]string{"step": name},
+			Count:  float64(l.counts[name]),
+		})
+	}
+	return outcomes
+}
+
+// retryRule wraps a rule so its Prepare is retried.
+type retryRule struct {
+	Rule
+	policy RetryPolicy
+}
+
+var _ Rule = (*retryRule)(nil)
+
+// unwrap returns the wrapped rule.
+func (r *retryRule) unwrap() any { return r.Rule }
+
+// Params returns the type and parameters of the wrapped rule, and the policy.
+func (r *retryRule) Params() []any {
+	return []any{reflect.TypeOf(r.Rule), paramsOf(r.Rule), r.policy}
+}
+
+// Prepare retries the wrapped rule's Prepare under the policy.
+func (r *retryRule) Prepare(ctx context.Context) (any, error) {
+	return Retry(ctx, r.policy, r.Name(), r.Rule.Prepare)
+}
+
+// RetryRule wraps rule so its Prepare is retried under policy. Validate is
+// not retried: it should only read what Prepare fetched.
+//
+// Example:
+//
+//	policy := rules.RetryPolicy{MaxAttempts: 3, InitialBackoff: 50 * time.Millisecond, Jitter: 0.2}
+//
+//	rule := rules.RetryRule(policy, rules.NewTypedRuleWithPrepare("creditOK",
+//	    func(ctx context.Context, u User) (Credit, error) { return bureau.Credit(ctx, u.ID) },
+//	    func(ctx context.Context, u User, c Credit) error { return checkCredit(c) },
+//	))
+func RetryRule(policy RetryPolicy, rule Rule) Rule {
+	return &retryRule{Rule: rule, policy: policy}
+}
+
+// retryCondition wraps a condition so its Prepare is retried.
+type retryCondition struct {
+	Condition
+	policy RetryPolicy
+}
+
+var _ Condition = (*retryCondition)(nil)
+
+// unwrap returns the wrapped condition.
+func (c *retryCondition) unwrap() any { return c.Condition }
+
+// Params returns the type and parameters of the wrapped condition, and the
+// policy.
+func (c *retryCondition) Params() []any {
+	return []any{reflect.TypeOf(c.Condition), paramsOf(c.Condition), c.policy}
+}
+
+// Prepare retries the wrapped condition's Prepare under the policy.
+func (c *retryCondition) Prepare(ctx context.Context) (any, error) {
+	return Retry(ctx, c.policy, c.Name(), c.Condition.Prepare)
+}
+
+// RetryCondition wraps condition so its Prepare is retried under policy. It
+// behaves like RetryRule.
+//
+// Example:
+//
+//	isVIP := rules.RetryCondition(policy, rules.NewTypedConditionWithPrepare("isVIP",
+//	    func(ctx context.Context, u User) (Tier, error) { return crm.Tier(ctx, u.ID) },
+//	    func(ctx context.Context, u User, tier Tier) bool { return tier == TierVIP },
+//	))
+func RetryCondition(policy RetryPolicy, condition Condition) Condition {
+	return &retryCondition{Condition: condition, policy: policy}
+}
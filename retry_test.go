@@ -0,0 +1,221 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyRule returns a rule whose prepare step fails with err for the first
+// failures calls.
+func flakyRule(policy RetryPolicy, failures int64, err error, calls *atomic.Int64) Rule {
+	return RetryRule(policy, NewTypedRuleWithPrepare("creditOK",
+		func(ctx context.Context, u testUser) (int, error) {
+			if calls.Add(1) <= failures {
+				return 0, err
+			}
+			return u.Age * 10, nil
+		},
+		func(ctx context.Context, u testUser, credit int) error {
+			if credit < 200 {
+				return Error{Field: "credit", Err: "credit too low", Code: "LOW_CREDIT"}
+			}
+			return nil
+		},
+	))
+}
+
+func TestRetryRule(t *testing.T) {
+	t.Parallel()
+
+	transient := RetryableError(errors.New("bureau timeout"))
+	permanent := errors.New("unknown user")
+
+	testCases := []struct {
+		testName  string
+		policy    RetryPolicy
+		failures  int64
+		err       error
+		wantErr   error
+		wantCalls int64
+	}{
+		{testName: "succeeds after retries", policy: RetryPolicy{MaxAttempts: 3}, failures: 2, err: transient, wantCalls: 3},
+		{testName: "gives up after max attempts", policy: RetryPolicy{MaxAttempts: 3}, failures: 5, err: transient, wantErr: transient, wantCalls: 3},
+		{testName: "permanent error not retried", policy: RetryPolicy{MaxAttempts: 3}, failures: 5, err: permanent, wantErr: permanent, wantCalls: 1},
+		{testName: "zero policy makes one attempt", failures: 1, err: transient, wantErr: transient, wantCalls: 1},
+		{
+			testName:  "custom classification",
+			policy:    RetryPolicy{MaxAttempts: 2, Retryable: func(err error) bool { return errors.Is(err, permanent) }},
+			failures:  1,
+			err:       permanent,
+			wantCalls: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			t.Parallel()
+
+			var calls atomic.Int64
+			tree := Rules(flakyRule(tc.policy, tc.failures, tc.err, &calls))
+			err := ValidateWithData(context.Background(), tree, ProcessingHooks{}, "credit", testUser{Age: 30})
+			if tc.wantErr == nil && err != nil || tc.wantErr != nil && !errors.Is(err, tc.wantErr) {
+				t.Errorf("error = %v, want %v", err, tc.wantErr)
+			}
+			if calls.Load() != tc.wantCalls {
+				t.Errorf("calls = %d, want %d", calls.Load(), tc.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetry_HooksAndMetrics(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int64
+	policy := RetryPolicy{MaxAttempts: 4, InitialBackoff: time.Millisecond}
+	condition := RetryCondition(policy, NewTypedConditionWithPrepare("isVIP",
+		func(ctx context.Context, u testUser) (bool, error) {
+			if calls.Add(1) == 1 {
+				return false, RetryableError(errors.New("crm unavailable"))
+			}
+			return true, nil
+		},
+		func(ctx context.Context, u testUser, vip bool) bool { return vip },
+	))
+	var ruleCalls atomic.Int64
+	tree := Node(condition, Rules(flakyRule(policy, 2, RetryableError(errors.New("timeout")), &ruleCalls)))
+
+	var events []RetryEvent
+	hooks := ProcessingHooks{OnRetry: func(ctx context.Context, event RetryEvent) {
+		events = append(events, event)
+	}}
+	report, err := EvaluateMetricsWithData(context.Background(), tree, hooks, "vip", testUser{Age: 30})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("got %d retry events, want 3: %+v", len(events), events)
+	}
+	if events[0].Name != "isVIP" || events[1].Name != "creditOK" || events[2].Attempt != 2 {
+		t.Errorf("events = %+v", events)
+	}
+	if events[1].Delay != time.Millisecond || events[2].Delay != 2*time.Millisecond {
+		t.Errorf("delays = %v, %v, want exponential backoff", events[1].Delay, events[2].Delay)
+	}
+	for step, want := range map[string]float64{"isVIP": 1, "creditOK": 2} {
+		key := SeriesKey(RetriesMetric, map[string]string{"step": step})
+		if got := report.Metrics[key]; got.Count != want || got.Labels["step"] != step {
+			t.Errorf("%s = %+v, want count %v", key, got, want)
+		}
+	}
+}
+
+func TestRetry_MetricsReachSink(t *testing.T) {
+	t.Parallel()
+
+	var ruleCalls atomic.Int64
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	tree := Rules(flakyRule(policy, 1, RetryableError(errors.New("timeout")), &ruleCalls))
+
+	sink := &sinkRecorder{}
+	ctx := WithOutcomeSink(context.Background(), sink)
+	if err := ValidateWithData(ctx, tree, ProcessingHooks{}, "vip", testUser{Age: 30}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(sink.outcomes) != 1 {
+		t.Fatalf("sink got %d outcomes, want 1: %+v", len(sink.outcomes), sink.outcomes)
+	}
+	if o := sink.outcomes[0]; o.Name != RetriesMetric || o.Labels["step"] != "creditOK" || o.Count != 1 || sink.trees[0] != "vip" {
+		t.Errorf("sink got %+v for tree %q", o, sink.trees[0])
+	}
+}
+
+func TestRetry_RespectsContext(t *testing.T) {
+	t.Parallel()
+
+	failing := func(calls *atomic.Int64) func(ctx context.Context) (int, error) {
+		return func(ctx context.Context) (int, error) {
+			calls.Add(1)
+			return 0, RetryableError(errors.New("unavailable"))
+		}
+	}
+	policy := RetryPolicy{MaxAttempts: 10, InitialBackoff: time.Hour}
+
+	t.Run("deadline before next attempt", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		var calls atomic.Int64
+		start := time.Now()
+		if _, err := Retry(ctx, policy, "op", failing(&calls)); err == nil {
+			t.Fatal("expected the last error")
+		}
+		if calls.Load() != 1 || time.Since(start) > time.Second {
+			t.Errorf("calls = %d after %v, want one attempt and no wait", calls.Load(), time.Since(start))
+		}
+	})
+
+	t.Run("canceled while waiting", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		var calls atomic.Int64
+		if _, err := Retry(ctx, policy, "op", failing(&calls)); err == nil {
+			t.Fatal("expected the last error")
+		}
+		if calls.Load() != 1 {
+			t.Errorf("calls = %d, want 1", calls.Load())
+		}
+	})
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	t.Parallel()
+
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 3}
+	want := []time.Duration{100 * time.Millisecond, 300 * time.Millisecond, 900 * time.Millisecond, time.Second, time.Second}
+	for i, w := range want {
+		if got := policy.delay(i + 1); got != w {
+			t.Errorf("delay(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for range 100 {
+		if got := policy.delay(1); got < 50*time.Millisecond || got > 100*time.Millisecond {
+			t.Fatalf("jittered delay %v outside [50ms, 100ms]", got)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	t.Parallel()
+
+	base := errors.New("boom")
+	testCases := []struct {
+		testName string
+		err      error
+		want     bool
+	}{
+		{testName: "nil", err: nil, want: false},
+		{testName: "plain", err: base, want: false},
+		{testName: "marked", err: RetryableError(base), want: true},
+		{testName: "wrapped mark", err: errors.Join(errors.New("ctx"), RetryableError(base)), want: true},
+		{testName: "canceled", err: RetryableError(context.Canceled), want: false},
+	}
+	for _, tc := range testCases {
+		if got := IsRetryable(tc.err); got != tc.want {
+			t.Errorf("%s: IsRetryable = %v, want %v", tc.testName, got, tc.want)
+		}
+	}
+	if RetryableError(nil) != nil {
+		t.Error("RetryableError(nil) should be nil")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"reflect"
 )
 
 // Error contains a structured definition for validation errors, including
@@ -167,7 +168,7 @@ func (n *ConditionNode) PrepareConditions(ctx context.Context) error {
 		return nil
 	}
 
-	if _, err := n.Condition.Prepare(ctx); err != nil {
+	if _, err := prepareStep(ctx, n.Condition); err != nil {
 		return err
 	}
 
@@ -376,7 +377,7 @@ func (n *NotCondition) Prepare(ctx context.Context) (any, error) {
 	if n.condition == nil {
 		return nil, nil
 	}
-	return n.condition.Prepare(ctx)
+	return prepareStep(ctx, n.condition)
 }
 
 func (n *NotCondition) IsValid(ctx context.Context) bool {
@@ -453,7 +454,7 @@ func (n *ConditionEither) PrepareConditions(ctx context.Context) error {
 	// Impure: prepare the condition, then fan out Prepare across BOTH
 	// branches so the dataloader can batch all fetches together. The typed
 	// condition self-records its prepared data, so the store is populated here.
-	if _, err := n.Condition.Prepare(ctx); err != nil {
+	if _, err := prepareStep(ctx, n.Condition); err != nil {
 		return err
 	}
 
@@ -575,7 +576,7 @@ func (c *ChainRules) Name() string {
 // its own data (typed rules self-record into the preparedStore).
 func (c *ChainRules) Prepare(ctx context.Context) (any, error) {
 	for _, rule := range c.Rules {
-		if _, err := rule.Prepare(ctx); err != nil {
+		if _, err := prepareStep(ctx, rule); err != nil {
 			return nil, err
 		}
 	}
@@ -673,7 +674,7 @@ func (o *OrRules) Name() string {
 // Prepare() returns an error, it stops and returns that error immediately.
 func (o *OrRules) Prepare(ctx context.Context) (any, error) {
 	for _, rule := range o.Rules {
-		if _, err := rule.Prepare(ctx); err != nil {
+		if _, err := prepareStep(ctx, rule); err != nil {
 			return nil, err
 		}
 	}
@@ -757,6 +758,9 @@ type ConditionSideEffect[T any] struct {
 
 var _ Condition = (*ConditionSideEffect[any])(nil) // Ensure ConditionSideEffect implements the Condition interface.
 
+// preparedType returns T, the type of the data recorded by Prepare.
+func (c *ConditionSideEffect[T]) preparedType() reflect.Type { return reflect.TypeFor[T]() }
+
 // Prepare runs the side-effecting prepare function, records the retrieved data
 // in the per-evaluation preparedStore keyed by this condition, and returns it
 // (as any) to satisfy the Rule interface. The data is read back typed in
@@ -831,14 +835,19 @@ func NewConditionSideEffect[T any](
 }
 
 // TypedRulePure is a pure rule that reads its input data of type T from the
-// data registry at validation time. Prepare is a no-op. Used by NewTypedRule.
+// data registry at validation time. Prepare is a no-op. Used by NewTypedRule
+// and NewTypedRuleWithKey.
 type TypedRulePure[T any] struct {
 	RuleBase
 	name string
+	key  string // registry slot; empty for the default payload
 	fn   func(ctx context.Context, data T) error
 }
 
-var _ Rule = (*TypedRulePure[any])(nil)
+var (
+	_ Rule          = (*TypedRulePure[any])(nil)
+	_ Parameterized = (*TypedRulePure[any])(nil)
+)
 
 // Name returns the rule name.
 func (r *TypedRulePure[T]) Name() string { return r.name }
@@ -846,19 +855,22 @@ func (r *TypedRulePure[T]) Name() string { return r.name }
 // Prepare implements Rule interface. It's a no-op for pure rules.
 func (r *TypedRulePure[T]) Prepare(context.Context) (any, error) { return nil, nil }
 
-// Validate reads the typed data from the context registry via GetAs[T] and
-// calls the wrapped function. Returns a TYPE_MISMATCH error when the registered
-// data is not of type T.
+// Params returns the registry slot the rule reads, or nil for the default
+// payload.
+func (r *TypedRulePure[T]) Params() []any {
+	if r.key == "" {
+		return nil
+	}
+	return []any{r.key}
+}
+
+// Validate reads the typed data from the context registry via GetKey and
+// calls the wrapped function. Returns a TYPE_MISMATCH error when the slot is
+// empty or its data is not of type T.
 func (r *TypedRulePure[T]) Validate(ctx context.Context) error {
-	data, ok := GetAs[T](ctx)
+	data, ok := GetKey(ctx, Key[T]{name: r.key})
 	if !ok {
-		var zero T
-		raw, _ := Get(ctx)
-		return Error{
-			Field: r.name,
-			Err:   fmt.Sprintf("expected data of type %T, got %T", zero, raw),
-			Code:  ErrorCodeTypeMismatch,
-		}
+		return r.mismatch(ctx)
 	}
 	if r.fn == nil {
 		return Error{
@@ -870,6 +882,26 @@ func (r *TypedRulePure[T]) Validate(ctx context.Context) error {
 	return r.fn(ctx, data)
 }
 
+// mismatch builds the TYPE_MISMATCH error for a slot that is empty or holds
+// data of another type.
+func (r *TypedRulePure[T]) mismatch(ctx context.Context) error {
+	var zero T
+	var raw any
+	found := false
+	if reg := registryFromContext(ctx); reg != nil {
+		raw, found = reg.slot(ctx, r.key)
+	}
+	msg := fmt.Sprintf("expected data of type %T, got %T", zero, raw)
+	if r.key != "" && !found {
+		msg = fmt.Sprintf("expected data of type %T in slot %q, slot is empty", zero, r.key)
+	}
+	return Error{
+		Field: r.name,
+		Err:   msg,
+		Code:  ErrorCodeTypeMismatch,
+	}
+}
+
 // NewTypedRule creates a type-safe rule that reads data of type T from the
 // data registry at validation time. Returns a TYPE_MISMATCH error if the
 // registered data is not of type T.
@@ -890,6 +922,24 @@ func NewTypedRule[T any](name string, fn func(ctx context.Context, data T) error
 	return &TypedRulePure[T]{name: name, fn: fn}
 }
 
+// NewTypedRuleWithKey is like NewTypedRule but reads its data from the
+// registry slot identified by key instead of the default payload. Returns a
+// TYPE_MISMATCH error if the slot is empty or holds data of another type.
+//
+// Example:
+//
+//	var Principal = rules.NewKey[Account]("principal")
+//
+//	rule := rules.NewTypedRuleWithKey("isActive", Principal, func(ctx context.Context, a Account) error {
+//	    if !a.Active {
+//	        return rules.Error{Field: "principal", Err: "account is disabled", Code: "ACCOUNT_DISABLED"}
+//	    }
+//	    return nil
+//	})
+func NewTypedRuleWithKey[T any](name string, key Key[T], fn func(ctx context.Context, data T) error) Rule {
+	return &TypedRulePure[T]{name: name, key: key.name, fn: fn}
+}
+
 // NewTypedCondition creates a type-safe condition that reads data of type T
 // from the data registry and returns false when the registered data is not of
 // type T. It is intended for pure conditions (no side effects).
@@ -900,19 +950,36 @@ func NewTypedRule[T any](name string, fn func(ctx context.Context, data T) error
 //	    return user.Age >= 18
 //	})
 func NewTypedCondition[T any](name string, fn func(ctx context.Context, data T) bool) Condition {
+	return NewTypedConditionWithKey(name, Key[T]{}, fn)
+}
+
+// NewTypedConditionWithKey is like NewTypedCondition but reads its data from
+// the registry slot identified by key. It returns false when the slot is
+// empty or holds data of another type.
+//
+// Example:
+//
+//	isEnterprise := rules.NewTypedConditionWithKey("isEnterprise", Tenant,
+//	    func(ctx context.Context, t TenantConfig) bool { return t.Plan == "enterprise" })
+func NewTypedConditionWithKey[T any](name string, key Key[T], fn func(ctx context.Context, data T) bool) Condition {
+	var params []any
+	if key.name != "" {
+		params = []any{key.name}
+	}
 	return &ConditionFunc{
 		name: name,
 		predicate: func(ctx context.Context) bool {
 			if fn == nil {
 				return false
 			}
-			data, ok := GetAs[T](ctx)
+			data, ok := GetKey(ctx, key)
 			if !ok {
 				return false
 			}
 			return fn(ctx, data)
 		},
-		pure: true,
+		pure:   true,
+		params: params,
 	}
 }
 
@@ -931,6 +998,9 @@ type TypedRuleDataFunc[In any, T any] struct {
 
 var _ Rule = (*TypedRuleDataFunc[any, any])(nil)
 
+// preparedType returns T, the type of the data recorded by Prepare.
+func (r *TypedRuleDataFunc[In, T]) preparedType() reflect.Type { return reflect.TypeFor[T]() }
+
 // Name returns the rule name.
 func (r *TypedRuleDataFunc[In, T]) Name() string {
 	return r.name
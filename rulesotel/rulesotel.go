@@ -0,0 +1,377 @@
+// Package rulesotel forwards the outcomes emitted by rules to OpenTelemetry
+// metric instruments. It lives in its own module so the rules module keeps no
+// dependencies.
+//
+// A Sink implements rules.OutcomeSink. Every outcome emitted through
+// rules.Emit or the metric rules is recorded on an instrument named after the
+// outcome (its Name, or its Field when Name is empty) as soon as it is
+// emitted, before any report aggregation. Kinds map as follows:
+//
+//   - KindCounter: a Float64Counter, adding Count.
+//   - KindScore: a Float64Gauge, recording Score.
+//   - KindGauge: a Float64Gauge, recording Value.
+//   - KindDistinct: a Float64Gauge, recording the estimated distinct count of
+//     the outcome's sketch.
+//   - KindSummary: a Float64Gauge, recording the p50, p90, p95 and p99 of the
+//     outcome's summary under a rules.quantile attribute.
+//   - KindValid: a Float64Counter, adding 1 with a rules.valid attribute.
+//   - KindHistogram: a cumulative histogram with the outcome's explicit
+//     bucket boundaries, or an exponential histogram for outcomes carrying a
+//     rules.ExponentialHistogram. Histograms are already aggregated, and the
+//     OpenTelemetry API can only record observations one by one, so the Sink
+//     merges them itself and exposes them as a producer of the SDK: register
+//     it on the reader with sdkmetric.WithProducer. Bucket counts, count and
+//     sum are exact, and recording costs one addition per bucket.
+//
+// Attributes come from Outcome.Labels, plus rules.metric (the metric name),
+// rules.tree (the name passed to the evaluation) and rules.field (when set).
+package rulesotel
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/mishudark/rules"
+)
+
+// ScopeName is the instrumentation scope of the histograms produced by a
+// Sink.
+const ScopeName = "github.com/mishudark/rules/rulesotel"
+
+// Attribute keys set on every measurement.
+const (
+	MetricKey   = attribute.Key("rules.metric")
+	TreeKey     = attribute.Key("rules.tree")
+	FieldKey    = attribute.Key("rules.field")
+	ValidKey    = attribute.Key("rules.valid")
+	QuantileKey = attribute.Key("rules.quantile")
+)
+
+// summaryQuantiles are the quantiles recorded for summaries.
+var summaryQuantiles = []float64{0.5, 0.9, 0.95, 0.99}
+
+// Sink records outcomes on instruments created from a meter, and merges
+// histograms into the metrics it produces for the SDK reader it is
+// registered on. Instruments are created on first use and cached by name; a
+// histogram keeps the layout of the first outcome recorded under its name.
+// It is safe for concurrent use.
+//
+// Example:
+//
+//	sink := rulesotel.NewSink(otel.Meter("checkout"))
+//	reader := sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithProducer(sink))
+//	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+//
+//	ctx := rules.WithOutcomeSink(context.Background(), sink)
+//	err := rules.ValidateWithData(ctx, tree, hooks, "checkout", order)
+type Sink struct {
+	meter  metric.Meter
+	prefix string
+	start  time.Time
+
+	mu         sync.Mutex
+	counters   map[string]metric.Float64Counter
+	gauges     map[string]metric.Float64Gauge
+	histograms map[string]*histogramFamily
+}
+
+var (
+	_ rules.OutcomeSink  = (*Sink)(nil)
+	_ sdkmetric.Producer = (*Sink)(nil)
+)
+
+// histogramFamily is the merged state of the histograms recorded under one
+// name, one point per attribute set.
+type histogramFamily struct {
+	exponential bool
+	bounds      []float64 // finite boundaries, for explicit buckets
+	points      map[attribute.Distinct]*histogramPoint
+}
+
+// histogramPoint is a cumulative histogram data point.
+type histogramPoint struct {
+	attrs attribute.Set
+	// counts holds, for explicit buckets, the count of each bucket (not
+	// cumulative, as in OpenTelemetry), the last one above every boundary.
+	counts []uint64
+	total  uint64
+	sum    float64
+	exp    rules.ExponentialHistogram
+}
+
+// Option configures a Sink.
+type Option func(*Sink)
+
+// WithPrefix prefixes every instrument name, e.g. "rules.".
+func WithPrefix(prefix string) Option {
+	return func(s *Sink) {
+		s.prefix = prefix
+	}
+}
+
+// NewSink creates a Sink recording on instruments of meter. The Sink is
+// created before the reader it produces histograms for, so meter is usually
+// the global one from otel.Meter, which delegates to the provider set later.
+func NewSink(meter metric.Meter, opts ...Option) *Sink {
+	s := &Sink{
+		meter:      meter,
+		start:      time.Now(),
+		counters:   make(map[string]metric.Float64Counter),
+		gauges:     make(map[string]metric.Float64Gauge),
+		histograms: make(map[string]*histogramFamily),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// RecordOutcome records o on the instrument of its kind. Outcomes without a
+// Name or Field have no instrument and are dropped, as they are dropped from
+// reports.
+func (s *Sink) RecordOutcome(ctx context.Context, tree string, o rules.Outcome) {
+	name := o.Name
+	if name == "" {
+		name = o.Field
+	}
+	if name == "" {
+		return
+	}
+
+	attrs := make([]attribute.KeyValue, 0, len(o.Labels)+4)
+	for k, v := range o.Labels {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+	attrs = append(attrs, MetricKey.String(name), TreeKey.String(tree))
+	if o.Field != "" {
+		attrs = append(attrs, FieldKey.String(o.Field))
+	}
+
+	switch o.Kind {
+	case rules.KindCounter:
+		s.counter(name).Add(ctx, o.Count, metric.WithAttributes(attrs...))
+	case rules.KindValid:
+		attrs = append(attrs, ValidKey.Bool(o.Valid))
+		s.counter(name).Add(ctx, 1, metric.WithAttributes(attrs...))
+	case rules.KindHistogram:
+		if err := s.recordHistogram(name, attribute.NewSet(attrs...), o); err != nil {
+			otel.Handle(err)
+		}
+	case rules.KindScore:
+		s.gauge(name).Record(ctx, o.Score, metric.WithAttributes(attrs...))
+	case rules.KindGauge:
+		s.gauge(name).Record(ctx, o.Value, metric.WithAttributes(attrs...))
+	case rules.KindDistinct:
+		s.gauge(name).Record(ctx, float64(o.Distinct.Estimate()), metric.WithAttributes(attrs...))
+	case rules.KindSummary:
+		if o.Summary.Count == 0 {
+			return
+		}
+		gauge := s.gauge(name)
+		for _, q := range summaryQuantiles {
+			gauge.Record(ctx, o.Summary.Quantile(q), metric.WithAttributes(append(attrs, QuantileKey.Float64(q))...))
+		}
+	}
+}
+
+// counter returns the counter named name, creating it if needed.
+func (s *Sink) counter(name string) metric.Float64Counter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.counters[name]
+	if !ok {
+		var err error
+		c, err = s.meter.Float64Counter(s.prefix + name)
+		handle(err)
+		s.counters[name] = c
+	}
+	return c
+}
+
+// gauge returns the gauge named name, creating it if needed.
+func (s *Sink) gauge(name string) metric.Float64Gauge {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.gauges[name]
+	if !ok {
+		var err error
+		g, err = s.meter.Float64Gauge(s.prefix + name)
+		handle(err)
+		s.gauges[name] = g
+	}
+	return g
+}
+
+// recordHistogram merges the histogram of o into the point of its name and
+// attributes. An outcome whose layout differs from the first one recorded
+// under its name is dropped with an error: explicit and exponential buckets
+// do not convert into each other, and explicit buckets only merge with the
+// same boundaries.
+func (s *Sink) recordHistogram(name string, attrs attribute.Set, o rules.Outcome) error {
+	h, exp := o.Histogram, o.Exponential
+	exponential := len(h.Buckets) == 0
+	if exponential && exp.Total == 0 {
+		return nil // an empty histogram
+	}
+	bounds := finiteBounds(h.Buckets)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	family, ok := s.histograms[name]
+	if !ok {
+		family = &histogramFamily{
+			exponential: exponential,
+			bounds:      bounds,
+			points:      make(map[attribute.Distinct]*histogramPoint),
+		}
+		s.histograms[name] = family
+	}
+	switch {
+	case family.exponential != exponential:
+		return fmt.Errorf("rulesotel: histogram %s has %s buckets, got %s buckets",
+			name, layoutName(family.exponential), layoutName(exponential))
+	case !slices.Equal(family.bounds, bounds):
+		return fmt.Errorf("rulesotel: histogram %s has boundaries %v, got %v", name, family.bounds, bounds)
+	}
+
+	point, ok := family.points[attrs.Equivalent()]
+	if !ok {
+		point = &histogramPoint{attrs: attrs}
+		if !exponential {
+			point.counts = make([]uint64, len(bounds)+1)
+		}
+		family.points[attrs.Equivalent()] = point
+	}
+	if exponential {
+		point.exp.Merge(exp)
+		return nil
+	}
+	// rules.Histogram counts are cumulative, and observations above the
+	// largest boundary are only counted in Total.
+	var prev uint64
+	for i := range bounds {
+		if i < len(h.Counts) {
+			point.counts[i] += h.Counts[i] - prev
+			prev = h.Counts[i]
+		}
+	}
+	point.counts[len(bounds)] += h.Total - prev
+	point.total += h.Total
+	point.sum += h.Sum
+	return nil
+}
+
+// finiteBounds returns the finite boundaries of buckets. OpenTelemetry adds
+// the +Inf bucket itself.
+func finiteBounds(buckets []float64) []float64 {
+	bounds := make([]float64, 0, len(buckets))
+	for _, b := range buckets {
+		if !math.IsInf(b, 0) {
+			bounds = append(bounds, b)
+		}
+	}
+	return bounds
+}
+
+// layoutName names a histogram layout in errors.
+func layoutName(exponential bool) string {
+	if exponential {
+		return "exponential"
+	}
+	return "explicit"
+}
+
+// Produce returns the histograms recorded so far, as cumulative data points
+// starting when the Sink was created. It implements sdkmetric.Producer.
+func (s *Sink) Produce(context.Context) ([]metricdata.ScopeMetrics, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.histograms) == 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	metrics := make([]metricdata.Metrics, 0, len(s.histograms))
+	for _, name := range slices.Sorted(maps.Keys(s.histograms)) {
+		family := s.histograms[name]
+		m := metricdata.Metrics{Name: s.prefix + name}
+		if family.exponential {
+			data := metricdata.ExponentialHistogram[float64]{Temporality: metricdata.CumulativeTemporality}
+			for _, p := range family.sortedPoints() {
+				data.DataPoints = append(data.DataPoints, metricdata.ExponentialHistogramDataPoint[float64]{
+					Attributes:     p.attrs,
+					StartTime:      s.start,
+					Time:           now,
+					Count:          p.exp.Total,
+					Min:            metricdata.NewExtrema(p.exp.Min),
+					Max:            metricdata.NewExtrema(p.exp.Max),
+					Sum:            p.exp.Sum,
+					Scale:          int32(p.exp.Scale),
+					ZeroCount:      p.exp.ZeroCount,
+					PositiveBucket: exponentialBucket(p.exp.Positive),
+					NegativeBucket: exponentialBucket(p.exp.Negative),
+				})
+			}
+			m.Data = data
+		} else {
+			data := metricdata.Histogram[float64]{Temporality: metricdata.CumulativeTemporality}
+			for _, p := range family.sortedPoints() {
+				data.DataPoints = append(data.DataPoints, metricdata.HistogramDataPoint[float64]{
+					Attributes:   p.attrs,
+					StartTime:    s.start,
+					Time:         now,
+					Count:        p.total,
+					Bounds:       slices.Clone(family.bounds),
+					BucketCounts: slices.Clone(p.counts),
+					Sum:          p.sum,
+				})
+			}
+			m.Data = data
+		}
+		metrics = append(metrics, m)
+	}
+	return []metricdata.ScopeMetrics{{Scope: instrumentation.Scope{Name: ScopeName}, Metrics: metrics}}, nil
+}
+
+// sortedPoints returns the points of the family sorted by attributes, so
+// produced metrics are stable.
+func (f *histogramFamily) sortedPoints() []*histogramPoint {
+	points := slices.Collect(maps.Values(f.points))
+	slices.SortFunc(points, func(a, b *histogramPoint) int {
+		ea, eb := a.attrs.Encoded(attribute.DefaultEncoder()), b.attrs.Encoded(attribute.DefaultEncoder())
+		switch {
+		case ea < eb:
+			return -1
+		case ea > eb:
+			return 1
+		}
+		return 0
+	})
+	return points
+}
+
+// exponentialBucket converts the buckets of one sign of an exponential
+// histogram.
+func exponentialBucket(b rules.ExponentialBucketCounts) metricdata.ExponentialBucket {
+	return metricdata.ExponentialBucket{Offset: int32(b.Offset), Counts: slices.Clone(b.Counts)}
+}
+
+// handle reports an instrument creation error to the global OpenTelemetry
+// error handler. The instrument returned alongside the error is still usable.
+func handle(err error) {
+	if err != nil {
+		otel.Handle(err)
+	}
+}
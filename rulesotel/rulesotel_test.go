@@ -0,0 +1,272 @@
+package rulesotel
+
+import (
+	"context"
+	"math"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/mishudark/rules"
+)
+
+type order struct {
+	Total   float64
+	Country string
+}
+
+func orderTree() rules.Evaluable {
+	return rules.Rules(
+		rules.NewTypedMetricRule("revenue", rules.KindCounter, "revenue",
+			func(ctx context.Context, o order) (rules.Outcome, error) {
+				out := rules.CounterValue(o.Total)
+				out.Labels = map[string]string{"country": o.Country}
+				return out, nil
+			}),
+		rules.NewTypedMetricRule("risk", rules.KindScore, "",
+			func(ctx context.Context, o order) (rules.Outcome, error) {
+				return rules.ScoreValue(o.Total/100, 1), nil
+			}),
+		rules.NewTypedMetricRule("small", rules.KindValid, "",
+			func(ctx context.Context, o order) (rules.Outcome, error) {
+				return rules.ValidValue(o.Total < 100, nil), nil
+			}),
+		rules.NewTypedMetricRule("total", rules.KindHistogram, "",
+			func(ctx context.Context, o order) (rules.Outcome, error) {
+				h := rules.NewHistogram([]float64{50, 100})
+				h.Observe(o.Total)
+				return rules.HistogramValue(h), nil
+			}),
+	)
+}
+
+// newSink returns a Sink registered as the producer of an in-memory reader,
+// and recording on a meter read by the same reader.
+func newSink(opts ...Option) (*Sink, *sdkmetric.ManualReader) {
+	sink := NewSink(nil, opts...)
+	reader := sdkmetric.NewManualReader(sdkmetric.WithProducer(sink))
+	sink.meter = sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")
+	return sink, reader
+}
+
+// read returns the metrics read by reader, keyed by instrument name.
+func read(t *testing.T, reader sdkmetric.Reader) map[string]metricdata.Metrics {
+	t.Helper()
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	metrics := make(map[string]metricdata.Metrics)
+	for _, scope := range rm.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			metrics[m.Name] = m
+		}
+	}
+	return metrics
+}
+
+// collect evaluates orders through a Sink and returns the metrics read by an
+// in-memory reader, keyed by instrument name.
+func collect(t *testing.T, orders ...order) map[string]metricdata.Metrics {
+	t.Helper()
+
+	sink, reader := newSink(WithPrefix("rules."))
+	ctx := rules.WithOutcomeSink(context.Background(), sink)
+	for _, o := range orders {
+		if err := rules.ValidateWithData(ctx, orderTree(), rules.ProcessingHooks{}, "checkout", o); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	return read(t, reader)
+}
+
+func TestSink(t *testing.T) {
+	t.Parallel()
+
+	metrics := collect(t, order{Total: 40, Country: "US"}, order{Total: 200, Country: "US"}, order{Total: 10, Country: "DE"})
+
+	revenue, ok := metrics["rules.revenue"].Data.(metricdata.Sum[float64])
+	if !ok || !revenue.IsMonotonic {
+		t.Fatalf("revenue = %#v, want a monotonic sum", metrics["rules.revenue"].Data)
+	}
+	byCountry := map[string]float64{}
+	for _, dp := range revenue.DataPoints {
+		country, _ := dp.Attributes.Value("country")
+		tree, _ := dp.Attributes.Value(TreeKey)
+		field, _ := dp.Attributes.Value(FieldKey)
+		name, _ := dp.Attributes.Value(MetricKey)
+		if tree.AsString() != "checkout" || field.AsString() != "revenue" || name.AsString() != "revenue" {
+			t.Errorf("unexpected attributes %v", dp.Attributes.ToSlice())
+		}
+		byCountry[country.AsString()] = dp.Value
+	}
+	if byCountry["US"] != 240 || byCountry["DE"] != 10 {
+		t.Errorf("revenue by country = %v", byCountry)
+	}
+
+	risk, ok := metrics["rules.risk"].Data.(metricdata.Gauge[float64])
+	if !ok || len(risk.DataPoints) != 1 || risk.DataPoints[0].Value != 0.1 {
+		t.Errorf("risk = %#v, want a gauge holding the last score", metrics["rules.risk"].Data)
+	}
+
+	small, ok := metrics["rules.small"].Data.(metricdata.Sum[float64])
+	if !ok {
+		t.Fatalf("small = %#v, want a sum", metrics["rules.small"].Data)
+	}
+	byValid := map[bool]float64{}
+	for _, dp := range small.DataPoints {
+		valid, _ := dp.Attributes.Value(ValidKey)
+		byValid[valid.AsBool()] = dp.Value
+	}
+	if byValid[true] != 2 || byValid[false] != 1 {
+		t.Errorf("small by valid = %v", byValid)
+	}
+
+	total, ok := metrics["rules.total"].Data.(metricdata.Histogram[float64])
+	if !ok || len(total.DataPoints) != 1 {
+		t.Fatalf("total = %#v, want one histogram point", metrics["rules.total"].Data)
+	}
+	dp := total.DataPoints[0]
+	if !slices.Equal(dp.Bounds, []float64{50, 100}) || !slices.Equal(dp.BucketCounts, []uint64{2, 0, 1}) || dp.Count != 3 || dp.Sum != 250 {
+		t.Errorf("total bounds = %v, counts = %v, count = %d, sum = %v", dp.Bounds, dp.BucketCounts, dp.Count, dp.Sum)
+	}
+}
+
+func TestSink_DropsUnnamedOutcomes(t *testing.T) {
+	t.Parallel()
+
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	sink := NewSink(provider.Meter("test"))
+	sink.RecordOutcome(context.Background(), "tree", rules.CounterValue(1))
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	if len(rm.ScopeMetrics) != 0 {
+		t.Errorf("unexpected metrics %v", rm.ScopeMetrics)
+	}
+}
+
+func TestSink_Histograms(t *testing.T) {
+	t.Parallel()
+
+	sink, reader := newSink()
+	ctx := context.Background()
+	record := func(name, country string, h rules.Histogram) {
+		o := rules.HistogramValue(h)
+		o.Name, o.Labels = name, map[string]string{"country": country}
+		sink.RecordOutcome(ctx, "tree", o)
+	}
+
+	// Counts are added per bucket, whatever the number of observations.
+	large := rules.Histogram{
+		Buckets: []float64{1, 10, math.Inf(1)},
+		Counts:  []uint64{400_000, 900_000, 1_000_000},
+		Total:   1_000_000,
+		Sum:     7e6,
+	}
+	record("amount", "US", large)
+	record("amount", "US", large)
+	small := rules.NewHistogram([]float64{1, 10})
+	small.Observe(20)
+	record("amount", "DE", small)
+	// A histogram with other boundaries is dropped.
+	record("amount", "US", rules.NewHistogram([]float64{5}))
+
+	exp := rules.NewExponentialHistogram(0)
+	for _, v := range []float64{-3, -1, 0, 1.5, 3, 3.5} {
+		exp.Observe(v)
+	}
+	latency := rules.ExponentialHistogramValue(exp)
+	latency.Name = "latency"
+	sink.RecordOutcome(ctx, "tree", latency)
+	sink.RecordOutcome(ctx, "tree", latency)
+	// An explicit histogram under an exponential name is dropped.
+	record("latency", "US", small)
+
+	metrics := read(t, reader)
+	amount, ok := metrics["amount"].Data.(metricdata.Histogram[float64])
+	if !ok || len(amount.DataPoints) != 2 {
+		t.Fatalf("amount = %#v, want two histogram points", metrics["amount"].Data)
+	}
+	for _, dp := range amount.DataPoints {
+		country, _ := dp.Attributes.Value("country")
+		want := map[string][]uint64{"US": {800_000, 1_000_000, 200_000}, "DE": {0, 0, 1}}[country.AsString()]
+		if !slices.Equal(dp.Bounds, []float64{1, 10}) || !slices.Equal(dp.BucketCounts, want) {
+			t.Errorf("%s: bounds = %v, counts = %v, want %v", country.AsString(), dp.Bounds, dp.BucketCounts, want)
+		}
+	}
+
+	latencies, ok := metrics["latency"].Data.(metricdata.ExponentialHistogram[float64])
+	if !ok || len(latencies.DataPoints) != 1 {
+		t.Fatalf("latency = %#v, want one exponential histogram point", metrics["latency"].Data)
+	}
+	dp := latencies.DataPoints[0]
+	lo, _ := dp.Min.Value()
+	hi, _ := dp.Max.Value()
+	if dp.Count != 12 || dp.ZeroCount != 2 || dp.Sum != 8 || lo != -3 || hi != 3.5 {
+		t.Errorf("latency count = %d, zero = %d, sum = %v, min = %v, max = %v", dp.Count, dp.ZeroCount, dp.Sum, lo, hi)
+	}
+	var positive, negative uint64
+	for _, c := range dp.PositiveBucket.Counts {
+		positive += c
+	}
+	for _, c := range dp.NegativeBucket.Counts {
+		negative += c
+	}
+	if positive != 6 || negative != 4 || dp.Scale != int32(exp.Scale) {
+		t.Errorf("latency positive = %d, negative = %d, scale = %d", positive, negative, dp.Scale)
+	}
+}
+
+func TestSink_GaugeDistinctSummary(t *testing.T) {
+	t.Parallel()
+
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	sink := NewSink(provider.Meter("test"))
+
+	merchants := rules.NewHyperLogLog(0)
+	amounts := rules.NewSummary(0)
+	for i := range 100 {
+		merchants.Add(strconv.Itoa(i % 10))
+		amounts.Observe(float64(i))
+	}
+	outcomes := []rules.Outcome{
+		rules.GaugeValue(7, time.Now()),
+		rules.DistinctValue(merchants),
+		rules.SummaryValue(amounts),
+	}
+	for i, name := range []string{"depth", "merchants", "amounts"} {
+		outcomes[i].Name = name
+		sink.RecordOutcome(context.Background(), "tree", outcomes[i])
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	values := map[string][]float64{}
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		gauge, ok := m.Data.(metricdata.Gauge[float64])
+		if !ok {
+			t.Fatalf("%s = %#v, want a gauge", m.Name, m.Data)
+		}
+		for _, dp := range gauge.DataPoints {
+			values[m.Name] = append(values[m.Name], dp.Value)
+		}
+	}
+	if !slices.Equal(values["depth"], []float64{7}) || !slices.Equal(values["merchants"], []float64{10}) {
+		t.Errorf("gauges = %v", values)
+	}
+	if len(values["amounts"]) != 4 {
+		t.Errorf("amounts quantiles = %v, want 4", values["amounts"])
+	}
+}
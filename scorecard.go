@@ -0,0 +1,252 @@
+package rules
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrorCodeScoreBelowThreshold is the code of the error a Scorecard returns
+// when its combined score falls below the threshold.
+const ErrorCodeScoreBelowThreshold = "SCORE_BELOW_THRESHOLD"
+
+// ScorecardNode gates validation on the combined score of the rules beneath
+// it: every KindScore outcome emitted by those rules (through Emit or the
+// metric rules) is collected during validation, the scores are combined with
+// Aggregation, and the node fails with a ScorecardError when the combined
+// score is below Threshold. A scorecard whose rules emit no score passes.
+//
+// The children are evaluated like the children of a ConditionNode whose
+// condition holds: the rules of every child that evaluates successfully are
+// run. Scores still reach the report under EvaluateMetrics; the scorecard
+// only reads them. Scorecards nest: an inner scorecard's scores also count
+// towards the outer one.
+type ScorecardNode struct {
+	Name      string
+	Threshold float64
+	// Aggregation combines the scores, as for same-name score outcomes.
+	// AggNone uses AggWeightedAvg.
+	Aggregation Aggregation
+	Children    []Evaluable
+}
+
+var _ Evaluable = (*ScorecardNode)(nil)
+
+// Scorecard creates a ScorecardNode combining the scores emitted beneath it
+// with AggWeightedAvg. Use WithAggregation to combine them differently, e.g.
+// AggSum for points-based scorecards.
+//
+// Example:
+//
+//	tree := rules.Scorecard("creditScore", 0.6,
+//	    rules.Rules(incomeScore, historyScore, utilisationScore),
+//	)
+//	err := rules.ValidateWithData(ctx, tree, hooks, "application", app)
+//	var low rules.ScorecardError
+//	if errors.As(err, &low) {
+//	    fmt.Println(low.Score, low.Contributors[0].Name)
+//	}
+func Scorecard(name string, threshold float64, children ...Evaluable) *ScorecardNode {
+	return &ScorecardNode{Name: name, Threshold: threshold, Children: children}
+}
+
+// WithAggregation returns a copy of the scorecard combining its scores with
+// agg.
+func (n *ScorecardNode) WithAggregation(agg Aggregation) *ScorecardNode {
+	c := *n
+	c.Aggregation = agg
+	return &c
+}
+
+// PrepareConditions prepares the children.
+func (n *ScorecardNode) PrepareConditions(ctx context.Context) error {
+	for _, child := range n.Children {
+		if err := child.PrepareConditions(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Evaluate returns the rules of the children that evaluate successfully,
+// enclosed by two rules of the scorecard: one opening the collection of
+// scores and one closing it, which computes the combined score and returns
+// the error. The pair is created per evaluation, so the node stays safe for
+// concurrent use.
+func (n *ScorecardNode) Evaluate(ctx context.Context) (bool, []Rule) {
+	if trace := traceFromContext(ctx); trace != nil {
+		trace.push(n.Name)
+		defer trace.pop()
+	}
+
+	gate := &scorecardGate{node: n}
+	acc := []Rule{&scorecardOpen{gate: gate}}
+	for _, child := range n.Children {
+		if ok, rules := child.Evaluate(ctx); ok {
+			acc = append(acc, rules...)
+		}
+	}
+	return true, append(acc, gate)
+}
+
+// ScoreContribution is one score collected by a scorecard.
+type ScoreContribution struct {
+	Name   string // metric name of the score outcome
+	Score  float64
+	Weight float64 // the outcome's weight, 1 when unset
+}
+
+// ScorecardError is returned by a scorecard whose combined score is below
+// its threshold. It unwraps to an Error with code SCORE_BELOW_THRESHOLD and
+// the scorecard name as Field, so it is handled like any other rule error.
+type ScorecardError struct {
+	Scorecard string
+	Score     float64
+	Threshold float64
+	// Contributors lists the collected scores, those that pulled the combined
+	// score down the most (lowest weighted score) first.
+	Contributors []ScoreContribution
+}
+
+// Error describes the failure, naming the three lowest contributors.
+func (e ScorecardError) Error() string {
+	return e.Unwrap().Error()
+}
+
+// Unwrap returns the failure as an Error.
+func (e ScorecardError) Unwrap() error {
+	msg := fmt.Sprintf("score %g is below the threshold %g", e.Score, e.Threshold)
+	if len(e.Contributors) > 0 {
+		top := make([]string, 0, 3)
+		for _, c := range e.Contributors[:min(3, len(e.Contributors))] {
+			top = append(top, fmt.Sprintf("%s=%g", c.Name, c.Score))
+		}
+		msg += " (lowest: " + strings.Join(top, ", ") + ")"
+	}
+	return Error{Field: e.Scorecard, Err: msg, Code: ErrorCodeScoreBelowThreshold}
+}
+
+// scorecardScope collects the scores emitted while at least one scorecard is
+// open. Rules validate sequentially per target, so the scores of a scorecard
+// are those emitted between its opening and its gate.
+type scorecardScope struct {
+	open   int
+	scores []Outcome
+}
+
+// scorecardScopeFromContext returns the scorecard scope of the target
+// evaluated with ctx, or nil until a scorecard opens or outside the engine.
+func scorecardScopeFromContext(ctx context.Context) *scorecardScope {
+	if state := targetStateFromContext(ctx); state != nil {
+		return state.scorecard
+	}
+	return nil
+}
+
+// openScorecardScope returns the scorecard scope of the target evaluated with
+// ctx, creating it, or nil outside the engine.
+func openScorecardScope(ctx context.Context) *scorecardScope {
+	state := targetStateFromContext(ctx)
+	if state == nil || state.run == nil {
+		return nil
+	}
+	if state.scorecard == nil {
+		state.scorecard = &scorecardScope{}
+	}
+	return state.scorecard
+}
+
+// collect records a score outcome when a scorecard is open.
+func (s *scorecardScope) collect(o Outcome) {
+	if s != nil && s.open > 0 && o.Kind == KindScore {
+		s.scores = append(s.scores, o)
+	}
+}
+
+// scorecardOpen is the rule opening a scorecard's collection of scores.
+type scorecardOpen struct {
+	RuleBase
+	gate *scorecardGate
+}
+
+var _ Rule = (*scorecardOpen)(nil)
+
+func (r *scorecardOpen) Name() string                         { return r.gate.node.Name }
+func (r *scorecardOpen) Prepare(context.Context) (any, error) { return nil, nil }
+
+// Validate marks where the scorecard's scores start.
+func (r *scorecardOpen) Validate(ctx context.Context) error {
+	scope := openScorecardScope(ctx)
+	if scope == nil {
+		return nil
+	}
+	r.gate.start = len(scope.scores)
+	r.gate.opened = true
+	scope.open++
+	return nil
+}
+
+// scorecardGate is the rule closing a scorecard: it combines the scores
+// collected since the opening and compares them with the threshold.
+type scorecardGate struct {
+	RuleBase
+	node   *ScorecardNode
+	start  int
+	opened bool
+}
+
+var _ Rule = (*scorecardGate)(nil)
+
+func (r *scorecardGate) Name() string                         { return r.node.Name }
+func (r *scorecardGate) Prepare(context.Context) (any, error) { return nil, nil }
+
+// Validate returns a ScorecardError when the combined score is below the
+// threshold.
+func (r *scorecardGate) Validate(ctx context.Context) error {
+	scope := scorecardScopeFromContext(ctx)
+	if scope == nil || !r.opened {
+		return nil
+	}
+	scores := scope.scores[r.start:]
+	scope.open--
+	defer func() {
+		if scope.open == 0 {
+			scope.scores = scope.scores[:0]
+		}
+	}()
+	if len(scores) == 0 {
+		return nil
+	}
+
+	agg := r.node.Aggregation
+	if agg == AggNone {
+		agg = AggWeightedAvg
+	}
+	group := make([]Outcome, len(scores))
+	contributors := make([]ScoreContribution, len(scores))
+	for i, o := range scores {
+		o.Aggregation = agg
+		group[i] = o
+		weight := o.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		contributors[i] = ScoreContribution{Name: o.MetricName(), Score: o.Score, Weight: weight}
+	}
+
+	score := finalizeGroup(group).Score
+	if score >= r.node.Threshold {
+		return nil
+	}
+	slices.SortStableFunc(contributors, func(a, b ScoreContribution) int {
+		return cmp.Compare(a.Score*a.Weight, b.Score*b.Weight)
+	})
+	return ScorecardError{
+		Scorecard:    r.node.Name,
+		Score:        score,
+		Threshold:    r.node.Threshold,
+		Contributors: contributors,
+	}
+}
@@ -0,0 +1,117 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type applicant struct {
+	Income  float64
+	History float64
+	Debt    float64
+}
+
+// scoreRule emits a weighted score read from the applicant.
+func scoreRule(name string, weight float64, score func(applicant) float64) Rule {
+	return NewTypedMetricRule(name, KindScore, "", func(ctx context.Context, a applicant) (Outcome, error) {
+		return ScoreValue(score(a), weight), nil
+	})
+}
+
+func creditRules() Evaluable {
+	return Rules(
+		scoreRule("income", 2, func(a applicant) float64 { return a.Income }),
+		scoreRule("history", 1, func(a applicant) float64 { return a.History }),
+		scoreRule("debt", 1, func(a applicant) float64 { return a.Debt }),
+	)
+}
+
+func TestScorecard(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		testName  string
+		tree      Evaluable
+		applicant applicant
+		wantScore float64 // 0 when the scorecard passes
+		wantFirst string
+	}{
+		{testName: "above threshold", tree: Scorecard("credit", 0.6, creditRules()), applicant: applicant{Income: 0.8, History: 0.6, Debt: 0.4}},
+		{testName: "below threshold", tree: Scorecard("credit", 0.6, creditRules()), applicant: applicant{Income: 0.5, History: 0.9, Debt: 0.1}, wantScore: 0.5, wantFirst: "debt"},
+		{
+			testName:  "points-based",
+			tree:      Scorecard("points", 100, creditRules()).WithAggregation(AggSum),
+			applicant: applicant{Income: 40, History: 30, Debt: 20},
+			wantScore: 90,
+			wantFirst: "debt",
+		},
+		{testName: "no scores", tree: Scorecard("empty", 1, Rules(NewRulePure("noop", func() error { return nil }))), applicant: applicant{}},
+		{
+			testName:  "scores outside are ignored",
+			tree:      AllOf(Rules(scoreRule("outside", 1, func(applicant) float64 { return 0 })), Scorecard("credit", 0.5, creditRules())),
+			applicant: applicant{Income: 0.5, History: 0.5, Debt: 0.5},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			t.Parallel()
+
+			err := ValidateWithData(context.Background(), tc.tree, ProcessingHooks{}, "application", tc.applicant)
+			if tc.wantScore == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var scErr ScorecardError
+			if !errors.As(err, &scErr) {
+				t.Fatalf("error = %v, want a ScorecardError", err)
+			}
+			if scErr.Score != tc.wantScore || len(scErr.Contributors) != 3 || scErr.Contributors[0].Name != tc.wantFirst {
+				t.Errorf("score = %v, contributors = %+v", scErr.Score, scErr.Contributors)
+			}
+			var ruleErr Error
+			if !errors.As(err, &ruleErr) || ruleErr.Code != ErrorCodeScoreBelowThreshold || ruleErr.Field != scErr.Scorecard {
+				t.Errorf("error = %v, want code %s", ruleErr, ErrorCodeScoreBelowThreshold)
+			}
+		})
+	}
+}
+
+func TestScorecard_Nested(t *testing.T) {
+	t.Parallel()
+
+	inner := Scorecard("inner", 0.5, Rules(scoreRule("risk", 1, func(a applicant) float64 { return a.Debt })))
+	outer := Scorecard("outer", 0.5, Rules(scoreRule("income", 1, func(a applicant) float64 { return a.Income })), inner)
+
+	report, err := EvaluateMetricsWithData(context.Background(), outer, ProcessingHooks{}, "application", applicant{Income: 0.9, Debt: 0.2})
+	if err == nil || report.Valid {
+		t.Fatal("expected the inner scorecard to fail")
+	}
+	var scErr ScorecardError
+	if !errors.As(err, &scErr) || scErr.Scorecard != "inner" || len(report.Errors) != 1 {
+		t.Errorf("errors = %v, want only the inner scorecard to fail", report.Errors)
+	}
+	// The scores are still reported.
+	if report.Metrics["income"].Score != 0.9 || report.Metrics["risk"].Score != 0.2 {
+		t.Errorf("metrics = %v", report.Metrics)
+	}
+}
+
+func TestScorecard_Fingerprint(t *testing.T) {
+	t.Parallel()
+
+	a := Fingerprint(Scorecard("credit", 0.6, creditRules()))
+	if a != Fingerprint(Scorecard("credit", 0.6, creditRules())) {
+		t.Error("equal scorecards must share a fingerprint")
+	}
+	if a == Fingerprint(Scorecard("credit", 0.7, creditRules())) {
+		t.Error("the threshold must change the fingerprint")
+	}
+	if a == Fingerprint(Scorecard("credit", 0.6, creditRules()).WithAggregation(AggMin)) {
+		t.Error("the aggregation must change the fingerprint")
+	}
+}
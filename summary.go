@@ -0,0 +1,176 @@
+package rules
+
+import (
+	"math"
+	"slices"
+)
+
+// DefaultSummaryCompression is the compression used when NewSummary is given
+// a non-positive one.
+const DefaultSummaryCompression = 100
+
+// Summary is a streaming quantile sketch, a merging t-digest: observations
+// are kept as weighted centroids, small near the extremes and larger around
+// the median, so tail quantiles such as p99 stay accurate while the sketch
+// holds at most about 2*Compression centroids once compressed. Summaries
+// merge across rules and targets, unlike precomputed quantiles.
+//
+// Example:
+//
+//	amounts := rules.NewSummary(0)
+//	for _, item := range order.Items {
+//	    amounts.Observe(item.Amount)
+//	}
+//	o := rules.SummaryValue(amounts)
+type Summary struct {
+	Compression float64
+	Centroids   []Centroid // sorted by Mean once compressed
+	Count       float64
+	Sum         float64
+	Min         float64 // smallest observation, when Count > 0
+	Max         float64 // largest observation, when Count > 0
+}
+
+// Centroid is the mean of Weight observations of a Summary.
+type Centroid struct {
+	Mean   float64
+	Weight float64
+}
+
+// NewSummary creates an empty summary. Higher compressions keep more
+// centroids, for more accurate quantiles (DefaultSummaryCompression when
+// compression is not positive).
+func NewSummary(compression float64) Summary {
+	if compression <= 0 {
+		compression = DefaultSummaryCompression
+	}
+	return Summary{Compression: compression}
+}
+
+// SummaryValue returns a KindSummary outcome carrying a summary. The summary
+// is compressed first, so the outcome stays small.
+func SummaryValue(s Summary) Outcome {
+	s = s.clone()
+	s.compress()
+	return Outcome{Kind: KindSummary, Summary: s}
+}
+
+// Observe adds an observation. NaN and infinite values are ignored.
+func (s *Summary) Observe(v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+	s.add(Centroid{Mean: v, Weight: 1}, v, v)
+	s.Sum += v
+}
+
+// Merge adds the observations of other to s. other is not modified.
+func (s *Summary) Merge(other Summary) {
+	if other.Count == 0 {
+		return
+	}
+	if s.Compression <= 0 {
+		s.Compression = max(other.Compression, 0)
+	}
+	for _, c := range other.Centroids {
+		s.add(c, other.Min, other.Max)
+	}
+	s.Sum += other.Sum
+}
+
+// Quantile estimates the q-quantile (0 <= q <= 1) of the observations by
+// interpolating between centroid means. It returns NaN for an empty summary;
+// q is clamped to [0, 1].
+func (s Summary) Quantile(q float64) float64 {
+	if s.Count == 0 || math.IsNaN(q) {
+		return math.NaN()
+	}
+	s = s.clone()
+	s.compress()
+	rank := min(max(q, 0), 1) * s.Count
+
+	cs := s.Centroids
+	var before float64 // weight of the centroids before cs[i]
+	for i, c := range cs {
+		mid := before + c.Weight/2
+		if rank < mid {
+			if i == 0 {
+				return s.Min + (c.Mean-s.Min)*rank/mid
+			}
+			prev := cs[i-1]
+			prevMid := before - prev.Weight/2
+			return prev.Mean + (c.Mean-prev.Mean)*(rank-prevMid)/(mid-prevMid)
+		}
+		before += c.Weight
+	}
+	last := cs[len(cs)-1]
+	lastMid := s.Count - last.Weight/2
+	if s.Count == lastMid {
+		return s.Max
+	}
+	return last.Mean + (s.Max-last.Mean)*(rank-lastMid)/(s.Count-lastMid)
+}
+
+// add adds a centroid whose observations range over [lo, hi], compressing
+// when the buffer grows.
+func (s *Summary) add(c Centroid, lo, hi float64) {
+	if s.Compression <= 0 {
+		s.Compression = DefaultSummaryCompression
+	}
+	if s.Count == 0 || lo < s.Min {
+		s.Min = lo
+	}
+	if s.Count == 0 || hi > s.Max {
+		s.Max = hi
+	}
+	s.Count += c.Weight
+	s.Centroids = append(s.Centroids, c)
+	if float64(len(s.Centroids)) > 10*s.Compression {
+		s.compress()
+	}
+}
+
+// compress merges adjacent centroids as long as each one stays within one
+// unit of the k1 scale function k(q) = Compression/(2π) * asin(2q-1).
+func (s *Summary) compress() {
+	if len(s.Centroids) < 2 {
+		return
+	}
+	slices.SortFunc(s.Centroids, func(a, b Centroid) int {
+		switch {
+		case a.Mean < b.Mean:
+			return -1
+		case a.Mean > b.Mean:
+			return 1
+		}
+		return 0
+	})
+
+	k := func(q float64) float64 { return s.Compression / (2 * math.Pi) * math.Asin(2*q-1) }
+	limit := func(q float64) float64 {
+		return (math.Sin(min(k(q)+1, s.Compression/4)*2*math.Pi/s.Compression) + 1) / 2
+	}
+
+	merged := s.Centroids[:1]
+	var before float64 // weight of the merged centroids before the last one
+	qLimit := limit(0)
+	for _, c := range s.Centroids[1:] {
+		cur := &merged[len(merged)-1]
+		if (before+cur.Weight+c.Weight)/s.Count <= qLimit {
+			w := cur.Weight + c.Weight
+			cur.Mean += (c.Mean - cur.Mean) * c.Weight / w
+			cur.Weight = w
+			continue
+		}
+		before += cur.Weight
+		qLimit = limit(before / s.Count)
+		merged = append(merged, c)
+	}
+	s.Centroids = slices.Clip(merged)
+}
+
+// clone returns a copy of s that shares no slices with it.
+func (s Summary) clone() Summary {
+	s.Centroids = slices.Clone(s.Centroids)
+	return s
+}
@@ -0,0 +1,60 @@
+package rules
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+func TestSummary_Quantile(t *testing.T) {
+	t.Parallel()
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	values := make([]float64, 10_000)
+	s := NewSummary(0)
+	for i := range values {
+		values[i] = rng.ExpFloat64() * 100
+		s.Observe(values[i])
+	}
+	slices.Sort(values)
+
+	for _, q := range []float64{0, 0.5, 0.9, 0.99, 0.999, 1} {
+		want := values[min(int(q*float64(len(values))), len(values)-1)]
+		if got := s.Quantile(q); math.Abs(got-want) > 0.02*want+0.5 {
+			t.Errorf("Quantile(%v) = %v, want about %v", q, got, want)
+		}
+	}
+	if got := s.Quantile(0); got != values[0] {
+		t.Errorf("Quantile(0) = %v, want the minimum %v", got, values[0])
+	}
+	if o := SummaryValue(s); len(o.Summary.Centroids) > int(2*s.Compression) {
+		t.Errorf("compressed summary holds %d centroids", len(o.Summary.Centroids))
+	}
+	if got := (Summary{}).Quantile(0.5); !math.IsNaN(got) {
+		t.Errorf("empty Quantile = %v, want NaN", got)
+	}
+}
+
+func TestSummary_Merge(t *testing.T) {
+	t.Parallel()
+
+	low, high := NewSummary(0), NewSummary(0)
+	for i := range 1000 {
+		low.Observe(float64(i))
+		high.Observe(float64(1000 + i))
+	}
+	highCentroids := slices.Clone(high.Centroids)
+
+	merged := low.clone()
+	merged.Merge(high)
+	if merged.Count != 2000 || merged.Min != 0 || merged.Max != 1999 || merged.Sum != low.Sum+high.Sum {
+		t.Errorf("Count = %v, Min = %v, Max = %v", merged.Count, merged.Min, merged.Max)
+	}
+	if got := merged.Quantile(0.5); math.Abs(got-1000) > 20 {
+		t.Errorf("merged median = %v, want about 1000", got)
+	}
+	if !slices.Equal(high.Centroids, highCentroids) {
+		t.Error("Merge must not modify its argument")
+	}
+}
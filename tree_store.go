@@ -0,0 +1,278 @@
+package rules
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TreeVersion identifies the version of a named tree held in a TreeStore.
+// The zero value means the evaluated tree did not come from a store.
+type TreeVersion struct {
+	Name    string // name the tree was published under
+	Version uint64 // per-name version, starting at 1 and increasing on every publish
+}
+
+// String returns the version as "name@vN", or an empty string for the zero
+// value.
+func (v TreeVersion) String() string {
+	if v == (TreeVersion{}) {
+		return ""
+	}
+	return fmt.Sprintf("%s@v%d", v.Name, v.Version)
+}
+
+// VersionedTree is an immutable snapshot of one version of a named tree. It
+// implements Evaluable by delegating to Tree, so it can be passed to Validate,
+// ValidateMulti, EvaluateMetrics and friends directly; the engine then stamps
+// its TreeVersion onto the Report and the ExecutionTrace.
+//
+// Because a snapshot never changes, an evaluation that started with one
+// version keeps using it even if the store publishes a newer version while the
+// evaluation is in flight.
+type VersionedTree struct {
+	Tree        Evaluable
+	TreeVersion TreeVersion
+	UpdatedAt   time.Time // when this version was published
+	Fingerprint string    // Fingerprint of Tree, computed when the version is published
+}
+
+// PrepareConditions delegates to the wrapped tree.
+func (t *VersionedTree) PrepareConditions(ctx context.Context) error {
+	return t.Tree.PrepareConditions(ctx)
+}
+
+// Evaluate delegates to the wrapped tree.
+func (t *VersionedTree) Evaluate(ctx context.Context) (bool, []Rule) {
+	return t.Tree.Evaluate(ctx)
+}
+
+var _ Evaluable = (*VersionedTree)(nil) // Ensure VersionedTree implements the Evaluable interface.
+
+// treeVersionOf returns the version of tree when it is a VersionedTree, or the
+// zero TreeVersion otherwise.
+func treeVersionOf(tree Evaluable) TreeVersion {
+	if vt, ok := tree.(*VersionedTree); ok {
+		return vt.TreeVersion
+	}
+	return TreeVersion{}
+}
+
+// TreeStore holds named, versioned trees and swaps them atomically. Reads are
+// lock-free: Get loads an immutable snapshot, so any number of goroutines can
+// evaluate trees while another goroutine publishes new versions.
+//
+// Example:
+//
+//	store := rules.NewTreeStore()
+//	store.Set("signup", buildSignupTree())
+//
+//	// per request
+//	tree, ok := store.Get("signup")
+//	if !ok {
+//	    return errors.New("no signup policy")
+//	}
+//	err := rules.ValidateWithData(ctx, tree, hooks, "signup", req)
+type TreeStore struct {
+	mu       sync.Mutex // serializes writers
+	trees    atomic.Pointer[map[string]*VersionedTree]
+	versions map[string]uint64 // last version per name, kept across deletes
+}
+
+// NewTreeStore creates an empty TreeStore.
+func NewTreeStore() *TreeStore {
+	s := &TreeStore{versions: make(map[string]uint64)}
+	s.trees.Store(&map[string]*VersionedTree{})
+	return s
+}
+
+// Get returns the current version of the named tree. The returned snapshot is
+// immutable: later calls to Set or Delete do not affect it.
+func (s *TreeStore) Get(name string) (*VersionedTree, bool) {
+	tree, ok := (*s.trees.Load())[name]
+	return tree, ok
+}
+
+// Set publishes tree as the next version of name and returns the new
+// snapshot. Evaluations that already hold the previous version keep using it.
+func (s *TreeStore) Set(name string, tree Evaluable) *VersionedTree {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.versions[name]++
+	vt := &VersionedTree{
+		Tree: tree,
+		TreeVersion: TreeVersion{
+			Name:    name,
+			Version: s.versions[name],
+		},
+		UpdatedAt:   time.Now(),
+		Fingerprint: Fingerprint(tree),
+	}
+
+	s.swap(func(trees map[string]*VersionedTree) { trees[name] = vt })
+	return vt
+}
+
+// Delete removes the named tree from the store. Version numbers are not reset:
+// publishing the same name again continues from the last version.
+func (s *TreeStore) Delete(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.swap(func(trees map[string]*VersionedTree) { delete(trees, name) })
+}
+
+// Names returns the names of the trees currently held, sorted.
+func (s *TreeStore) Names() []string {
+	trees := *s.trees.Load()
+	names := make([]string, 0, len(trees))
+	for name := range trees {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// swap copies the current map, applies update to the copy and publishes it.
+// The caller must hold s.mu.
+func (s *TreeStore) swap(update func(map[string]*VersionedTree)) {
+	current := *s.trees.Load()
+	next := make(map[string]*VersionedTree, len(current)+1)
+	for name, tree := range current {
+		next[name] = tree
+	}
+	update(next)
+	s.trees.Store(&next)
+}
+
+// TreeBuilder builds a tree from a declarative definition. name is the file
+// name without its extension and definition is the file content.
+type TreeBuilder func(name string, definition []byte) (Evaluable, error)
+
+// LoadDir builds a tree from every regular file in dir and publishes each one
+// under its file name without the extension. Hidden files and directories are
+// skipped. Files that fail to build, and files whose names differ only in
+// their extension (e.g. "signup.json" and "signup.yaml"), are reported in the
+// returned error and leave any previously published version in place.
+func (s *TreeStore) LoadDir(dir string, build TreeBuilder) error {
+	w := &dirWatcher{store: s, dir: dir, build: build, hashes: make(map[string][sha256.Size]byte)}
+	return errors.Join(w.sync()...)
+}
+
+// WatchDir loads dir like LoadDir and then polls it every interval until ctx
+// is done, rebuilding trees whose file content changed and deleting trees
+// whose file was removed. The initial load runs before WatchDir returns and
+// its error is returned; errors from later polls are passed to onError (which
+// may be nil). A definition that fails to rebuild keeps its previous version
+// in the store, so a bad edit never takes a policy offline. interval must be
+// positive.
+//
+// Example:
+//
+//	err := store.WatchDir(ctx, "policies", 5*time.Second,
+//	    func(name string, def []byte) (rules.Evaluable, error) {
+//	        return parsePolicy(def)
+//	    },
+//	    func(err error) { log.Printf("policy reload: %v", err) },
+//	)
+func (s *TreeStore) WatchDir(ctx context.Context, dir string, interval time.Duration, build TreeBuilder, onError func(error)) error {
+	if interval <= 0 {
+		return fmt.Errorf("rules: watch %s: interval must be positive, got %v", dir, interval)
+	}
+	w := &dirWatcher{store: s, dir: dir, build: build, hashes: make(map[string][sha256.Size]byte)}
+	err := errors.Join(w.sync()...)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if errs := w.sync(); len(errs) > 0 && onError != nil {
+					onError(errors.Join(errs...))
+				}
+			}
+		}
+	}()
+
+	return err
+}
+
+// dirWatcher tracks the content hash of every definition it published so
+// unchanged files are not rebuilt on each poll.
+type dirWatcher struct {
+	store  *TreeStore
+	dir    string
+	build  TreeBuilder
+	hashes map[string][sha256.Size]byte
+}
+
+// sync rebuilds changed definitions and deletes removed ones.
+func (w *dirWatcher) sync() []error {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return []error{err}
+	}
+
+	// Group the definitions by tree name: two files with the same name and
+	// different extensions are ambiguous and neither is published.
+	files := make(map[string][]string, len(entries))
+	var names []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		if _, ok := files[name]; !ok {
+			names = append(names, name)
+		}
+		files[name] = append(files[name], entry.Name())
+	}
+
+	var errs []error
+	for _, name := range names {
+		if len(files[name]) > 1 {
+			errs = append(errs, fmt.Errorf("build tree %q: defined by several files: %s", name, strings.Join(files[name], ", ")))
+			continue
+		}
+
+		definition, err := os.ReadFile(filepath.Join(w.dir, files[name][0]))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		hash := sha256.Sum256(definition)
+		if prev, ok := w.hashes[name]; ok && prev == hash {
+			continue
+		}
+
+		tree, err := w.build(name, definition)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("build tree %q: %w", name, err))
+			continue
+		}
+		w.store.Set(name, tree)
+		w.hashes[name] = hash
+	}
+
+	for name := range w.hashes {
+		if _, ok := files[name]; !ok {
+			w.store.Delete(name)
+			delete(w.hashes, name)
+		}
+	}
+
+	return errs
+}
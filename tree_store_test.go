@@ -0,0 +1,262 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTreeStore_SetGetVersions(t *testing.T) {
+	t.Parallel()
+
+	store := NewTreeStore()
+	if _, ok := store.Get("signup"); ok {
+		t.Fatal("expected empty store")
+	}
+
+	v1 := store.Set("signup", Rules(&NopRule{}))
+	v2 := store.Set("signup", Rules(&NopRule{}))
+	if v1.TreeVersion.Version != 1 || v2.TreeVersion.Version != 2 {
+		t.Fatalf("versions = %d, %d, want 1, 2", v1.TreeVersion.Version, v2.TreeVersion.Version)
+	}
+
+	got, ok := store.Get("signup")
+	if !ok || got != v2 {
+		t.Fatalf("Get returned %v, want latest version", got)
+	}
+	if got.TreeVersion.String() != "signup@v2" {
+		t.Errorf("String() = %q, want signup@v2", got.TreeVersion.String())
+	}
+
+	store.Delete("signup")
+	if _, ok := store.Get("signup"); ok {
+		t.Fatal("expected tree to be deleted")
+	}
+	if v3 := store.Set("signup", Rules(&NopRule{})); v3.TreeVersion.Version != 3 {
+		t.Errorf("version after delete = %d, want 3", v3.TreeVersion.Version)
+	}
+}
+
+func TestTreeStore_InFlightEvaluationKeepsVersion(t *testing.T) {
+	t.Parallel()
+
+	store := NewTreeStore()
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	slow := NewTypedRule[int]("slow", func(ctx context.Context, n int) error {
+		close(started)
+		<-release
+		return errors.New("v1 rejected")
+	})
+	store.Set("policy", Rules(slow))
+
+	tree, _ := store.Get("policy")
+	done := make(chan Report)
+	go func() {
+		report, _ := EvaluateMetricsWithData(context.Background(), tree, ProcessingHooks{}, "policy", 1)
+		done <- report
+	}()
+
+	<-started
+	store.Set("policy", Rules(&NopRule{}))
+	close(release)
+
+	report := <-done
+	if report.Valid {
+		t.Error("in-flight evaluation should use the version it started with")
+	}
+	if report.TreeVersion != (TreeVersion{Name: "policy", Version: 1}) {
+		t.Errorf("TreeVersion = %v, want policy@v1", report.TreeVersion)
+	}
+
+	latest, _ := store.Get("policy")
+	if err := ValidateWithData(context.Background(), latest, ProcessingHooks{}, "policy", 1); err != nil {
+		t.Errorf("latest version should pass, got %v", err)
+	}
+}
+
+func TestTreeStore_TraceRecordsVersion(t *testing.T) {
+	t.Parallel()
+
+	store := NewTreeStore()
+	store.Set("policy", Rules(&NopRule{}))
+	tree, _ := store.Get("policy")
+
+	ctx, trace := WithExecutionTrace(context.Background())
+	if err := ValidateWithData(ctx, tree, ProcessingHooks{}, "policy", 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := trace.TreeVersion().String(); got != "policy@v1" {
+		t.Errorf("trace.TreeVersion() = %q, want policy@v1", got)
+	}
+}
+
+func TestTreeStore_ConcurrentSwap(t *testing.T) {
+	t.Parallel()
+
+	store := NewTreeStore()
+	store.Set("policy", Rules(&NopRule{}))
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Go(func() {
+			for range 100 {
+				tree, ok := store.Get("policy")
+				if !ok {
+					t.Error("tree missing during swap")
+					return
+				}
+				if err := ValidateWithData(context.Background(), tree, ProcessingHooks{}, "policy", 1); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		})
+	}
+	for range 100 {
+		store.Set("policy", Rules(&NopRule{}))
+	}
+	wg.Wait()
+
+	tree, _ := store.Get("policy")
+	if tree.TreeVersion.Version != 101 {
+		t.Errorf("Version = %d, want 101", tree.TreeVersion.Version)
+	}
+}
+
+// minAgeBuilder builds a tree from a definition holding a minimum age.
+func minAgeBuilder(name string, definition []byte) (Evaluable, error) {
+	minAge, err := strconv.Atoi(string(definition))
+	if err != nil {
+		return nil, err
+	}
+	return Rules(NewTypedRule[int](name, func(ctx context.Context, age int) error {
+		if age < minAge {
+			return Error{Field: "age", Err: "too young", Code: "VALUE_LOWER_MIN"}
+		}
+		return nil
+	})), nil
+}
+
+func TestTreeStore_LoadDir(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "adult.txt"), "18")
+	writeFile(t, filepath.Join(dir, "senior.txt"), "65")
+	writeFile(t, filepath.Join(dir, ".hidden"), "not a tree")
+
+	store := NewTreeStore()
+	if err := store.LoadDir(dir, minAgeBuilder); err != nil {
+		t.Fatalf("LoadDir: %v", err)
+	}
+	if got := store.Names(); len(got) != 2 || got[0] != "adult" || got[1] != "senior" {
+		t.Fatalf("Names() = %v, want [adult senior]", got)
+	}
+
+	writeFile(t, filepath.Join(dir, "broken.txt"), "not a number")
+	if err := store.LoadDir(dir, minAgeBuilder); err == nil {
+		t.Error("expected build error for broken definition")
+	}
+	if _, ok := store.Get("broken"); ok {
+		t.Error("broken definition should not be published")
+	}
+
+	// Two definitions of one tree are ambiguous: the published version stays.
+	published, _ := store.Get("adult")
+	writeFile(t, filepath.Join(dir, "adult.json"), "21")
+	err := store.LoadDir(dir, minAgeBuilder)
+	if err == nil || !strings.Contains(err.Error(), "adult.json, adult.txt") {
+		t.Errorf("error = %v, want the duplicate adult definitions", err)
+	}
+	if tree, ok := store.Get("adult"); !ok || tree != published {
+		t.Errorf("adult = %v, %v, want %v kept", tree, ok, published)
+	}
+}
+
+func TestTreeStore_WatchDir(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "adult.txt")
+	writeFile(t, path, "18")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewTreeStore()
+	errs := make(chan error, 16)
+	if err := store.WatchDir(ctx, dir, time.Millisecond, minAgeBuilder, func(err error) { errs <- err }); err != nil {
+		t.Fatalf("WatchDir: %v", err)
+	}
+
+	tree, _ := store.Get("adult")
+	if err := ValidateWithData(ctx, tree, ProcessingHooks{}, "adult", 20); err != nil {
+		t.Fatalf("v1 should accept 20: %v", err)
+	}
+
+	writeFile(t, path, "21")
+	waitFor(t, func() bool {
+		tree, _ := store.Get("adult")
+		return tree.TreeVersion.Version == 2
+	})
+	tree, _ = store.Get("adult")
+	if err := ValidateWithData(ctx, tree, ProcessingHooks{}, "adult", 20); err == nil {
+		t.Error("v2 should reject 20")
+	}
+
+	// A bad edit keeps the previous version and reports the error.
+	writeFile(t, path, "oops")
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected reload error")
+	}
+	if tree, _ := store.Get("adult"); tree.TreeVersion.Version != 2 {
+		t.Errorf("Version = %d, want 2 after failed reload", tree.TreeVersion.Version)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		_, ok := store.Get("adult")
+		return !ok
+	})
+}
+
+func TestTreeStore_WatchDirInterval(t *testing.T) {
+	t.Parallel()
+
+	store := NewTreeStore()
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if err := store.WatchDir(context.Background(), t.TempDir(), interval, minAgeBuilder, nil); err == nil {
+			t.Errorf("interval %v: expected an error", interval)
+		}
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before deadline")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
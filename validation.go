@@ -3,6 +3,8 @@ package rules
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
 )
 
 // Hook is called after each step of the validation process.
@@ -15,6 +17,10 @@ type ProcessingHooks struct {
 	AfterEvaluateConditions Hook
 	AfterPrepareRules       Hook
 	AfterValidateRules      Hook
+
+	// OnRetry is called before each retry of a prepare step wrapped with
+	// RetryRule or RetryCondition, or run through Retry.
+	OnRetry func(ctx context.Context, event RetryEvent)
 }
 
 // Target holds the Evaluable tree and the context for evaluation.
@@ -42,25 +48,87 @@ func NewTarget(ctx context.Context, tree Evaluable) *Target {
 // EvaluateMetricsMulti. When collectMetrics is true, an outcome collector is
 // attached per target during phase 4 and one Report per target is returned.
 //
+// All targets share one Loader session: keys registered during phase 1 are
+// fetched once at its end, and keys registered during phase 3 once at its end.
+//
 // targets is copied before per-target state is attached, so the caller's
 // slice is never mutated. Hooks receive the ctx passed by the caller: per-
 // target prepared data is not visible at the hook level, which is consistent
 // across single- and multi-target runs.
 func run(ctx context.Context, targets []Target, hooks ProcessingHooks, name string, collectMetrics bool) ([]Report, []error) {
 	// Copy so the caller's slice is never mutated: each target gets its own
-	// prepared-data store, so prepare results for one target never leak into
-	// another when a tree is shared between targets.
+	// state, and with it its own prepared-data store, so prepare results for
+	// one target never leak into another when a tree is shared between
+	// targets. The rest of the per-run and per-target state is created on
+	// first use.
 	targets = append([]Target(nil), targets...)
+	shared := &runState{name: name, onRetry: hooks.OnRetry}
+	states := make([]targetState, len(targets))
 	for i := range targets {
-		targets[i].ctx, _ = withPreparedStore(targets[i].ctx)
+		states[i].run = shared
+		targets[i].ctx = context.WithValue(targets[i].ctx, targetStateKey{}, &states[i])
 	}
 
-	// Phase 1: prepare the conditions for all targets.
-	for _, target := range targets {
+	// Recording and replay attach a step session to every target, through
+	// which the engine and the built-in composites prepare their steps.
+	recorder := recorderFromContext(ctx)
+	cassettes, replay := replayFromContext(ctx)
+	if recorder != nil || replay {
+		sessions := make([]*stepSession, len(targets))
+		for i, target := range targets {
+			var cassette *Cassette
+			if replay {
+				var err error
+				if cassette, err = cassetteFor(cassettes, i, states[i].treeFingerprint(target.tree)); err != nil {
+					return nil, []error{err}
+				}
+			}
+			session, err := newStepSession(target.tree, recorder != nil, cassette)
+			if err != nil {
+				return nil, []error{err}
+			}
+			sessions[i] = session
+			targets[i].ctx = context.WithValue(target.ctx, stepSessionKey{}, session)
+		}
+		if recorder != nil {
+			defer func() {
+				for i, target := range targets {
+					recorder.add(sessions[i].cassette(target.ctx, target.tree, name, i, states[i].treeFingerprint(target.tree)))
+				}
+			}()
+		}
+	}
+
+	// Mutation detection snapshots every target's registry up front, and
+	// traces the targets that have no trace so mutations can be reported
+	// with the rule's path.
+	if mutationDetectionFromContext(ctx) {
+		for i := range targets {
+			if traceFromContext(targets[i].ctx) == nil {
+				targets[i].ctx, _ = WithExecutionTrace(targets[i].ctx)
+			}
+			states[i].mutations = newMutationDetector(registryFromContext(targets[i].ctx))
+		}
+	}
+
+	// Phase 1: prepare the conditions for all targets. With target isolation
+	// a failure only takes its own target out of the later phases.
+	isolate := targetIsolationFromContext(ctx)
+	targetErrs := make([][]error, len(targets))
+	for i, target := range targets {
 		if err := target.tree.PrepareConditions(target.ctx); err != nil {
-			return nil, []error{err}
+			if !isolate {
+				return nil, []error{err}
+			}
+			states[i].failed = true
+			targetErrs[i] = []error{TargetError{Index: i, Err: err}}
+			continue
+		}
+		if err := states[i].mutations.check(target.ctx, nil); err != nil {
+			targetErrs[i] = append(targetErrs[i], err)
 		}
 	}
+	shared.flushLoaders(ctx)
 
 	if hooks.AfterPrepareConditions != nil {
 		if err := hooks.AfterPrepareConditions(ctx); err != nil {
@@ -72,13 +140,20 @@ func run(ctx context.Context, targets []Target, hooks ProcessingHooks, name stri
 	// pushed onto the execution trace (if any) as the root path segment.
 	evaluated := make([][]Rule, len(targets))
 	for i, target := range targets {
+		if states[i].failed {
+			continue
+		}
 		if trace := traceFromContext(target.ctx); trace != nil {
+			trace.setTree(treeVersionOf(target.tree), states[i].treeFingerprint(target.tree))
 			trace.push(name)
 		}
 		_, evaluated[i] = target.tree.Evaluate(target.ctx)
 		if trace := traceFromContext(target.ctx); trace != nil {
 			trace.pop()
 		}
+		if err := states[i].mutations.check(target.ctx, nil); err != nil {
+			targetErrs[i] = append(targetErrs[i], err)
+		}
 	}
 
 	if hooks.AfterEvaluateConditions != nil {
@@ -88,17 +163,21 @@ func run(ctx context.Context, targets []Target, hooks ProcessingHooks, name stri
 	}
 
 	// Phase 3: prepare all rules across targets (batch).
-	targetErrs := make([][]error, len(targets))
 	prepared := make([][]Rule, len(targets))
 	for i, target := range targets {
 		for _, rule := range evaluated[i] {
-			if _, err := rule.Prepare(target.ctx); err != nil {
+			_, err := prepareStep(target.ctx, rule)
+			if mutErr := states[i].mutations.check(target.ctx, rule); mutErr != nil {
+				targetErrs[i] = append(targetErrs[i], mutErr)
+			}
+			if err != nil {
 				targetErrs[i] = append(targetErrs[i], err)
 				continue
 			}
 			prepared[i] = append(prepared[i], rule)
 		}
 	}
+	shared.flushLoaders(ctx)
 
 	if hooks.AfterPrepareRules != nil {
 		if err := hooks.AfterPrepareRules(ctx); err != nil {
@@ -110,19 +189,40 @@ func run(ctx context.Context, targets []Target, hooks ProcessingHooks, name stri
 	// metric outcomes. The validation context carries an outcome collector
 	// so metric-carrying rules can record their outcomes while they
 	// validate; the collector is a per-evaluation side channel, so no rule
-	// is mutated and rules stay safe to share across goroutines.
+	// is mutated and rules stay safe to share across goroutines. The scores
+	// Scorecard nodes gate on are collected in the target state.
 	reports := make([]Report, len(targets))
 	for i, target := range targets {
+		if states[i].failed {
+			if collectMetrics {
+				reports[i] = Report{
+					Errors:      targetErrs[i],
+					TreeVersion: treeVersionOf(target.tree),
+					Fingerprint: states[i].treeFingerprint(target.tree),
+				}
+			}
+			continue
+		}
+
 		valCtx := target.ctx
 		var collector *outcomeCollector
 		if collectMetrics {
-			valCtx, collector = withOutcomeCollector(target.ctx)
+			valCtx, collector = withOutcomeCollector(valCtx)
 		}
 
 		for _, rule := range prepared[i] {
 			if err := rule.Validate(valCtx); err != nil {
 				targetErrs[i] = append(targetErrs[i], err)
 			}
+			if err := states[i].mutations.check(target.ctx, rule); err != nil {
+				targetErrs[i] = append(targetErrs[i], err)
+			}
+		}
+
+		// Retries are emitted like rule outcomes, so OutcomeSinks see them
+		// with Validate as well as EvaluateMetrics.
+		for _, outcome := range states[i].retries.outcomes() {
+			Emit(valCtx, outcome)
 		}
 
 		if collector != nil {
@@ -136,6 +236,8 @@ func run(ctx context.Context, targets []Target, hooks ProcessingHooks, name stri
 			reports[i] = aggregateOutcomes(collector.outcomes)
 			reports[i].Errors = targetErrs[i]
 			reports[i].Valid = len(targetErrs[i]) == 0
+			reports[i].TreeVersion = treeVersionOf(target.tree)
+			reports[i].Fingerprint = states[i].treeFingerprint(target.tree)
 		}
 	}
 
@@ -152,6 +254,79 @@ func run(ctx context.Context, targets []Target, hooks ProcessingHooks, name stri
 	return reports, flattenErrors(targetErrs)
 }
 
+// targetStateKey is the context key for the state of a target.
+type targetStateKey struct{}
+
+// runState is the state shared by the targets of one run.
+type runState struct {
+	name    string
+	onRetry func(ctx context.Context, event RetryEvent)
+
+	mu      sync.Mutex
+	loaders *loaderSession // created by the first Loader used
+}
+
+// targetState is the state of one target of a run. Only the prepared-data
+// store is always used; the other fields are created on first use, so a run
+// that uses no loader, retry, scorecard or mutation detection allocates
+// nothing for them. The engine evaluates a target sequentially, so the
+// target state needs no locking.
+type targetState struct {
+	run       *runState // nil outside a run (see withPreparedStore)
+	store     preparedStore
+	retries   *retryLog
+	scorecard *scorecardScope
+	mutations *mutationDetector
+	failed    bool
+
+	fingerprint string // of the target's tree, computed on first use
+}
+
+// treeFingerprint returns the fingerprint of the target's tree, hashing it
+// once per run. Trees published by a TreeStore carry the fingerprint computed
+// at publication; other trees may change between runs, so they are hashed
+// again by every run.
+func (s *targetState) treeFingerprint(tree Evaluable) string {
+	if s.fingerprint == "" {
+		s.fingerprint = Fingerprint(tree)
+	}
+	return s.fingerprint
+}
+
+// targetStateFromContext returns the target state attached to ctx, or nil.
+func targetStateFromContext(ctx context.Context) *targetState {
+	state, _ := ctx.Value(targetStateKey{}).(*targetState)
+	return state
+}
+
+// runNameFromContext returns the name of the run evaluating ctx, or "".
+func runNameFromContext(ctx context.Context) string {
+	if state := targetStateFromContext(ctx); state != nil && state.run != nil {
+		return state.run.name
+	}
+	return ""
+}
+
+// loaderSession returns the loader session of the run, creating it.
+func (r *runState) loaderSession() *loaderSession {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.loaders == nil {
+		r.loaders = newLoaderSession()
+	}
+	return r.loaders
+}
+
+// flushLoaders flushes the loader session, if a Loader was used.
+func (r *runState) flushLoaders(ctx context.Context) {
+	r.mu.Lock()
+	loaders := r.loaders
+	r.mu.Unlock()
+	if loaders != nil {
+		loaders.flush(ctx)
+	}
+}
+
 // ValidateMulti executes the targets trees in 4 steps:
 // 1. Prepare the conditions for evaluation
 // 2. Evaluate the tree and get candidate rules
@@ -233,3 +408,49 @@ func joinErrors(errs []error) error {
 	}
 	return errors.Join(errs...)
 }
+
+type targetIsolationKey struct{}
+
+// WithTargetIsolation returns a context that makes ValidateMulti,
+// EvaluateMetricsMulti and their WithData variants isolate target failures.
+// By default a PrepareConditions error in any target aborts the whole batch.
+// With isolation, the failing target is skipped for the remaining phases and
+// reported with a TargetError carrying its index, while every other target
+// runs through all phases with batching intact.
+//
+// Example:
+//
+//	ctx := rules.WithTargetIsolation(ctx)
+//	reports, err := rules.EvaluateMetricsMultiWithData(ctx, targets, hooks, "import")
+//	for i, report := range reports {
+//	    if !report.Valid {
+//	        log.Printf("record %d rejected: %v", i, report.Errors)
+//	    }
+//	}
+func WithTargetIsolation(ctx context.Context) context.Context {
+	return context.WithValue(ctx, targetIsolationKey{}, true)
+}
+
+// targetIsolationFromContext reports whether target isolation is enabled.
+func targetIsolationFromContext(ctx context.Context) bool {
+	isolate, _ := ctx.Value(targetIsolationKey{}).(bool)
+	return isolate
+}
+
+// TargetError is the error of a target that failed before validation when
+// target isolation is enabled (see WithTargetIsolation). Index is the
+// position of the target in the slice passed to the engine.
+type TargetError struct {
+	Index int
+	Err   error
+}
+
+// Error implements the error interface.
+func (e TargetError) Error() string {
+	return fmt.Sprintf("target %d: %v", e.Index, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e TargetError) Unwrap() error {
+	return e.Err
+}
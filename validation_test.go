@@ -351,3 +351,85 @@ func TestValidateMulti_DoesNotMutateTargets(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateMulti_TargetIsolation(t *testing.T) {
+	t.Parallel()
+
+	prepareErr := errors.New("profile service down")
+	var batches [][]string
+	profiles := NewLoader(func(ctx context.Context, keys []string) (map[string]int, error) {
+		batches = append(batches, keys)
+		out := make(map[string]int, len(keys))
+		for _, k := range keys {
+			out[k] = len(k)
+		}
+		return out, nil
+	})
+
+	condition := NewTypedConditionWithPrepare("hasProfile",
+		func(ctx context.Context, u testUser) (Thunk[int], error) {
+			if u.Name == "broken" {
+				return nil, prepareErr
+			}
+			return profiles.Load(ctx, u.Name), nil
+		},
+		func(ctx context.Context, u testUser, profile Thunk[int]) bool {
+			_, err := profile()
+			return err == nil
+		})
+	tree := Node(condition, Rules(NewTypedRule("adult", func(ctx context.Context, u testUser) error {
+		if u.Age < 18 {
+			return Error{Field: "age", Err: "must be an adult", Code: "MINOR"}
+		}
+		return nil
+	})))
+	targets := []TreeAndData{
+		{Tree: tree, Data: testUser{Name: "alice", Age: 30}},
+		{Tree: tree, Data: testUser{Name: "broken", Age: 30}},
+		{Tree: tree, Data: testUser{Name: "bob", Age: 12}},
+	}
+
+	t.Run("default aborts the batch", func(t *testing.T) {
+		reports, err := EvaluateMetricsMultiWithData(context.Background(), targets, ProcessingHooks{}, "batch")
+		if !errors.Is(err, prepareErr) || reports != nil {
+			t.Errorf("got %v, %v, want the prepare error and no reports", reports, err)
+		}
+		var te TargetError
+		if errors.As(err, &te) {
+			t.Error("errors are not attributed without isolation")
+		}
+	})
+
+	t.Run("isolated", func(t *testing.T) {
+		batches = nil
+		ctx := WithTargetIsolation(context.Background())
+		reports, err := EvaluateMetricsMultiWithData(ctx, targets, ProcessingHooks{}, "batch")
+		if !errors.Is(err, prepareErr) {
+			t.Fatalf("expected the prepare error to surface, got %v", err)
+		}
+
+		if !reports[0].Valid || len(reports[0].Errors) != 0 {
+			t.Errorf("target 0: %+v, want valid", reports[0])
+		}
+
+		var te TargetError
+		if reports[1].Valid || len(reports[1].Errors) != 1 || !errors.As(reports[1].Errors[0], &te) {
+			t.Fatalf("target 1: %+v, want one TargetError", reports[1])
+		}
+		if te.Index != 1 || !errors.Is(te, prepareErr) {
+			t.Errorf("TargetError = %+v, want index 1 wrapping the prepare error", te)
+		}
+		if reports[1].Fingerprint == "" || reports[1].Fingerprint != reports[0].Fingerprint {
+			t.Error("failed target should still carry the tree fingerprint")
+		}
+
+		var re Error
+		if reports[2].Valid || len(reports[2].Errors) != 1 || !errors.As(reports[2].Errors[0], &re) || re.Code != "MINOR" {
+			t.Errorf("target 2: %+v, want MINOR", reports[2])
+		}
+
+		if len(batches) != 1 || len(batches[0]) != 2 {
+			t.Errorf("batches = %v, want the remaining targets in one batch", batches)
+		}
+	})
+}
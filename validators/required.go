@@ -0,0 +1,34 @@
+package validators
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/mishudark/rules"
+)
+
+// requiredValidator checks that value is not the zero value of its type. A nil
+// pointer, nil or empty slice or map, and an empty string are all missing.
+func requiredValidator(fieldName string, value any) error {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() || v.IsZero() || ((v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.Len() == 0) {
+		return rules.Error{
+			Field: fieldName,
+			Err:   "value is required",
+			Code:  "REQUIRED",
+		}
+	}
+	return nil
+}
+
+// Required creates a validation Rule that checks that a value is present,
+// i.e. not the zero value of its type. It is the presence check the format
+// validators (Email, URL, Slug, ...) leave out by treating empty values as
+// valid.
+func Required(fieldName string, value any) rules.Rule {
+	ruleName := fmt.Sprintf("RuleRequired[%s]", fieldName)
+
+	return rules.NewRulePure(ruleName, func() error {
+		return requiredValidator(fieldName, value)
+	})
+}
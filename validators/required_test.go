@@ -0,0 +1,47 @@
+package validators
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mishudark/rules"
+)
+
+func TestRequired(t *testing.T) {
+	name := "x"
+	testCases := []struct {
+		testName   string
+		value      any
+		expectFail bool
+	}{
+		{testName: "non-empty string", value: "hello"},
+		{testName: "non-zero int", value: 3},
+		{testName: "non-nil pointer", value: &name},
+		{testName: "non-empty slice", value: []int{1}},
+		{testName: "empty string", value: "", expectFail: true},
+		{testName: "zero int", value: 0, expectFail: true},
+		{testName: "nil", value: nil, expectFail: true},
+		{testName: "nil pointer", value: (*string)(nil), expectFail: true},
+		{testName: "empty slice", value: []int{}, expectFail: true},
+		{testName: "empty map", value: map[string]int{}, expectFail: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			err := Required("field", tc.value).Validate(context.Background())
+			if (err != nil) != tc.expectFail {
+				t.Fatalf("Required(%v) error = %v, expectFail %v", tc.value, err, tc.expectFail)
+			}
+			if err == nil {
+				return
+			}
+			re, ok := err.(rules.Error)
+			if !ok {
+				t.Fatalf("expected rules.Error, got %T", err)
+			}
+			if re.Code != "REQUIRED" || re.Field != "field" {
+				t.Errorf("got code %q field %q, want REQUIRED field", re.Code, re.Field)
+			}
+		})
+	}
+}
@@ -0,0 +1,506 @@
+package validators
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mishudark/rules"
+)
+
+// FieldRule builds the rule that checks a single field value. path is the
+// json-tag path of the field (e.g. "address.zip" or "items[2].sku") and is
+// used as the error Field.
+type FieldRule func(path string, value reflect.Value) rules.Rule
+
+// TagFactory builds the FieldRule for one struct tag. It runs once per field
+// while FromStruct builds the tree, receiving the tag parameter (the text
+// after "=", empty when absent) and the field type with pointers removed, so
+// parameters are parsed and types are checked up front rather than on every
+// validation.
+type TagFactory func(param string, fieldType reflect.Type) (FieldRule, error)
+
+var (
+	tagFactoriesMu sync.RWMutex
+	tagFactories   = map[string]TagFactory{
+		"required": requiredTag,
+		"min":      boundTag(true),
+		"max":      boundTag(false),
+		"email":    stringTag(func(path, value, param string) rules.Rule { return Email(path, value, splitParam(param)) }),
+		"url":      stringTag(func(path, value, param string) rules.Rule { return URL(value, splitParam(param)) }),
+		"slug":     stringTag(func(path, value, _ string) rules.Rule { return Slug(path, value) }),
+		"uslug":    stringTag(func(path, value, _ string) rules.Rule { return UnicodeSlug(path, value) }),
+		"domain":   stringTag(func(path, value, _ string) rules.Rule { return ValidDomainNameAdvanced(path, value, true) }),
+		"ipv4":     stringTag(func(_, value, _ string) rules.Rule { return IPv4Address(value) }),
+		"ipv6":     stringTag(func(_, value, _ string) rules.Rule { return IPv6Address(value) }),
+		"ip":       stringTag(func(_, value, _ string) rules.Rule { return IPv46Address(value) }),
+	}
+)
+
+// RegisterTag makes a custom tag name available to FromStruct. Registering an
+// existing name replaces it, which also allows overriding the built-in tags.
+// Trees already built by FromStruct are not affected.
+//
+// Example:
+//
+//	validators.RegisterTag("even", func(param string, t reflect.Type) (validators.FieldRule, error) {
+//	    if !t.ConvertibleTo(reflect.TypeFor[int64]()) {
+//	        return nil, fmt.Errorf("even: unsupported type %s", t)
+//	    }
+//	    return func(path string, v reflect.Value) rules.Rule {
+//	        return validators.StepValue(v.Int(), 2, 0)
+//	    }, nil
+//	})
+func RegisterTag(name string, factory TagFactory) {
+	tagFactoriesMu.Lock()
+	defer tagFactoriesMu.Unlock()
+	tagFactories[name] = factory
+}
+
+// lookupTag returns the factory registered for name.
+func lookupTag(name string) (TagFactory, bool) {
+	tagFactoriesMu.RLock()
+	defer tagFactoriesMu.RUnlock()
+	factory, ok := tagFactories[name]
+	return factory, ok
+}
+
+// FromStruct reflects over T once and builds a reusable tree that validates
+// values of type T read from the data registry, driven by `rules` struct
+// tags:
+//
+//	type Signup struct {
+//	    Email   string   `json:"email" rules:"required,email"`
+//	    Age     int      `json:"age" rules:"min=18,max=130"`
+//	    Website string   `json:"website" rules:"url=https"`
+//	    Tags    []string `json:"tags" rules:"max=5"`
+//	    Address Address  `json:"address"` // nested structs are recursed into
+//	}
+//
+// Built-in tags: required, min and max (value bounds for numbers, length
+// bounds for strings, slices and maps), email (optional "a.com|b.com" domain
+// allowlist), url (optional "https|http" scheme allowlist), slug, uslug,
+// domain, ipv4, ipv6 and ip. Format tags treat the empty string as valid,
+// following the package convention; combine them with required when the value
+// must be present. Custom tags are added with RegisterTag.
+//
+// Nested structs, pointers to structs and slices of structs are validated
+// recursively. Errors are rules.Error values whose Field is the json-tag path
+// of the offending value, e.g. "address.zip" or "items[2].sku". Each field
+// stops at its first failing tag.
+//
+// Embedded structs follow encoding/json: without a json name their fields
+// are promoted into the parent, so their paths read "zip" rather than
+// "Address.zip", and a promoted field is hidden by a field of the same name
+// closer to the parent. Fields behind a nil embedded pointer are skipped, as
+// encoding/json skips them.
+//
+// The tree holds one rule per top-level field and is safe to share across
+// goroutines. An unknown tag or an invalid tag parameter is reported when the
+// tree is built.
+//
+// Example:
+//
+//	tree, err := validators.FromStruct[Signup]()
+//	if err != nil {
+//	    return err
+//	}
+//	err = rules.ValidateWithData(ctx, tree, hooks, "signup", signup)
+func FromStruct[T any]() (rules.Evaluable, error) {
+	rootType := reflect.TypeFor[T]()
+	if derefType(rootType).Kind() != reflect.Struct {
+		return nil, fmt.Errorf("FromStruct: %s is not a struct", rootType)
+	}
+
+	plan, err := compileStruct(derefType(rootType), "", map[reflect.Type]bool{})
+	if err != nil {
+		return nil, err
+	}
+
+	fieldRules := make([]rules.Rule, 0, len(plan.fields))
+	for _, field := range plan.fields {
+		fieldRules = append(fieldRules, rules.NewTypedRule[T](
+			fmt.Sprintf("RuleStructField[%s]", field.name),
+			func(ctx context.Context, data T) error {
+				v := reflect.ValueOf(&data).Elem()
+				if v.Kind() == reflect.Pointer {
+					if v.IsNil() {
+						return nil
+					}
+					v = v.Elem()
+				}
+				return errors.Join(field.validate(ctx, "", v)...)
+			},
+		))
+	}
+
+	return rules.Rules(fieldRules...), nil
+}
+
+// structPlan is the compiled validation plan for a struct type.
+type structPlan struct {
+	fields []fieldPlan
+}
+
+// fieldPlan is the compiled validation plan for one struct field.
+type fieldPlan struct {
+	name   string // json name
+	index  []int  // through the embedded structs the field is promoted from
+	checks []tagCheck
+	nested *structPlan // struct or pointer-to-struct fields
+	elem   *structPlan // slice or array of structs (or pointers to structs)
+}
+
+// tagCheck is one compiled tag of a field.
+type tagCheck struct {
+	required bool // required also runs on nil pointers
+	rule     FieldRule
+}
+
+// compileStruct builds the plan for struct type t. visiting guards against
+// recursive types, which cannot be expanded statically.
+func compileStruct(t reflect.Type, prefix string, visiting map[reflect.Type]bool) (*structPlan, error) {
+	if visiting[t] {
+		return nil, fmt.Errorf("FromStruct: recursive type %s is not supported", t)
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	plan := &structPlan{}
+	for _, jf := range jsonFields(t) {
+		sf, name := jf.field, jf.name
+		field := fieldPlan{name: name, index: jf.index}
+		fieldType := derefType(sf.Type)
+
+		if tag := sf.Tag.Get("rules"); tag != "" {
+			checks, err := compileTags(tag, fieldType)
+			if err != nil {
+				return nil, fmt.Errorf("FromStruct: field %s%s: %w", prefix, name, err)
+			}
+			field.checks = checks
+		}
+
+		var err error
+		switch {
+		case fieldType.Kind() == reflect.Struct:
+			field.nested, err = compileStruct(fieldType, prefix+name+".", visiting)
+		case (fieldType.Kind() == reflect.Slice || fieldType.Kind() == reflect.Array) &&
+			derefType(fieldType.Elem()).Kind() == reflect.Struct:
+			field.elem, err = compileStruct(derefType(fieldType.Elem()), prefix+name+"[].", visiting)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(field.checks) > 0 || field.nested.hasChecks() || field.elem.hasChecks() {
+			plan.fields = append(plan.fields, field)
+		}
+	}
+
+	return plan, nil
+}
+
+// hasChecks reports whether the plan validates anything.
+func (p *structPlan) hasChecks() bool {
+	return p != nil && len(p.fields) > 0
+}
+
+// compileTags parses a comma-separated rules tag into checks.
+func compileTags(tag string, fieldType reflect.Type) ([]tagCheck, error) {
+	var checks []tagCheck
+	for part := range strings.SplitSeq(tag, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, param, _ := strings.Cut(part, "=")
+
+		factory, ok := lookupTag(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown tag %q", name)
+		}
+		rule, err := factory(param, fieldType)
+		if err != nil {
+			return nil, fmt.Errorf("tag %q: %w", name, err)
+		}
+		checks = append(checks, tagCheck{required: name == "required", rule: rule})
+	}
+	return checks, nil
+}
+
+// validate checks the field of the struct value v and everything nested under
+// it, returning every error found.
+func (f *fieldPlan) validate(ctx context.Context, prefix string, v reflect.Value) []error {
+	path := prefix + f.name
+	value, err := v.FieldByIndexErr(f.index)
+	if err != nil {
+		return nil // behind a nil embedded pointer
+	}
+
+	isNil := value.Kind() == reflect.Pointer && value.IsNil()
+	for _, check := range f.checks {
+		target := value
+		if !check.required {
+			if isNil {
+				continue
+			}
+			target = reflect.Indirect(value)
+		}
+		if err := check.rule(path, target).Validate(ctx); err != nil {
+			return []error{withField(err, path)}
+		}
+	}
+
+	if isNil {
+		return nil
+	}
+	value = reflect.Indirect(value)
+
+	var errs []error
+	if f.nested != nil {
+		errs = append(errs, f.nested.validate(ctx, path+".", value)...)
+	}
+	if f.elem != nil {
+		for i := range value.Len() {
+			elem := value.Index(i)
+			if elem.Kind() == reflect.Pointer {
+				if elem.IsNil() {
+					continue
+				}
+				elem = elem.Elem()
+			}
+			errs = append(errs, f.elem.validate(ctx, fmt.Sprintf("%s[%d].", path, i), elem)...)
+		}
+	}
+	return errs
+}
+
+// validate checks every field of the struct value v.
+func (p *structPlan) validate(ctx context.Context, prefix string, v reflect.Value) []error {
+	var errs []error
+	for i := range p.fields {
+		errs = append(errs, p.fields[i].validate(ctx, prefix, v)...)
+	}
+	return errs
+}
+
+// withField fills the Field of a rules.Error returned by validators that do
+// not take a field name, so every error carries the field path.
+func withField(err error, path string) error {
+	if re, ok := err.(rules.Error); ok && re.Field == "" {
+		re.Field = path
+		return re
+	}
+	return err
+}
+
+// jsonField is a field of a struct as encoding/json sees it.
+type jsonField struct {
+	field  reflect.StructField
+	name   string // json name
+	index  []int
+	tagged bool // the name comes from a json tag
+}
+
+// jsonFields returns the fields of struct type t that encoding/json encodes,
+// in index order: embedded structs without a json name are replaced by their
+// own fields, unexported fields are left out, except for embedded structs,
+// and so are fields tagged "-". Of the fields sharing a name, the shallowest
+// wins; fields at the same depth are all dropped unless exactly one of them
+// is tagged.
+func jsonFields(t reflect.Type) []jsonField {
+	var fields []jsonField
+	var walk func(t reflect.Type, index []int, embedding map[reflect.Type]bool)
+	walk = func(t reflect.Type, index []int, embedding map[reflect.Type]bool) {
+		for i := range t.NumField() {
+			sf := t.Field(i)
+			fieldType := derefType(sf.Type)
+			if sf.Anonymous {
+				if !sf.IsExported() && fieldType.Kind() != reflect.Struct {
+					continue
+				}
+			} else if !sf.IsExported() {
+				continue
+			}
+			tag := sf.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, _, _ := strings.Cut(tag, ",")
+			fieldIndex := append(slices.Clone(index), i)
+
+			if sf.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+				if !embedding[fieldType] { // a type embedding itself through a pointer
+					embedding[fieldType] = true
+					walk(fieldType, fieldIndex, embedding)
+					delete(embedding, fieldType)
+				}
+				continue
+			}
+			field := jsonField{field: sf, name: name, index: fieldIndex, tagged: name != ""}
+			if name == "" {
+				field.name = sf.Name
+			}
+			fields = append(fields, field)
+		}
+	}
+	walk(t, nil, map[reflect.Type]bool{t: true})
+
+	byName := make(map[string][]int) // positions in fields
+	for i, f := range fields {
+		byName[f.name] = append(byName[f.name], i)
+	}
+	kept := fields[:0:0]
+	for i, f := range fields {
+		if dominant(fields, byName[f.name]) == i {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// dominant returns the position of the field that wins among the fields at
+// positions, which share a name, or -1 when none does.
+func dominant(fields []jsonField, positions []int) int {
+	depth := len(fields[positions[0]].index)
+	for _, p := range positions {
+		depth = min(depth, len(fields[p].index))
+	}
+	winner, tagged, shallowest := -1, 0, 0
+	for _, p := range positions {
+		if len(fields[p].index) != depth {
+			continue
+		}
+		shallowest++
+		if fields[p].tagged {
+			winner = p
+			tagged++
+		} else if tagged == 0 {
+			winner = p
+		}
+	}
+	if shallowest > 1 && tagged != 1 {
+		return -1
+	}
+	return winner
+}
+
+// derefType removes any pointer indirection from t.
+func derefType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+// splitParam splits a "|"-separated tag parameter into a list.
+func splitParam(param string) []string {
+	if param == "" {
+		return nil
+	}
+	return strings.Split(param, "|")
+}
+
+// requiredTag builds the "required" check.
+func requiredTag(_ string, _ reflect.Type) (FieldRule, error) {
+	return func(path string, value reflect.Value) rules.Rule {
+		return Required(path, value.Interface())
+	}, nil
+}
+
+// stringTag adapts a string validator into a TagFactory. The empty string is
+// skipped, following the package convention that format validators do not
+// check presence.
+func stringTag(build func(path, value, param string) rules.Rule) TagFactory {
+	return func(param string, fieldType reflect.Type) (FieldRule, error) {
+		if fieldType.Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported type %s, want string", fieldType)
+		}
+		return func(path string, value reflect.Value) rules.Rule {
+			if value.String() == "" {
+				return &rules.NopRule{}
+			}
+			return build(path, value.String(), param)
+		}, nil
+	}
+}
+
+// boundTag builds the "min" (lower is true) or "max" check: a value bound for
+// numbers and a length bound for strings, slices, arrays and maps.
+func boundTag(lower bool) TagFactory {
+	return func(param string, fieldType reflect.Type) (FieldRule, error) {
+		switch fieldType.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			bound, err := strconv.ParseInt(param, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			return func(path string, v reflect.Value) rules.Rule {
+				if lower {
+					return MinValue(path, v.Int(), bound)
+				}
+				return MaxValue(path, v.Int(), bound)
+			}, nil
+
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			bound, err := strconv.ParseUint(param, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			return func(path string, v reflect.Value) rules.Rule {
+				if lower {
+					return MinValue(path, v.Uint(), bound)
+				}
+				return MaxValue(path, v.Uint(), bound)
+			}, nil
+
+		case reflect.Float32, reflect.Float64:
+			bound, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				return nil, err
+			}
+			return func(path string, v reflect.Value) rules.Rule {
+				if lower {
+					return MinValue(path, v.Float(), bound)
+				}
+				return MaxValue(path, v.Float(), bound)
+			}, nil
+
+		case reflect.String:
+			bound, err := strconv.Atoi(param)
+			if err != nil {
+				return nil, err
+			}
+			return func(path string, v reflect.Value) rules.Rule {
+				if lower {
+					return MinLengthString(path, v.String(), bound)
+				}
+				return MaxLengthString(path, v.String(), bound)
+			}, nil
+
+		case reflect.Slice, reflect.Array, reflect.Map:
+			bound, err := strconv.Atoi(param)
+			if err != nil {
+				return nil, err
+			}
+			return func(path string, v reflect.Value) rules.Rule {
+				// The slice validators only look at the length, so a
+				// zero-size placeholder of the same length stands in for
+				// the reflected value without copying its elements.
+				placeholder := make([]struct{}, v.Len())
+				if lower {
+					return MinLengthSlice(path, placeholder, bound)
+				}
+				return MaxLengthSlice(path, placeholder, bound)
+			}, nil
+
+		default:
+			return nil, fmt.Errorf("unsupported type %s", fieldType)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package validators
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/mishudark/rules"
+)
+
+type tagAddress struct {
+	Street string `json:"street" rules:"required"`
+	Zip    string `json:"zip" rules:"min=5,max=5"`
+}
+
+type tagItem struct {
+	SKU string `json:"sku" rules:"required,slug"`
+	Qty int    `json:"qty" rules:"min=1"`
+}
+
+type tagSignup struct {
+	Email    string      `json:"email" rules:"required,email"`
+	Age      int         `json:"age" rules:"min=18,max=130"`
+	Score    float64     `json:"score,omitempty" rules:"max=1"`
+	Website  string      `json:"website" rules:"url=https"`
+	IP       string      `json:"ip" rules:"ipv4"`
+	Tags     []string    `json:"tags" rules:"max=2"`
+	Address  tagAddress  `json:"address"`
+	Billing  *tagAddress `json:"billing"`
+	Items    []tagItem   `json:"items" rules:"required"`
+	Internal string      `json:"-" rules:"required"`
+	NoTag    string
+}
+
+func validSignup() tagSignup {
+	return tagSignup{
+		Email:   "ada@example.com",
+		Age:     36,
+		Website: "https://example.com",
+		IP:      "10.0.0.1",
+		Address: tagAddress{Street: "Main St", Zip: "12345"},
+		Items:   []tagItem{{SKU: "book-1", Qty: 1}},
+	}
+}
+
+// errorFields returns the Field of every rules.Error in err, sorted.
+func errorFields(err error) []string {
+	var fields []string
+	var walk func(error)
+	walk = func(err error) {
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, e := range joined.Unwrap() {
+				walk(e)
+			}
+			return
+		}
+		var re rules.Error
+		if errors.As(err, &re) {
+			fields = append(fields, re.Field)
+		}
+	}
+	if err != nil {
+		walk(err)
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+func TestFromStruct(t *testing.T) {
+	tree, err := FromStruct[tagSignup]()
+	if err != nil {
+		t.Fatalf("FromStruct: %v", err)
+	}
+
+	testCases := []struct {
+		testName   string
+		mutate     func(*tagSignup)
+		wantFields []string
+	}{
+		{testName: "valid", mutate: func(*tagSignup) {}},
+		{testName: "missing email", mutate: func(s *tagSignup) { s.Email = "" }, wantFields: []string{"email"}},
+		{testName: "invalid email", mutate: func(s *tagSignup) { s.Email = "nope" }, wantFields: []string{"email"}},
+		{testName: "too young", mutate: func(s *tagSignup) { s.Age = 12 }, wantFields: []string{"age"}},
+		{testName: "float bound", mutate: func(s *tagSignup) { s.Score = 1.5 }, wantFields: []string{"score"}},
+		{testName: "scheme not allowed", mutate: func(s *tagSignup) { s.Website = "http://example.com" }, wantFields: []string{"website"}},
+		{testName: "empty format field is valid", mutate: func(s *tagSignup) { s.Website = ""; s.IP = "" }},
+		{testName: "bad ip", mutate: func(s *tagSignup) { s.IP = "::1" }, wantFields: []string{"ip"}},
+		{testName: "too many tags", mutate: func(s *tagSignup) { s.Tags = []string{"a", "b", "c"} }, wantFields: []string{"tags"}},
+		{testName: "nested struct", mutate: func(s *tagSignup) { s.Address.Zip = "1" }, wantFields: []string{"address.zip"}},
+		{testName: "nil pointer struct skipped", mutate: func(s *tagSignup) { s.Billing = nil }},
+		{testName: "pointer struct", mutate: func(s *tagSignup) { s.Billing = &tagAddress{Zip: "12345"} }, wantFields: []string{"billing.street"}},
+		{testName: "missing items", mutate: func(s *tagSignup) { s.Items = nil }, wantFields: []string{"items"}},
+		{
+			testName: "slice elements",
+			mutate: func(s *tagSignup) {
+				s.Items = []tagItem{{SKU: "ok", Qty: 1}, {SKU: "not a slug", Qty: 0}}
+			},
+			wantFields: []string{"items[1].qty", "items[1].sku"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			signup := validSignup()
+			tc.mutate(&signup)
+
+			err := rules.ValidateWithData(context.Background(), tree, rules.ProcessingHooks{}, "signup", signup)
+			if got := errorFields(err); !slices.Equal(got, tc.wantFields) {
+				t.Errorf("error fields = %v, want %v (err: %v)", got, tc.wantFields, err)
+			}
+		})
+	}
+}
+
+func TestFromStruct_ReusableTree(t *testing.T) {
+	tree, err := FromStruct[tagItem]()
+	if err != nil {
+		t.Fatalf("FromStruct: %v", err)
+	}
+
+	targets := []rules.TreeAndData{
+		{Tree: tree, Data: tagItem{SKU: "a", Qty: 1}},
+		{Tree: tree, Data: tagItem{SKU: "b", Qty: 0}},
+		{Tree: tree, Data: tagItem{SKU: "", Qty: 2}},
+	}
+	err = rules.ValidateMultiWithData(context.Background(), targets, rules.ProcessingHooks{}, "items")
+	if got := errorFields(err); !slices.Equal(got, []string{"qty", "sku"}) {
+		t.Errorf("error fields = %v, want [qty sku]", got)
+	}
+}
+
+// tagAudit is embedded through a pointer.
+type tagAudit struct {
+	Owner string `json:"owner" rules:"required"`
+	Zip   string `json:"zip" rules:"required"`
+}
+
+type tagAccount struct {
+	tagAddress               // street and zip are promoted
+	*tagAudit                // owner is promoted
+	tagItem    `json:"item"` // named by its json tag, so not promoted
+	Zip        string        `json:"zip" rules:"min=3"` // hides the embedded zips
+}
+
+func TestFromStruct_Embedded(t *testing.T) {
+	tree, err := FromStruct[tagAccount]()
+	if err != nil {
+		t.Fatalf("FromStruct: %v", err)
+	}
+
+	testCases := []struct {
+		testName   string
+		mutate     func(*tagAccount)
+		wantFields []string
+	}{
+		{testName: "valid", mutate: func(*tagAccount) {}},
+		{testName: "promoted field", mutate: func(a *tagAccount) { a.Street = "" }, wantFields: []string{"street"}},
+		{testName: "shallower field wins", mutate: func(a *tagAccount) { a.Zip = "1" }, wantFields: []string{"zip"}},
+		{testName: "promoted through a pointer", mutate: func(a *tagAccount) { a.Owner = "" }, wantFields: []string{"owner"}},
+		{testName: "nil embedded pointer skipped", mutate: func(a *tagAccount) { a.tagAudit = nil }},
+		{testName: "embedded with a json name", mutate: func(a *tagAccount) { a.Qty = 0 }, wantFields: []string{"item.qty"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			account := tagAccount{
+				tagAddress: tagAddress{Street: "Main St", Zip: "1"},
+				tagAudit:   &tagAudit{Owner: "ops"},
+				tagItem:    tagItem{SKU: "book-1", Qty: 1},
+				Zip:        "123",
+			}
+			tc.mutate(&account)
+
+			err := rules.ValidateWithData(context.Background(), tree, rules.ProcessingHooks{}, "account", account)
+			if got := errorFields(err); !slices.Equal(got, tc.wantFields) {
+				t.Errorf("error fields = %v, want %v (err: %v)", got, tc.wantFields, err)
+			}
+		})
+	}
+}
+
+// tagNote and tagRemark both hold a Note at the same depth; tagTaggedNote
+// names its Note with a json tag.
+type (
+	tagNote       struct{ Note string }
+	tagRemark     struct{ Note string }
+	tagTaggedNote struct {
+		Note string `json:"Note"`
+	}
+)
+
+func TestJSONFields_Conflicts(t *testing.T) {
+	testCases := []struct {
+		testName string
+		typ      reflect.Type
+		want     []string
+	}{
+		{testName: "tie drops both", typ: reflect.TypeFor[struct {
+			tagNote
+			tagRemark
+			Zip string
+		}](), want: []string{"Zip[2]"}},
+		{testName: "tagged wins the tie", typ: reflect.TypeFor[struct {
+			tagNote
+			tagTaggedNote
+		}](), want: []string{"Note[1 0]"}},
+		{testName: "shallower wins", typ: reflect.TypeFor[struct {
+			tagNote
+			Note int
+		}](), want: []string{"Note[1]"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			var got []string
+			for _, f := range jsonFields(tc.typ) {
+				got = append(got, fmt.Sprint(f.name, f.index))
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("fields = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFromStruct_BuildErrors(t *testing.T) {
+	type unknownTag struct {
+		Name string `rules:"shiny"`
+	}
+	type badParam struct {
+		Age int `rules:"min=eighteen"`
+	}
+	type wrongType struct {
+		Age int `rules:"email"`
+	}
+
+	if _, err := FromStruct[unknownTag](); err == nil {
+		t.Error("expected error for unknown tag")
+	}
+	if _, err := FromStruct[badParam](); err == nil {
+		t.Error("expected error for invalid parameter")
+	}
+	if _, err := FromStruct[wrongType](); err == nil {
+		t.Error("expected error for unsupported type")
+	}
+	if _, err := FromStruct[int](); err == nil {
+		t.Error("expected error for non-struct type")
+	}
+}
+
+func TestRegisterTag(t *testing.T) {
+	RegisterTag("even", func(param string, fieldType reflect.Type) (FieldRule, error) {
+		if fieldType.Kind() != reflect.Int {
+			return nil, fmt.Errorf("unsupported type %s", fieldType)
+		}
+		return func(path string, v reflect.Value) rules.Rule {
+			return rules.NewRulePure("even", func() error {
+				if v.Int()%2 != 0 {
+					return rules.Error{Field: path, Err: "must be even", Code: "NOT_EVEN"}
+				}
+				return nil
+			})
+		}, nil
+	})
+
+	type pair struct {
+		Count int `json:"count" rules:"even"`
+	}
+	tree, err := FromStruct[pair]()
+	if err != nil {
+		t.Fatalf("FromStruct: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := rules.ValidateWithData(ctx, tree, rules.ProcessingHooks{}, "pair", pair{Count: 2}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err = rules.ValidateWithData(ctx, tree, rules.ProcessingHooks{}, "pair", pair{Count: 3})
+	var re rules.Error
+	if !errors.As(err, &re) || re.Code != "NOT_EVEN" || re.Field != "count" {
+		t.Errorf("expected NOT_EVEN on count, got %v", err)
+	}
+}